@@ -3,7 +3,8 @@ module github.com/Shikugawa/gpupipe
 go 1.16
 
 require (
-	github.com/google/uuid v1.2.0 // indirect
-	github.com/spf13/cobra v1.1.3 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/google/uuid v1.2.0
+	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,58 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	arrayId string
+
+	arrayCmd = &cobra.Command{
+		Use:   "array",
+		Short: "manage array jobs published with a sweep",
+	}
+
+	arrayListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list processes of an array job",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodGet, "arrays/"+url.PathEscape(arrayId), nil)
+		},
+	}
+
+	arrayCancelCmd = &cobra.Command{
+		Use:   "cancel",
+		Short: "delete every process of an array job",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodDelete, "arrays/"+url.PathEscape(arrayId), nil)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(arrayCmd)
+	arrayCmd.AddCommand(arrayListCmd)
+	arrayCmd.AddCommand(arrayCancelCmd)
+
+	arrayCmd.PersistentFlags().Int16VarP(&port, "port", "p", 8000, "server port")
+	arrayCmd.PersistentFlags().StringVar(&host, "host", "0.0.0.0", "server host")
+	arrayCmd.PersistentFlags().StringVar(&arrayId, "id", "", "array id")
+	arrayCmd.MarkPersistentFlagRequired("id")
+}
@@ -0,0 +1,176 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+var (
+	host string
+	port int16
+	// addressGiven is whether --host or --port was given, in which case
+	// gpiped is not looked for on its Unix socket.
+	addressGiven bool
+
+	tlsCA   string
+	tlsCert string
+	tlsKey  string
+)
+
+func apiUrl(scheme, path string) string {
+	return scheme + "://" + host + ":" + strconv.Itoa(int(port)) + "/v1/" + path
+}
+
+// tlsClient returns a client connecting to gpiped over TLS as configured by
+// c.
+func tlsClient(c *tlsConfig) (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(c.CA) != 0 {
+		b, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%s: no certificates found", c.CA)
+		}
+	}
+
+	if len(c.Cert) != 0 || len(c.Key) != 0 {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}, nil
+}
+
+// unixSocketClient returns a client connecting to the Unix socket at path
+// whatever the host of the URL is.
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// isSocket reports whether there is a Unix socket at path.
+func isSocket(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// sendRequest sends body, if any, as JSON to the /v1/ API and returns the
+// response. The caller must close its body.
+func sendRequest(method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, url := http.DefaultClient, apiUrl("http", path)
+	if !addressGiven && isSocket(config.Socket) {
+		client, url = unixSocketClient(config.Socket), "http://gpiped/v1/"+path
+	} else if config.TLS != nil {
+		if client, err = tlsClient(config.TLS); err != nil {
+			return nil, err
+		}
+		url = apiUrl("https", path)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(config.Token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+
+	return client.Do(req)
+}
+
+// request sends a request and prints the response.
+func request(method, path string, body interface{}) {
+	resp, err := sendRequest(method, path, body)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	defer resp.Body.Close()
+	printResponse(resp)
+}
+
+// printResponse prints an indented JSON body on success, "succeess" for an
+// empty one, and the error message otherwise.
+func printResponse(resp *http.Response) {
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		var errResp types.ErrorResponse
+		if err := json.Unmarshal(b, &errResp); err == nil && len(errResp.Error.Code) != 0 {
+			fmt.Printf("error: %s\n", errResp.Error.Message)
+		} else {
+			fmt.Printf("error: %s\n", resp.Status)
+		}
+		return
+	}
+
+	if len(b) == 0 {
+		fmt.Println("succeess")
+		return
+	}
+
+	var fixed bytes.Buffer
+	if err := json.Indent(&fixed, b, "", "\t"); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fixed.WriteTo(os.Stdout)
+	fmt.Println()
+}
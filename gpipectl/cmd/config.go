@@ -0,0 +1,107 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// configEnv names a config file to use instead of the default one.
+	configEnv = "GPIPECTL_CONFIG"
+	// tokenEnv overrides the token of the config file.
+	tokenEnv = "GPUPIPE_TOKEN"
+	// defaultSocketPath is where gpiped --socket is looked for if the config
+	// file names no socket.
+	defaultSocketPath = "/run/gpupipe.sock"
+)
+
+// clientConfig is read from the YAML file named by $GPIPECTL_CONFIG, or from
+// gpupipe/gpipectl.yaml in the user config directory, e.g.
+// ~/.config/gpupipe/gpipectl.yaml.
+type clientConfig struct {
+	// Token is sent as a bearer token to authenticate with gpiped.
+	Token string `yaml:"token"`
+	// Socket is the Unix socket of gpiped. It is used instead of --host and
+	// --port when it exists and neither is given.
+	Socket string `yaml:"socket"`
+	// TLS, if set, connects to gpiped over TLS.
+	TLS *tlsConfig `yaml:"tls"`
+}
+
+// tlsConfig names PEM files. Certificates of gpiped are verified against CA,
+// or the system CAs if it is empty. Cert and Key are the client certificate
+// sent to a gpiped which requires one.
+type tlsConfig struct {
+	CA   string `yaml:"ca"`
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+func configPath() string {
+	if path := os.Getenv(configEnv); len(path) != 0 {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gpupipe", "gpipectl.yaml")
+}
+
+// loadConfig reads the config file. A missing default config file is the
+// same as an empty one.
+func loadConfig() (clientConfig, error) {
+	var c clientConfig
+
+	path := configPath()
+	if len(path) != 0 {
+		b, err := ioutil.ReadFile(path)
+		if err != nil && (!os.IsNotExist(err) || len(os.Getenv(configEnv)) != 0) {
+			return c, err
+		}
+		if err := yaml.UnmarshalStrict(b, &c); err != nil {
+			return c, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	// Flags override the TLS settings of the file.
+	if len(tlsCA) != 0 || len(tlsCert) != 0 || len(tlsKey) != 0 {
+		if c.TLS == nil {
+			c.TLS = &tlsConfig{}
+		}
+		if len(tlsCA) != 0 {
+			c.TLS.CA = tlsCA
+		}
+		if len(tlsCert) != 0 {
+			c.TLS.Cert = tlsCert
+		}
+		if len(tlsKey) != 0 {
+			c.TLS.Key = tlsKey
+		}
+	}
+	if len(c.Socket) == 0 {
+		c.Socket = defaultSocketPath
+	}
+	if token := os.Getenv(tokenEnv); len(token) != 0 {
+		c.Token = token
+	}
+	return c, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gpipectl.yaml")
+	if err := ioutil.WriteFile(path, []byte("token: from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configEnv, path)
+	t.Setenv(tokenEnv, "")
+
+	if c, err := loadConfig(); err != nil || c.Token != "from-file" || c.Socket != defaultSocketPath {
+		t.Errorf("got %+v, %v", c, err)
+	}
+
+	t.Setenv(tokenEnv, "from-env")
+	if c, err := loadConfig(); err != nil || c.Token != "from-env" {
+		t.Errorf("environment does not override the file: got %+v, %v", c, err)
+	}
+
+	// A config file which was asked for explicitly must exist.
+	t.Setenv(configEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := loadConfig(); err == nil {
+		t.Error("missing config file was ignored")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("tokn: typo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configEnv, path)
+	if _, err := loadConfig(); err == nil {
+		t.Error("unknown field was accepted")
+	}
+}
+
+func TestLoadConfigTLS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gpipectl.yaml")
+	if err := ioutil.WriteFile(path, []byte("tls:\n  ca: file-ca.pem\n  cert: file-cert.pem\n  key: file-key.pem\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configEnv, path)
+	t.Setenv(tokenEnv, "")
+	defer func() { tlsCA, tlsCert, tlsKey = "", "", "" }()
+
+	c, err := loadConfig()
+	if err != nil || c.TLS == nil || *c.TLS != (tlsConfig{CA: "file-ca.pem", Cert: "file-cert.pem", Key: "file-key.pem"}) {
+		t.Fatalf("got %+v, %v", c.TLS, err)
+	}
+
+	// Flags override single settings of the file.
+	tlsCA = "flag-ca.pem"
+	c, err = loadConfig()
+	if err != nil || *c.TLS != (tlsConfig{CA: "flag-ca.pem", Cert: "file-cert.pem", Key: "file-key.pem"}) {
+		t.Errorf("got %+v, %v", c.TLS, err)
+	}
+
+	// and enable TLS on their own.
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err = loadConfig()
+	if err != nil || c.TLS == nil || *c.TLS != (tlsConfig{CA: "flag-ca.pem"}) {
+		t.Errorf("got %+v, %v", c.TLS, err)
+	}
+}
+
+func TestSendRequestOverSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "gpupipe.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *http.Request, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	path := filepath.Join(dir, "gpipectl.yaml")
+	if err := ioutil.WriteFile(path, []byte("token: secret\nsocket: "+socket+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(configEnv, path)
+	t.Setenv(tokenEnv, "")
+
+	addressGiven = false
+	resp, err := sendRequest(http.MethodGet, "jobs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	r := <-received
+	if r.URL.Path != "/v1/jobs" || r.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("got %s with Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+	}
+}
@@ -15,13 +15,9 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"strconv"
+	"net/url"
 
-	"github.com/Shikugawa/gpupipe/pkg/types"
 	"github.com/spf13/cobra"
 )
 
@@ -33,24 +29,7 @@ var (
 		Use:   "delete",
 		Short: "delete pending GPU process",
 		Run: func(cmd *cobra.Command, args []string) {
-			var request types.ProcessDeleteRequest
-			request.Id = id
-
-			requestRaw, _ := json.Marshal(request)
-			resp, err := http.Post("http://"+host+":"+strconv.Itoa(int(port))+"/delete", "application/json", bytes.NewBuffer(requestRaw))
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			defer resp.Body.Close()
-
-			if resp.StatusCode >= 400 {
-				fmt.Println("error")
-				return
-			}
-
-			fmt.Println("succeess")
+			request(http.MethodDelete, "jobs/"+url.PathEscape(id), nil)
 		},
 	}
 )
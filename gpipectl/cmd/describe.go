@@ -0,0 +1,127 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/spf13/cobra"
+)
+
+var (
+	describeCmd = &cobra.Command{
+		Use:   "describe <id>",
+		Short: "show details of a queued or finished GPU process",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			resp, err := sendRequest(http.MethodGet, "jobs/"+url.PathEscape(args[0]), nil)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 400 {
+				printResponse(resp)
+				return
+			}
+
+			var p process.Process
+			if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			describeProcess(&p)
+		},
+	}
+)
+
+func describeProcess(p *process.Process) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	field := func(name, value string) {
+		if len(value) != 0 {
+			fmt.Fprintf(w, "%s:\t%s\n", name, value)
+		}
+	}
+
+	state := process.ProcessStateToString(p.ProcessState)
+	if p.ProcessState == process.Finished || p.ProcessState == process.Failed {
+		state += fmt.Sprintf(" (exit code %d)", p.ExitCode)
+	}
+
+	field("Id", p.Id)
+	field("Name", p.Name)
+	field("User", p.User)
+	field("Owner", p.Owner)
+	field("State", state)
+	field("Exit Error", p.ExitError)
+	field("Command", strings.Join(p.Command, " "))
+	field("Root Path", p.RootPath)
+	field("GPUs", joinInts(p.GpuId))
+	if p.Pid != 0 {
+		field("PID", strconv.Itoa(p.Pid))
+	}
+	field("Attempts", strconv.Itoa(p.Attempts))
+	field("Issued", p.IssuedTime.Format(time.RFC3339))
+	if p.StartTime != nil {
+		field("Started", p.StartTime.Format(time.RFC3339))
+	}
+	if p.EndTime != nil {
+		field("Ended", p.EndTime.Format(time.RFC3339))
+	}
+	if p.StartTime != nil && p.EndTime != nil {
+		field("Runtime", p.EndTime.Sub(*p.StartTime).Round(time.Second).String())
+	}
+	field("Stdout Log", p.LogPath)
+	field("Stderr Log", p.ErrLogPath)
+	field("Workflow", p.WorkflowId)
+	field("Array", p.ArrayId)
+	for _, dep := range p.DependsOn {
+		field("Depends On", fmt.Sprintf("%s (%s)", dep.Job, dep.Condition))
+	}
+
+	fmt.Fprintln(w, "History:")
+	for _, t := range p.StateHistory {
+		fmt.Fprintf(w, "  %s\t%s\n", t.Time.Format(time.RFC3339), process.ProcessStateToString(t.State))
+	}
+}
+
+func joinInts(values []int) string {
+	var s []string
+	for _, v := range values {
+		s = append(s, strconv.Itoa(v))
+	}
+	return strings.Join(s, ", ")
+}
+
+func init() {
+	rootCmd.AddCommand(describeCmd)
+
+	describeCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
+	describeCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
+}
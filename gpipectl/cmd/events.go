@@ -0,0 +1,71 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	eventTypes string
+
+	eventsCmd = &cobra.Command{
+		Use:   "events",
+		Short: "print scheduler and GPU events as they happen, one JSON object per line",
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "events"
+			if len(eventTypes) != 0 {
+				path += "?type=" + url.QueryEscape(eventTypes)
+			}
+
+			resp, err := sendRequest(http.MethodGet, path, nil)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 400 {
+				printResponse(resp)
+				return
+			}
+
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+					fmt.Println(data)
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+
+	eventsCmd.Flags().StringVar(&eventTypes, "type", "", "comma separated event types to print, e.g. job.exited,gpu.free")
+	eventsCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
+	eventsCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
+}
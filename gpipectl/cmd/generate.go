@@ -15,46 +15,87 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 
 	"github.com/Shikugawa/gpupipe/pkg/types"
 	"github.com/spf13/cobra"
 )
 
 var (
+	showRendered bool
+
 	generateCmd = &cobra.Command{
 		Use:   "generate",
 		Short: "generate single line command from task definition",
 		Run: func(cmd *cobra.Command, args []string) {
-			target, err := ioutil.ReadFile(targetJson)
+			tasks, err := loadTaskFile(targetJson)
 			if err != nil {
 				fmt.Println(err)
 				return
 			}
 
-			var request types.ProcessPublishRequest
-			if err := json.NewDecoder(bytes.NewBuffer(target)).Decode(&request); err != nil {
-				fmt.Println(err)
-				return
+			for _, task := range tasks {
+				if showRendered {
+					printRendered(task.body)
+				} else {
+					printCommands(task.body)
+				}
 			}
+		},
+	}
+)
 
-			var targetCommand string
+func printCommands(target []byte) {
+	if isWorkflow(target) {
+		var workflow types.WorkflowPublishRequest
+		if err := types.DecodeStrict(target, &workflow); err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, step := range workflow.Steps {
+			fmt.Printf("%s: %s\n", step.Name, joinCommand(step.Command))
+		}
+		return
+	}
 
-			for _, unit := range request.Command {
-				targetCommand += unit + " "
-			}
+	var request types.ProcessPublishRequest
+	if err := types.DecodeStrict(target, &request); err != nil {
+		fmt.Println(err)
+		return
+	}
 
-			fmt.Println(targetCommand)
-		},
+	if request.Sweep == nil {
+		fmt.Println(joinCommand(request.Command))
+		return
 	}
-)
+
+	sets, err := request.Sweep.Expand()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for i, params := range sets {
+		instance := request.Instantiate(i, params)
+		fmt.Println(joinCommand(instance.Command))
+	}
+}
+
+func joinCommand(command []string) string {
+	var targetCommand string
+
+	for _, unit := range command {
+		targetCommand += unit + " "
+	}
+
+	return targetCommand
+}
 
 func init() {
 	rootCmd.AddCommand(generateCmd)
 
 	generateCmd.Flags().StringVar(&targetJson, "target", "", "target process")
+	generateCmd.Flags().BoolVar(&showRendered, "rendered", false, "print the rendered task definition instead of the command")
+	addTemplateFlags(generateCmd)
 	generateCmd.MarkFlagRequired("target")
 }
@@ -0,0 +1,162 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyState  string
+	historySince  string
+	historyUntil  string
+	historyUser   string
+	historyMine   bool
+	historyGpu    int
+	historyLimit  int
+	historyOffset int
+
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "query queued and finished GPU processes",
+		Example: `  # what ran on GPU 2 last night?
+  gpipectl history --gpu 2 --since "2021-06-01 20:00" --until "2021-06-02 08:00"`,
+		Run: func(cmd *cobra.Command, args []string) {
+			query := url.Values{}
+			if len(historyState) != 0 {
+				query.Set("state", historyState)
+			}
+			if len(historyUser) != 0 {
+				query.Set("user", historyUser)
+			}
+			if historyMine {
+				query.Set("mine", "true")
+			}
+			if historyGpu >= 0 {
+				query.Set("gpu", strconv.Itoa(historyGpu))
+			}
+			if historyLimit > 0 {
+				query.Set("limit", strconv.Itoa(historyLimit))
+			}
+			if historyOffset > 0 {
+				query.Set("offset", strconv.Itoa(historyOffset))
+			}
+			for name, v := range map[string]string{"since": historySince, "until": historyUntil} {
+				if len(v) == 0 {
+					continue
+				}
+				t, err := parseTimeFlag(v)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				query.Set(name, t.Format(time.RFC3339))
+			}
+
+			resp, err := sendRequest(http.MethodGet, "history?"+query.Encode(), nil)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 400 {
+				printResponse(resp)
+				return
+			}
+
+			var result struct {
+				Jobs  []process.Process `json:"jobs"`
+				Total int               `json:"total"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			printHistory(result.Jobs)
+			if shown := historyOffset + len(result.Jobs); shown < result.Total {
+				fmt.Printf("%d more, use --offset %d to see them\n", result.Total-shown, shown)
+			}
+		},
+	}
+)
+
+// parseTimeFlag accepts an RFC 3339 time, a local "2006-01-02 15:04" time, or
+// a duration meaning that long ago.
+func parseTimeFlag(v string) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("can't parse time %q", v)
+}
+
+func printHistory(jobs []process.Process) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	}
+
+	fmt.Fprintln(w, "ID\tNAME\tUSER\tSTATE\tGPUS\tSTARTED\tENDED\tCOMMAND")
+	for _, p := range jobs {
+		command := strings.Join(p.Command, " ")
+		if len(command) > 40 {
+			command = command[:37] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Id, p.Name, p.User, process.ProcessStateToString(p.ProcessState), joinInts(p.GpuId),
+			formatTime(p.StartTime), formatTime(p.EndTime), command)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
+	historyCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
+	historyCmd.Flags().StringVar(&historyState, "state", "", "comma separated states, e.g. finished,failed")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only processes running after this time, or this long ago like 12h")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "only processes running before this time, or this long ago like 12h")
+	historyCmd.Flags().StringVar(&historyUser, "user", "", "only processes submitted by this user")
+	historyCmd.Flags().BoolVar(&historyMine, "mine", false, "only processes owned by the user of the token")
+	historyCmd.Flags().IntVar(&historyGpu, "gpu", -1, "only processes using this GPU")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 50, "maximum number of processes to show")
+	historyCmd.Flags().IntVar(&historyOffset, "offset", 0, "number of processes to skip")
+}
@@ -15,42 +15,23 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"strconv"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	host string
-	port int16
+	listMine bool
 
 	listCmd = &cobra.Command{
 		Use:   "list",
 		Short: "get pending resouces in scheduler",
 		Run: func(cmd *cobra.Command, args []string) {
-			resp, err := http.Get("http://" + host + ":" + strconv.Itoa(int(port)) + "/list")
-			if err != nil {
-				fmt.Println(err)
-				return
+			path := "jobs"
+			if listMine {
+				path += "?mine=true"
 			}
-
-			defer resp.Body.Close()
-
-			b, _ := ioutil.ReadAll(resp.Body)
-			var fixed bytes.Buffer
-			if err := json.Indent(&fixed, b, "", "\t"); err != nil {
-				fmt.Println(err)
-				return
-			}
-
-			fixed.WriteTo(os.Stdout)
-			fmt.Println()
+			request(http.MethodGet, path, nil)
 		},
 	}
 )
@@ -58,6 +39,7 @@ var (
 func init() {
 	rootCmd.AddCommand(listCmd)
 
+	listCmd.Flags().BoolVar(&listMine, "mine", false, "only processes owned by the user of the token")
 	listCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
 	listCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
 }
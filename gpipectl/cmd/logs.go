@@ -0,0 +1,68 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	logsFollow bool
+	logsStream string
+
+	logsCmd = &cobra.Command{
+		Use:   "logs <id>",
+		Short: "print the output of a GPU process",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			values := url.Values{}
+			values.Set("stream", logsStream)
+			values.Set("follow", strconv.FormatBool(logsFollow))
+
+			resp, err := sendRequest(http.MethodGet, "jobs/"+url.PathEscape(args[0])+"/logs?"+values.Encode(), nil)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 400 {
+				printResponse(resp)
+				return
+			}
+
+			if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+				fmt.Println(err)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep printing output until the process terminates")
+	logsCmd.Flags().StringVar(&logsStream, "stream", "stdout", "output stream to print, stdout or stderr")
+	logsCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
+	logsCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
+}
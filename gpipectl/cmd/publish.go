@@ -15,12 +15,10 @@
 package cmd
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"strconv"
+	"path/filepath"
 
 	"github.com/Shikugawa/gpupipe/pkg/types"
 	"github.com/spf13/cobra"
@@ -33,43 +31,89 @@ var (
 		Use:   "publish",
 		Short: "publish GPU process",
 		Run: func(cmd *cobra.Command, args []string) {
-			target, err := ioutil.ReadFile(targetJson)
+			tasks, err := loadTaskFile(targetJson)
 			if err != nil {
 				fmt.Println(err)
 				return
 			}
 
-			var request types.ProcessPublishRequest
-			if err := json.NewDecoder(bytes.NewBuffer(target)).Decode(&request); err != nil {
-				fmt.Println(err)
-				return
+			for _, task := range tasks {
+				if isWorkflow(task.body) {
+					publishWorkflow(task.body, task.jobId)
+				} else {
+					publishTask(task.body, task.jobId)
+				}
 			}
+		},
+	}
+)
 
-			requestRaw, _ := json.Marshal(request)
-			resp, err := http.Post("http://"+host+":"+strconv.Itoa(int(port))+"/publish", "application/json", bytes.NewBuffer(requestRaw))
-			if err != nil {
-				fmt.Println(err)
-				return
-			}
+func publishTask(target []byte, id string) {
+	var req types.ProcessPublishRequest
+	if err := types.DecodeStrict(target, &req); err != nil {
+		fmt.Println(err)
+		return
+	}
 
-			defer resp.Body.Close()
+	// Publish with the id the template was rendered with, so that
+	// {{.JobId}} matches the id of the job.
+	if len(req.Id) == 0 {
+		req.Id = id
+	}
+	if len(req.User) == 0 {
+		req.User = currentUser()
+	}
 
-			if resp.StatusCode >= 400 {
-				fmt.Println("error")
-				return
-			}
+	request(http.MethodPost, "jobs", req)
+}
 
-			fmt.Println("succeess")
-		},
+// publishWorkflow submits a workflow spec, whose steps are queued together.
+func publishWorkflow(target []byte, id string) {
+	var req types.WorkflowPublishRequest
+	if err := types.DecodeStrict(target, &req); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if len(req.Id) == 0 {
+		req.Id = id
+	}
+	for i := range req.Steps {
+		if len(req.Steps[i].User) == 0 {
+			req.Steps[i].User = currentUser()
+		}
+	}
+
+	if err := req.Validate(); err != nil {
+		fmt.Println(err)
+		return
 	}
-)
+
+	request(http.MethodPost, "workflows", req)
+}
+
+// isWorkflow reports whether the task definition is a workflow spec, which
+// has steps instead of a command.
+func isWorkflow(target []byte) bool {
+	var spec struct {
+		Steps json.RawMessage `json:"steps"`
+	}
+	return json.Unmarshal(target, &spec) == nil && spec.Steps != nil
+}
+
+func isYaml(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
 
 func init() {
 	rootCmd.AddCommand(publishCmd)
 
 	publishCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
 	publishCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
-	publishCmd.Flags().StringVar(&targetJson, "target", "", "target process")
+	publishCmd.Flags().StringVar(&targetJson, "target", "", "target task definition or workflow spec, in JSON or YAML")
+
+	addTemplateFlags(publishCmd)
 
 	publishCmd.MarkFlagRequired("target")
 }
@@ -0,0 +1,46 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	quotaUser string
+
+	quotaCmd = &cobra.Command{
+		Use:   "quota",
+		Short: "show the quota of a user and what they use of it",
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "quota"
+			if len(quotaUser) != 0 {
+				path += "?user=" + url.QueryEscape(quotaUser)
+			}
+			request(http.MethodGet, path, nil)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(quotaCmd)
+
+	quotaCmd.Flags().StringVar(&quotaUser, "user", "", "user to show, the user of the token if empty")
+	quotaCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
+	quotaCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
+}
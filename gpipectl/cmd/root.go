@@ -25,6 +25,15 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "gpupipectl",
 	Short: "gpupiped controller",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		addressGiven = cmd.Flags().Changed("host") || cmd.Flags().Changed("port")
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&tlsCA, "cacert", "", "PEM CA certificates to verify gpiped with over TLS")
+	rootCmd.PersistentFlags().StringVar(&tlsCert, "cert", "", "PEM client certificate to present to gpiped over TLS")
+	rootCmd.PersistentFlags().StringVar(&tlsKey, "key", "", "PEM private key of the client certificate")
 }
 
 func Execute() {
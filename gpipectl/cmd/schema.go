@@ -0,0 +1,36 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	schemaCmd = &cobra.Command{
+		Use:   "schema",
+		Short: "print JSON Schema of task definitions for editor validation",
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(string(types.ProcessPublishRequestSchema))
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
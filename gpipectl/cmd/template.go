@@ -0,0 +1,243 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// templateData is what task files can refer to, e.g. {{.Date}} or
+// {{.Values.lr}} for a value given with --set lr=0.05.
+type templateData struct {
+	Date   string
+	Time   string
+	JobId  string
+	User   string
+	Values map[string]string
+}
+
+var setValues []string
+
+func newTemplateData() (*templateData, error) {
+	now := time.Now()
+	data := &templateData{
+		Date:   now.Format("2006-01-02"),
+		Time:   now.Format("150405"),
+		JobId:  uuid.NewString(),
+		Values: make(map[string]string),
+	}
+
+	data.User = currentUser()
+
+	for _, kv := range setValues {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("--set expects key=value, got %q", kv)
+		}
+		data.Values[kv[:i]] = kv[i+1:]
+	}
+	return data, nil
+}
+
+// renderedTask is one task definition after rendering, as JSON.
+type renderedTask struct {
+	body  []byte
+	jobId string
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// loadTaskFile renders every task definition in the file at path, merging in
+// the files they extend. A YAML file may hold several documents separated by
+// "---"; each is rendered on its own, so each gets its own {{.JobId}}.
+func loadTaskFile(path string) ([]renderedTask, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := [][]byte{raw}
+	if isYaml(path) {
+		documents = splitYamlDocuments(raw)
+	}
+
+	var tasks []renderedTask
+	for _, document := range documents {
+		data, err := newTemplateData()
+		if err != nil {
+			return nil, err
+		}
+
+		task, err := renderTask(path, document, data, make(map[string]bool))
+		if err != nil {
+			return nil, err
+		}
+		// Documents holding nothing but comments are skipped.
+		if task == nil {
+			continue
+		}
+
+		body, err := json.Marshal(task)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, renderedTask{body: body, jobId: data.JobId})
+	}
+
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("%s has no task definition", path)
+	}
+	return tasks, nil
+}
+
+// splitYamlDocuments splits raw at "---" document separators. It is done
+// before templating so that every document is rendered separately.
+func splitYamlDocuments(raw []byte) [][]byte {
+	var documents [][]byte
+	var current []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		trimmed := strings.TrimRight(line, " \t\r")
+		if trimmed == "---" || strings.HasPrefix(trimmed, "--- ") {
+			documents = append(documents, []byte(strings.Join(current, "\n")))
+			current = nil
+			if rest := strings.TrimSpace(strings.TrimPrefix(trimmed, "---")); len(rest) != 0 {
+				current = append(current, rest)
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	return append(documents, []byte(strings.Join(current, "\n")))
+}
+
+func loadTaskMap(path string, data *templateData, visiting map[string]bool) (map[string]interface{}, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := renderTask(path, raw, data, visiting)
+	if err != nil {
+		return nil, err
+	}
+	if task == nil {
+		return nil, fmt.Errorf("%s has no task definition", path)
+	}
+	return task, nil
+}
+
+func renderTask(path string, raw []byte, data *templateData, visiting map[string]bool) (map[string]interface{}, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if visiting[abs] {
+		return nil, fmt.Errorf("%s extends itself", path)
+	}
+	visiting[abs] = true
+	defer delete(visiting, abs)
+
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, err
+	}
+
+	body := rendered.Bytes()
+	if isYaml(path) {
+		if body, err = yamlToJson(body); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	var task map[string]interface{}
+	if err := json.Unmarshal(body, &task); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if task == nil {
+		return nil, nil
+	}
+
+	base, ok := task["extends"]
+	if !ok {
+		return task, nil
+	}
+	delete(task, "extends")
+
+	basePath, ok := base.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: extends must be a file path", path)
+	}
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(path), basePath)
+	}
+
+	baseTask, err := loadTaskMap(basePath, data, visiting)
+	if err != nil {
+		return nil, err
+	}
+	return mergeTask(baseTask, task), nil
+}
+
+// mergeTask overlays task onto base. Objects are merged key by key; any other
+// value, including arrays, replaces the one in base.
+func mergeTask(base, task map[string]interface{}) map[string]interface{} {
+	for k, v := range task {
+		baseObject, baseIsObject := base[k].(map[string]interface{})
+		object, isObject := v.(map[string]interface{})
+		if baseIsObject && isObject {
+			base[k] = mergeTask(baseObject, object)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+func addTemplateFlags(c *cobra.Command) {
+	c.Flags().StringArrayVar(&setValues, "set", nil, "template value given as key=value, available as {{.Values.key}}")
+}
+
+func printRendered(target []byte) {
+	var fixed bytes.Buffer
+	if err := json.Indent(&fixed, target, "", "\t"); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fixed.WriteTo(os.Stdout)
+	fmt.Println()
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestLoadTaskFileExtends(t *testing.T) {
+	setValues = []string{"steps=1000"}
+	defer func() { setValues = nil }()
+
+	tasks, err := loadTaskFile("../testdata/process_extends.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("got %d tasks, want 1", len(tasks))
+	}
+
+	var task map[string]interface{}
+	if err := json.Unmarshal(tasks[0].body, &task); err != nil {
+		t.Fatal(err)
+	}
+
+	if task["name"] != "xsum-1000" {
+		t.Errorf("got name %v", task["name"])
+	}
+	if task["rootpath"] != "/mnt/disk2/shimizu/PreSumm/src" {
+		t.Errorf("rootpath was not inherited: %v", task["rootpath"])
+	}
+	if logPath, _ := task["log_path"].(string); !strings.Contains(logPath, "/"+tasks[0].jobId+"/") {
+		t.Errorf("log_path %v doesn't contain job id %s", task["log_path"], tasks[0].jobId)
+	}
+	if want := []interface{}{float64(0)}; !reflect.DeepEqual(task["target_gpu"], want) {
+		t.Errorf("target_gpu was not replaced: %v", task["target_gpu"])
+	}
+	if _, ok := task["extends"]; ok {
+		t.Error("extends was kept in the merged task")
+	}
+}
+
+func writeTaskFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadTaskFileErrors(t *testing.T) {
+	dir := writeTaskFiles(t, map[string]string{
+		"a.json":       `{"extends": "b.json", "command": ["true"]}`,
+		"b.json":       `{"extends": "a.json"}`,
+		"missing.json": `{"command": ["echo", "{{.Values.undefined}}"]}`,
+		"number.json":  `{"extends": 1}`,
+	})
+
+	for name, want := range map[string]string{
+		"a.json":       "extends itself",
+		"missing.json": "undefined",
+		"number.json":  "must be a file path",
+	} {
+		_, err := loadTaskFile(filepath.Join(dir, name))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want an error containing %q", name, err, want)
+		}
+	}
+}
+
+func TestMergeTask(t *testing.T) {
+	base := map[string]interface{}{
+		"command": []interface{}{"python", "train.py"},
+		"env":     map[string]interface{}{"A": "1", "B": "2"},
+		"name":    "base",
+	}
+	task := map[string]interface{}{
+		"command": []interface{}{"python", "eval.py"},
+		"env":     map[string]interface{}{"B": "3"},
+	}
+
+	want := map[string]interface{}{
+		"command": []interface{}{"python", "eval.py"},
+		"env":     map[string]interface{}{"A": "1", "B": "3"},
+		"name":    "base",
+	}
+	if got := mergeTask(base, task); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewTemplateDataSetValues(t *testing.T) {
+	defer func() { setValues = nil }()
+
+	setValues = []string{"lr=0.05", "opts=a=b"}
+	data, err := newTemplateData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"lr": "0.05", "opts": "a=b"}; !reflect.DeepEqual(data.Values, want) {
+		t.Errorf("got %v, want %v", data.Values, want)
+	}
+
+	setValues = []string{"=0.05"}
+	if _, err := newTemplateData(); err == nil {
+		t.Error("--set without a key was accepted")
+	}
+}
+
+func TestLoadTaskFileYamlDocuments(t *testing.T) {
+	tasks, err := loadTaskFile("../testdata/processes.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(tasks))
+	}
+	if tasks[0].jobId == tasks[1].jobId {
+		t.Error("both documents were rendered with the same job id")
+	}
+
+	for i, want := range []string{"xsum-steps-10000", "xsum-steps-200000"} {
+		var request types.ProcessPublishRequest
+		if err := types.DecodeStrict(tasks[i].body, &request); err != nil {
+			t.Fatalf("document %d: %v", i, err)
+		}
+		if request.Name != want {
+			t.Errorf("document %d is named %s, want %s", i, request.Name, want)
+		}
+		if !strings.Contains(request.LogPath, tasks[i].jobId) {
+			t.Errorf("document %d logs to %s, not under its job id", i, request.LogPath)
+		}
+	}
+}
+
+func TestSplitYamlDocuments(t *testing.T) {
+	raw := "a: 1\n---\n# only a comment\n--- b: 2\nc: 3\n"
+
+	var got []string
+	for _, document := range splitYamlDocuments([]byte(raw)) {
+		got = append(got, string(document))
+	}
+	want := []string{"a: 1", "# only a comment", "b: 2\nc: 3\n"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoadTaskFileRejectsEmptyFile(t *testing.T) {
+	dir := writeTaskFiles(t, map[string]string{"empty.yaml": "# nothing\n---\n"})
+	if _, err := loadTaskFile(filepath.Join(dir, "empty.yaml")); err == nil {
+		t.Error("a file without task definitions was loaded")
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	webhooksCmd = &cobra.Command{
+		Use:   "webhooks",
+		Short: "inspect webhook notifications",
+	}
+
+	webhooksDeliveriesCmd = &cobra.Command{
+		Use:   "deliveries",
+		Short: "list recent webhook deliveries and whether they succeeded",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodGet, "webhooks/deliveries", nil)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(webhooksCmd)
+	webhooksCmd.AddCommand(webhooksDeliveriesCmd)
+
+	webhooksDeliveriesCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
+	webhooksDeliveriesCmd.Flags().StringVar(&host, "host", "0.0.0.0", "server host")
+}
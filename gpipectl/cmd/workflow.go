@@ -0,0 +1,79 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	workflowId string
+
+	workflowCmd = &cobra.Command{
+		Use:   "workflow",
+		Short: "manage workflows published from workflow spec files",
+	}
+
+	workflowListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list workflows and the state of their steps",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodGet, "workflows", nil)
+		},
+	}
+
+	workflowGetCmd = &cobra.Command{
+		Use:   "get",
+		Short: "show the state of the steps of a workflow",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodGet, "workflows/"+url.PathEscape(workflowId), nil)
+		},
+	}
+
+	workflowCancelCmd = &cobra.Command{
+		Use:   "cancel",
+		Short: "cancel every unfinished step of a workflow",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodDelete, "workflows/"+url.PathEscape(workflowId), nil)
+		},
+	}
+
+	workflowRetryCmd = &cobra.Command{
+		Use:   "retry",
+		Short: "rerun the steps of a workflow which did not succeed",
+		Run: func(cmd *cobra.Command, args []string) {
+			request(http.MethodPost, "workflows/"+url.PathEscape(workflowId)+"/retry", nil)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(workflowCmd)
+	workflowCmd.AddCommand(workflowListCmd)
+	workflowCmd.AddCommand(workflowGetCmd)
+	workflowCmd.AddCommand(workflowCancelCmd)
+	workflowCmd.AddCommand(workflowRetryCmd)
+
+	workflowCmd.PersistentFlags().Int16VarP(&port, "port", "p", 8000, "server port")
+	workflowCmd.PersistentFlags().StringVar(&host, "host", "0.0.0.0", "server host")
+
+	for _, c := range []*cobra.Command{workflowGetCmd, workflowCancelCmd, workflowRetryCmd} {
+		c.Flags().StringVar(&workflowId, "id", "", "workflow id")
+		c.MarkFlagRequired("id")
+	}
+}
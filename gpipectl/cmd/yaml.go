@@ -0,0 +1,69 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// yamlToJson converts a YAML document to JSON, so that the types in
+// pkg/types only have to carry json tags.
+func yamlToJson(in []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(in, &v); err != nil {
+		return nil, err
+	}
+
+	converted, err := convertYamlValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(converted)
+}
+
+// convertYamlValue replaces the map[interface{}]interface{} produced by yaml.v2
+// with map[string]interface{}, which encoding/json can marshal.
+func convertYamlValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported non-string key %v", key)
+			}
+			converted, err := convertYamlValue(value)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = converted
+		}
+		return m, nil
+	case []interface{}:
+		for i, value := range t {
+			converted, err := convertYamlValue(value)
+			if err != nil {
+				return nil, err
+			}
+			t[i] = converted
+		}
+		return t, nil
+	default:
+		return v, nil
+	}
+}
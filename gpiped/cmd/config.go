@@ -0,0 +1,147 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/config"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler/plugin"
+	"github.com/spf13/pflag"
+)
+
+// loadConfig reads --config, if given, applies the flags given on the command
+// line on top of it and validates the result. Files named by flags are read
+// again every time.
+func loadConfig(flags *pflag.FlagSet) (config.Config, error) {
+	c := config.Default()
+	if len(configPath) != 0 {
+		var err error
+		if c, err = config.Load(configPath); err != nil {
+			return c, err
+		}
+	}
+
+	changed := flags.Changed
+	if changed("port") {
+		c.Listen.Port = port
+	}
+	if changed("socket") {
+		c.Listen.Socket = socketPath
+		// A socket replaces the TCP port unless a port is asked for too.
+		if !changed("port") {
+			c.Listen.Port = 0
+		}
+	}
+	if changed("tls_cert") {
+		c.Listen.TLS.Cert = tlsCertFile
+	}
+	if changed("tls_key") {
+		c.Listen.TLS.Key = tlsKeyFile
+	}
+	if changed("client_ca") {
+		c.Listen.TLS.ClientCA = clientCAFile
+	}
+	if changed("queue_size") {
+		c.Scheduler.QueueSize = maxPendingQueueSize
+	}
+	if changed("default_memory_usage_low_watermark") {
+		c.Scheduler.DefaultMemoryUsageLowWatermark = defaultMemoryUsageLowWatermark
+	}
+	if changed("schedule_plugin") {
+		c.Scheduler.Plugin = schedulePlugin
+	}
+	if changed("fair_share_config") {
+		fairShare, err := plugin.LoadFairShareConfig(fairShareConfigPath)
+		if err != nil {
+			return c, err
+		}
+		c.Scheduler.FairShare = fairShare
+	}
+	if changed("run_as_users") {
+		c.Scheduler.RunAsUsers = runAsUsers
+	}
+	if changed("quota_config") {
+		quotas, err := quota.LoadConfig(quotaConfigPath)
+		if err != nil {
+			return c, err
+		}
+		c.Quotas = quotas
+	}
+	if changed("notify_config") {
+		n, err := notify.LoadConfig(notifyConfigPath)
+		if err != nil {
+			return c, err
+		}
+		c.Notify = n
+	}
+	if changed("request_interval") {
+		c.Gpu.RequestInterval = time.Duration(gpuInfoRequestInterval) * time.Second
+	}
+	if changed("history_size") {
+		c.History.Size = historySize
+	}
+	if changed("history_max_age") {
+		c.History.MaxAge = historyMaxAge
+	}
+	if changed("log_dir") {
+		c.Logs.Dir = logDirPath
+	}
+	if changed("log_rotate_size") {
+		c.Logs.RotateSize = logRotateSize
+	}
+	if changed("log_max_age") {
+		c.Logs.MaxAge = logMaxAge
+	}
+	if changed("log_max_total_size") {
+		c.Logs.MaxTotalSize = logMaxTotalSize
+	}
+	if changed("tokens_file") {
+		c.Auth.TokensFile = tokensFile
+	}
+	if changed("audit_log") {
+		c.Auth.AuditLog = auditLogPath
+	}
+
+	return c, c.Validate()
+}
+
+func newSchedulePlugin(c config.Scheduler) scheduler.SchedulePlugin {
+	if c.Plugin == config.PluginFairShare {
+		return plugin.NewFairSharePlugin(c.FairShare)
+	}
+	return plugin.NewFifoPlugin()
+}
+
+func newImpersonation(users []string) *process.Impersonation {
+	if len(users) == 0 {
+		return nil
+	}
+	return process.NewImpersonation(users)
+}
+
+// loadTokens reads the tokens file at path. Authentication is disabled
+// without one.
+func loadTokens(path string) (*auth.Tokens, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return auth.LoadTokens(path)
+}
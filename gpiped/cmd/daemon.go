@@ -0,0 +1,95 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"sync"
+
+	"github.com/Shikugawa/gpupipe/pkg/config"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler/plugin"
+	"github.com/Shikugawa/gpupipe/pkg/server"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/spf13/pflag"
+)
+
+// daemon reloads the configuration of a running gpiped.
+type daemon struct {
+	flags *pflag.FlagSet
+
+	mu sync.Mutex
+	// started is the configuration gpiped was started with, which settings
+	// needing a restart still have.
+	started config.Config
+	// config is the configuration in effect.
+	config config.Config
+
+	sched    *scheduler.Scheduler
+	notifier *notify.Notifier
+	server   *server.Server
+}
+
+// reload loads the configuration again and applies the settings which can
+// be changed while running. Nothing is applied if it is invalid. The tokens
+// file is read again even if its path did not change.
+func (d *daemon) reload() (types.ReloadResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, err := loadConfig(d.flags)
+	if err != nil {
+		return types.ReloadResponse{}, err
+	}
+	tokens, err := loadTokens(c.Auth.TokensFile)
+	if err != nil {
+		return types.ReloadResponse{}, err
+	}
+
+	applied, _ := config.Diff(&d.config, &c)
+	_, restartRequired := config.Diff(&d.started, &c)
+	res := types.ReloadResponse{
+		Applied:         append([]string{}, applied...),
+		RestartRequired: append([]string{}, restartRequired...),
+	}
+
+	previous := d.config.Scheduler
+	d.sched.Reconfigure(func() {
+		d.sched.MaxPendingQueueSize = c.Scheduler.QueueSize
+		d.sched.SetImpersonation(newImpersonation(c.Scheduler.RunAsUsers))
+		if c.Scheduler.Plugin != previous.Plugin {
+			d.sched.SetSchedulePlugin(newSchedulePlugin(c.Scheduler))
+		} else if fairShare, ok := d.sched.SchedulePlugin.(*plugin.FairSharePlugin); ok {
+			fairShare.SetConfig(c.Scheduler.FairShare)
+		}
+	})
+	d.sched.Quotas.SetConfig(c.Quotas)
+	d.sched.History.SetLimits(c.History.Size, c.History.MaxAge)
+	d.notifier.SetConfig(c.Notify)
+	d.server.SetTokens(tokens)
+
+	d.config = c
+	return res, nil
+}
+
+func (d *daemon) logReload() {
+	res, err := d.reload()
+	if err != nil {
+		log.Println("failed to reload configuration:", err)
+		return
+	}
+	log.Printf("reloaded configuration: applied %v, restart required for %v", res.Applied, res.RestartRequired)
+}
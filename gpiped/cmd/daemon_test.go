@@ -0,0 +1,174 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler/plugin"
+	"github.com/Shikugawa/gpupipe/pkg/server"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/spf13/pflag"
+)
+
+// newTestDaemon returns a daemon of a running scheduler configured by the
+// file it returns the path of.
+func newTestDaemon(t *testing.T, content string) (*daemon, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "gpiped.yaml")
+	writeConfig(t, path, content)
+	configPath = path
+	t.Cleanup(func() { configPath = "" })
+
+	flags := pflag.NewFlagSet("run", pflag.ContinueOnError)
+	c, err := loadConfig(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched := scheduler.NewScheduler(c.Scheduler.QueueSize, 3600, 0, newSchedulePlugin(c.Scheduler), history.NewStore(0, 0), nil)
+	sched.Quotas = quota.NewQuotas(c.Quotas)
+	sched.Impersonation = newImpersonation(c.Scheduler.RunAsUsers)
+	go sched.Run()
+	t.Cleanup(sched.TerminateAllActiveProcess)
+
+	n := notify.NewNotifier(c.Notify, sched.Events, sched.Get)
+	return &daemon{
+		flags:    flags,
+		started:  c,
+		config:   c,
+		sched:    sched,
+		notifier: n,
+		server:   server.NewServer(sched, n, nil, nil),
+	}, path
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	d, path := newTestDaemon(t, "listen:\n  port: 8000\n")
+
+	writeConfig(t, path, `
+listen:
+  port: 8080
+scheduler:
+  plugin: fair_share
+  queue_size: 20
+quotas:
+  default:
+    max_gpus: 1
+`)
+	res, err := d.reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"scheduler.plugin", "scheduler.queue_size", "quotas"}; !reflect.DeepEqual(res.Applied, want) {
+		t.Errorf("applied %v, want %v", res.Applied, want)
+	}
+	if want := []string{"listen"}; !reflect.DeepEqual(res.RestartRequired, want) {
+		t.Errorf("restart required for %v, want %v", res.RestartRequired, want)
+	}
+
+	var queueSize int
+	var schedulePlugin scheduler.SchedulePlugin
+	d.sched.Reconfigure(func() { queueSize, schedulePlugin = d.sched.MaxPendingQueueSize, d.sched.SchedulePlugin })
+	if queueSize != 20 {
+		t.Errorf("queue size %d, want 20", queueSize)
+	}
+	if _, ok := schedulePlugin.(*plugin.FairSharePlugin); !ok {
+		t.Errorf("plugin %T, want the fair-share one", schedulePlugin)
+	}
+	if limits := d.sched.Quotas.Limits("rei"); limits.MaxGpus != 1 {
+		t.Errorf("quota %+v", limits)
+	}
+
+	// Settings needing a restart are reported until gpiped is restarted,
+	// live ones only when they change.
+	res, err = d.reload()
+	if err != nil || len(res.Applied) != 0 || !reflect.DeepEqual(res.RestartRequired, []string{"listen"}) {
+		t.Errorf("reloading again: got %+v, %v", res, err)
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	d, path := newTestDaemon(t, "scheduler:\n  queue_size: 10\n")
+
+	writeConfig(t, path, "scheduler:\n  queue_size: 20\n  plugin: lifo\n")
+	if _, err := d.reload(); err == nil {
+		t.Fatal("invalid configuration was reloaded")
+	}
+
+	var queueSize int
+	d.sched.Reconfigure(func() { queueSize = d.sched.MaxPendingQueueSize })
+	if queueSize != 10 || d.config.Scheduler.QueueSize != 10 {
+		t.Errorf("part of an invalid configuration was applied: queue size %d", queueSize)
+	}
+}
+
+func TestReloadRunAsUsersWithQueuedJobs(t *testing.T) {
+	d, path := newTestDaemon(t, "scheduler:\n  run_as_users: [nobody, daemon]\n")
+
+	publish := func(owner string) string {
+		t.Helper()
+		// No GPU is ever sampled, so the job stays queued.
+		processes, err := d.sched.Publish(&types.ProcessPublishRequest{Owner: owner, Command: []string{"true"}, TargetGpu: []int{0}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return processes[0].Id
+	}
+	get := func(id string) process.Process {
+		t.Helper()
+		p, err := d.sched.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	nobody, daemon := publish("nobody"), publish("daemon")
+
+	writeConfig(t, path, "scheduler:\n  run_as_users: [daemon]\n")
+	if _, err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if p := get(nobody); p.ProcessState != process.Failed || len(p.ExitError) == 0 {
+		t.Errorf("job of a user no longer allowed: got %s, %q", process.ProcessStateToString(p.ProcessState), p.ExitError)
+	}
+	if p := get(daemon); p.ProcessState != process.Pending || p.RunAs != "daemon" {
+		t.Errorf("job of an allowed user: got %s as %q", process.ProcessStateToString(p.ProcessState), p.RunAs)
+	}
+
+	writeConfig(t, path, "")
+	if _, err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if p := get(daemon); p.ProcessState != process.Pending || len(p.RunAs) != 0 {
+		t.Errorf("job queued before impersonation was disabled: got %s as %q", process.ProcessStateToString(p.ProcessState), p.RunAs)
+	}
+}
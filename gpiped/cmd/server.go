@@ -23,31 +23,126 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/config"
+	"github.com/Shikugawa/gpupipe/pkg/gpu"
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/logs"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
 	"github.com/Shikugawa/gpupipe/pkg/scheduler"
-	"github.com/Shikugawa/gpupipe/pkg/scheduler/plugin"
 	"github.com/Shikugawa/gpupipe/pkg/server"
 	"github.com/spf13/cobra"
 )
 
+// logCleanupInterval is how often logs of finished processes are checked
+// against --log_max_age and --log_max_total_size.
+const logCleanupInterval = time.Minute
+
 var (
-	maxPendingQueueSize            int16
-	gpuInfoRequestInterval         int16
-	defaultMemoryUsageLowWatermark int8
-	port                           int16
+	configPath                     string
+	maxPendingQueueSize            int
+	gpuInfoRequestInterval         int
+	defaultMemoryUsageLowWatermark int
+	port                           int
+	historySize                    int
+	historyMaxAge                  time.Duration
+	logDirPath                     string
+	logRotateSize                  int
+	logMaxAge                      time.Duration
+	logMaxTotalSize                int
+	notifyConfigPath               string
+	tokensFile                     string
+	auditLogPath                   string
+	socketPath                     string
+	runAsUsers                     []string
+	tlsCertFile                    string
+	tlsKeyFile                     string
+	clientCAFile                   string
+	schedulePlugin                 string
+	fairShareConfigPath            string
+	quotaConfigPath                string
 
 	runCmd = &cobra.Command{
 		Use:   "run",
 		Short: "run gpiped server",
 		Run: func(cmd *cobra.Command, args []string) {
+			c, err := loadConfig(cmd.Flags())
+			if err != nil {
+				log.Fatalln(err)
+			}
+			gpu.NvidiaSmi = c.Gpu.NvidiaSmi
+
+			var logDir *logs.Dir
+			if len(c.Logs.Dir) != 0 {
+				logDir, err = logs.NewDir(c.Logs.Dir, int64(c.Logs.RotateSize)<<20, c.Logs.MaxAge, int64(c.Logs.MaxTotalSize)<<20)
+				if err != nil {
+					log.Fatalln(err)
+				}
+			}
+
 			sched := scheduler.NewScheduler(
-				int(maxPendingQueueSize), int(gpuInfoRequestInterval), int(defaultMemoryUsageLowWatermark), plugin.NewFifoPlugin())
+				c.Scheduler.QueueSize, int(c.Gpu.RequestInterval/time.Second), c.Scheduler.DefaultMemoryUsageLowWatermark,
+				newSchedulePlugin(c.Scheduler), history.NewStore(c.History.Size, c.History.MaxAge), logDir)
+			sched.Quotas = quota.NewQuotas(c.Quotas)
+			sched.Impersonation = newImpersonation(c.Scheduler.RunAsUsers)
 			go sched.Run()
 
-			srv := server.NewServer(sched).Start(strconv.Itoa(int(port)))
+			if logDir != nil {
+				go logDir.RunCleanup(logCleanupInterval, func() map[string]bool {
+					queued := make(map[string]bool)
+					for _, p := range sched.List() {
+						queued[p.Id] = true
+					}
+					return queued
+				})
+			}
+
+			notifier := notify.NewNotifier(c.Notify, sched.Events, sched.Get)
+			go notifier.Run()
+
+			tokens, err := loadTokens(c.Auth.TokensFile)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			auditLog := auth.NewAuditLog(os.Stderr)
+			if len(c.Auth.AuditLog) != 0 {
+				f, err := os.OpenFile(c.Auth.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				defer f.Close()
+				auditLog = auth.NewAuditLog(f)
+			}
+
+			listeners := server.Listeners{Socket: c.Listen.Socket}
+			if c.Listen.Port != 0 {
+				listeners.Port = strconv.Itoa(c.Listen.Port)
+			}
+			if len(c.Listen.TLS.Cert) != 0 {
+				if listeners.TLS, err = server.NewTLSConfig(c.Listen.TLS.Cert, c.Listen.TLS.Key, c.Listen.TLS.ClientCA); err != nil {
+					log.Fatalln(err)
+				}
+			}
+
+			s := server.NewServer(sched, notifier, tokens, auditLog)
+			d := &daemon{
+				flags:    cmd.Flags(),
+				started:  c,
+				config:   c,
+				sched:    sched,
+				notifier: notifier,
+				server:   s,
+			}
+			s.SetReloader(d.reload)
+			srv := s.Start(listeners)
 
 			sig := make(chan os.Signal, 1)
-			signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGABRT)
-			<-sig
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGINT, syscall.SIGABRT, syscall.SIGHUP)
+			for s := <-sig; s == syscall.SIGHUP; s = <-sig {
+				d.logReload()
+			}
 
 			sched.TerminateAllActiveProcess()
 
@@ -63,9 +158,29 @@ var (
 )
 
 func init() {
+	defaults := config.Default()
+
 	rootCmd.AddCommand(runCmd)
-	runCmd.Flags().Int16VarP(&port, "port", "p", 8000, "server port")
-	runCmd.Flags().Int16VarP(&maxPendingQueueSize, "queue_size", "q", 10, "the number of pending queue limit")
-	runCmd.Flags().Int8VarP(&defaultMemoryUsageLowWatermark, "default_memory_usage_low_watermark", "m", 10, "low usage watermark whether to issue or not GPU task")
-	runCmd.Flags().Int16VarP(&gpuInfoRequestInterval, "request_interval", "r", 5, "interval to request gpu usage for GPU watcher agent")
+	runCmd.Flags().StringVarP(&configPath, "config", "c", "", "YAML or JSON configuration file, reloaded on SIGHUP or POST /admin/reload; flags given on the command line override it")
+	runCmd.Flags().IntVarP(&port, "port", "p", defaults.Listen.Port, "server port")
+	runCmd.Flags().IntVarP(&maxPendingQueueSize, "queue_size", "q", defaults.Scheduler.QueueSize, "the number of pending queue limit")
+	runCmd.Flags().IntVarP(&defaultMemoryUsageLowWatermark, "default_memory_usage_low_watermark", "m", defaults.Scheduler.DefaultMemoryUsageLowWatermark, "low usage watermark whether to issue or not GPU task")
+	runCmd.Flags().IntVarP(&gpuInfoRequestInterval, "request_interval", "r", int(defaults.Gpu.RequestInterval/time.Second), "interval in seconds to request gpu usage for GPU watcher agent")
+	runCmd.Flags().StringVar(&schedulePlugin, "schedule_plugin", defaults.Scheduler.Plugin, "how the next process is selected, fifo or fair_share")
+	runCmd.Flags().StringVar(&fairShareConfigPath, "fair_share_config", "", "YAML or JSON file with the half-life of usage and the shares of users for the fair_share plugin")
+	runCmd.Flags().StringVar(&quotaConfigPath, "quota_config", "", "YAML or JSON file limiting the GPUs, pending jobs and daily GPU-hours of users and groups")
+	runCmd.Flags().IntVar(&historySize, "history_size", defaults.History.Size, "the number of finished processes kept in history")
+	runCmd.Flags().DurationVar(&historyMaxAge, "history_max_age", defaults.History.MaxAge, "how long finished processes are kept in history, 0 keeps them until history_size is exceeded")
+	runCmd.Flags().StringVar(&logDirPath, "log_dir", defaults.Logs.Dir, "directory where processes without log paths write <id>/stdout.log and <id>/stderr.log, empty keeps only the in-memory tail")
+	runCmd.Flags().IntVar(&logRotateSize, "log_rotate_size", defaults.Logs.RotateSize, "size in MiB at which log files are rotated and compressed when log_dir is set, 0 disables rotation")
+	runCmd.Flags().DurationVar(&logMaxAge, "log_max_age", defaults.Logs.MaxAge, "how long logs of finished processes are kept in log_dir, 0 keeps them")
+	runCmd.Flags().IntVar(&logMaxTotalSize, "log_max_total_size", defaults.Logs.MaxTotalSize, "size in MiB above which the oldest logs of finished processes are removed from log_dir, 0 disables the limit")
+	runCmd.Flags().StringVar(&notifyConfigPath, "notify_config", "", "YAML or JSON file configuring webhooks told about every process and the secret signing them")
+	runCmd.Flags().StringVar(&tokensFile, "tokens_file", defaults.Auth.TokensFile, "YAML or JSON file of API tokens with their users and roles, empty disables authentication")
+	runCmd.Flags().StringVar(&socketPath, "socket", defaults.Listen.Socket, "Unix socket to serve the API on, identifying callers as their Unix user; no TCP port is opened unless --port is given too")
+	runCmd.Flags().StringVar(&tlsCertFile, "tls_cert", "", "PEM certificate to serve the TCP port over TLS with")
+	runCmd.Flags().StringVar(&tlsKeyFile, "tls_key", "", "PEM private key of tls_cert")
+	runCmd.Flags().StringVar(&clientCAFile, "client_ca", "", "PEM CA certificates client certificates are verified against; clients must present one, whose common name is their user")
+	runCmd.Flags().StringSliceVar(&runAsUsers, "run_as_users", nil, "users whose processes are run as them rather than as the user of gpiped, * for every user but root; processes of other users are refused")
+	runCmd.Flags().StringVar(&auditLogPath, "audit_log", defaults.Auth.AuditLog, "file denied requests are appended to as JSON lines, empty writes them to stderr")
 }
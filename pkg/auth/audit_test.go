@@ -0,0 +1,40 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestAuditLogRecord(t *testing.T) {
+	var b bytes.Buffer
+	a := NewAuditLog(&b)
+	a.Record(AuditRecord{User: "rei", Role: RoleUser, Action: ActionModify, Method: "DELETE", Path: "/v1/jobs/x", Reason: "forbidden"})
+	a.Record(AuditRecord{Method: "GET", Path: "/v1/jobs", Reason: "no credentials"})
+
+	lines := bytes.Split(bytes.TrimSuffix(b.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines:\n%s", len(lines), b.String())
+	}
+	var first AuditRecord
+	if err := json.Unmarshal(lines[0], &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.User != "rei" || first.Action != ActionModify || first.Time.IsZero() {
+		t.Errorf("got %+v", first)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type Role string
+
+const (
+	// RoleAdmin may do anything, including to jobs of other users.
+	RoleAdmin Role = "admin"
+	// RoleUser may publish jobs and manage their own.
+	RoleUser Role = "user"
+	// RoleReadOnly may only look.
+	RoleReadOnly Role = "read-only"
+)
+
+func ValidateRole(r Role) error {
+	switch r {
+	case RoleAdmin, RoleUser, RoleReadOnly:
+		return nil
+	default:
+		return fmt.Errorf("unknown role %q", r)
+	}
+}
+
+// Identity is who sent a request.
+type Identity struct {
+	User string `json:"user"`
+	Role Role   `json:"role"`
+}
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// TokenEntry is one token of a tokens file. Either Token or its hex encoded
+// SHA-256 hash TokenSha256 is given, the latter keeping the file free of
+// usable secrets.
+type TokenEntry struct {
+	User        string `yaml:"user"`
+	Role        Role   `yaml:"role"`
+	Token       string `yaml:"token"`
+	TokenSha256 string `yaml:"token_sha256"`
+}
+
+type tokensFile struct {
+	Tokens []TokenEntry `yaml:"tokens"`
+}
+
+// Tokens authenticates requests by bearer tokens.
+type Tokens struct {
+	// byHash maps the SHA-256 hash of every token to its identity.
+	byHash map[[sha256.Size]byte]Identity
+	// byUser maps every user to the role of their first token.
+	byUser map[string]Role
+}
+
+// LoadTokens reads a YAML or JSON tokens file:
+//
+//	tokens:
+//	  - user: shimizu
+//	    role: admin
+//	    token_sha256: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+func LoadTokens(path string) (*Tokens, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f tokensFile
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	tokens, err := NewTokens(f.Tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return tokens, nil
+}
+
+func NewTokens(entries []TokenEntry) (*Tokens, error) {
+	t := &Tokens{
+		byHash: make(map[[sha256.Size]byte]Identity),
+		byUser: make(map[string]Role),
+	}
+
+	for i, e := range entries {
+		if len(e.User) == 0 {
+			return nil, fmt.Errorf("token %d has no user", i)
+		}
+		if err := ValidateRole(e.Role); err != nil {
+			return nil, fmt.Errorf("token of %s: %v", e.User, err)
+		}
+
+		var hash [sha256.Size]byte
+		switch {
+		case len(e.Token) != 0 && len(e.TokenSha256) != 0:
+			return nil, fmt.Errorf("token of %s has both token and token_sha256", e.User)
+		case len(e.Token) != 0:
+			hash = sha256.Sum256([]byte(e.Token))
+		case len(e.TokenSha256) != 0:
+			b, err := hex.DecodeString(e.TokenSha256)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("token of %s has an invalid token_sha256", e.User)
+			}
+			copy(hash[:], b)
+		default:
+			return nil, fmt.Errorf("token of %s has neither token nor token_sha256", e.User)
+		}
+
+		if _, ok := t.byHash[hash]; ok {
+			return nil, fmt.Errorf("token of %s is used more than once", e.User)
+		}
+		t.byHash[hash] = Identity{User: e.User, Role: e.Role}
+		if _, ok := t.byUser[e.User]; !ok {
+			t.byUser[e.User] = e.Role
+		}
+	}
+	return t, nil
+}
+
+// RoleOf returns the role of the first token of user.
+func (t *Tokens) RoleOf(user string) (Role, bool) {
+	role, ok := t.byUser[user]
+	return role, ok
+}
+
+// roleOrUser returns the role of the first token of user, or RoleUser if
+// user has no token. t may be nil.
+func (t *Tokens) roleOrUser(user string) Role {
+	if t == nil {
+		return RoleUser
+	}
+	if role, ok := t.RoleOf(user); ok {
+		return role
+	}
+	return RoleUser
+}
+
+// Authenticate returns the identity of the bearer token of r.
+func (t *Tokens) Authenticate(r *http.Request) (Identity, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) == 0 {
+		return Identity{}, ErrNoCredentials
+	}
+
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	// Tokens are looked up by their hash, so the timing of the lookup says
+	// nothing about the tokens themselves.
+	identity, ok := t.byHash[sha256.Sum256([]byte(strings.TrimSpace(header[len(prefix):])))]
+	if !ok {
+		return Identity{}, ErrInvalidCredentials
+	}
+	return identity, nil
+}
+
+type contextKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity of the request ctx belongs to. It is
+// false when authentication is disabled.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func hashOf(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestNewTokensRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []TokenEntry
+	}{
+		{"no user", []TokenEntry{{Role: RoleUser, Token: "a"}}},
+		{"unknown role", []TokenEntry{{User: "shimizu", Role: "root", Token: "a"}}},
+		{"no token", []TokenEntry{{User: "shimizu", Role: RoleUser}}},
+		{"both token and hash", []TokenEntry{{User: "shimizu", Role: RoleUser, Token: "a", TokenSha256: hashOf("a")}}},
+		{"invalid hash", []TokenEntry{{User: "shimizu", Role: RoleUser, TokenSha256: "abc"}}},
+		{"duplicate token", []TokenEntry{
+			{User: "shimizu", Role: RoleUser, Token: "a"},
+			{User: "rei", Role: RoleAdmin, TokenSha256: hashOf("a")},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewTokens(tc.entries); err == nil {
+				t.Error("invalid tokens were accepted")
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tokens, err := NewTokens([]TokenEntry{
+		{User: "shimizu", Role: RoleAdmin, Token: "plain"},
+		{User: "rei", Role: RoleReadOnly, TokenSha256: hashOf("hashed")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		header string
+		want   Identity
+		err    error
+	}{
+		{"Bearer plain", Identity{User: "shimizu", Role: RoleAdmin}, nil},
+		{"bearer hashed ", Identity{User: "rei", Role: RoleReadOnly}, nil},
+		{"", Identity{}, ErrNoCredentials},
+		{"Basic cGxhaW4=", Identity{}, ErrInvalidCredentials},
+		{"Bearer ", Identity{}, ErrInvalidCredentials},
+		{"Bearer other", Identity{}, ErrInvalidCredentials},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(tc.header) != 0 {
+			r.Header.Set("Authorization", tc.header)
+		}
+		got, err := tokens.Authenticate(r)
+		if err != tc.err || got != tc.want {
+			t.Errorf("%q: got %+v, %v, want %+v, %v", tc.header, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	content := "tokens:\n  - user: shimizu\n    role: user\n    token_sha256: " + hashOf("secret") + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := LoadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	if identity, err := tokens.Authenticate(r); err != nil || identity.User != "shimizu" {
+		t.Errorf("got %+v, %v", identity, err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("tokens:\n  - user: shimizu\n    secret: x\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTokens(path); err == nil {
+		t.Error("unknown field was accepted")
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	tokens, err := NewTokens([]TokenEntry{
+		{User: "shimizu", Role: RoleReadOnly, Token: "first"},
+		{User: "shimizu", Role: RoleAdmin, Token: "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role, ok := tokens.RoleOf("shimizu"); !ok || role != RoleReadOnly {
+		t.Errorf("got %s, %v, want the role of the first token", role, ok)
+	}
+	if _, ok := tokens.RoleOf("rei"); ok {
+		t.Error("user without tokens has a role")
+	}
+}
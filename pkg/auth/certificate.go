@@ -0,0 +1,34 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+)
+
+// ErrNoCommonName is returned for client certificates which name no user.
+var ErrNoCommonName = errors.New("client certificate has no common name")
+
+// CertificateIdentity identifies the sender of a verified client
+// certificate as the user named by its common name. The user has the role
+// of their token in tokens, if any, and RoleUser otherwise.
+func CertificateIdentity(cert *x509.Certificate, tokens *Tokens) (Identity, error) {
+	name := cert.Subject.CommonName
+	if len(name) == 0 {
+		return Identity{}, ErrNoCommonName
+	}
+	return Identity{User: name, Role: tokens.roleOrUser(name)}, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+)
+
+func TestCertificateIdentity(t *testing.T) {
+	tokens, err := NewTokens([]TokenEntry{{User: "shimizu", Role: RoleAdmin, Token: "admin-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		tokens *Tokens
+		want   Identity
+	}{
+		{name: "shimizu", tokens: tokens, want: Identity{User: "shimizu", Role: RoleAdmin}},
+		{name: "rei", tokens: tokens, want: Identity{User: "rei", Role: RoleUser}},
+		{name: "shimizu", want: Identity{User: "shimizu", Role: RoleUser}},
+	} {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: c.name}}
+		if got, err := CertificateIdentity(cert, c.tokens); err != nil || got != c.want {
+			t.Errorf("%s: got %+v, %v, want %+v", c.name, got, err, c.want)
+		}
+	}
+
+	if _, err := CertificateIdentity(&x509.Certificate{}, tokens); !errors.Is(err, ErrNoCommonName) {
+		t.Errorf("no common name: got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// ErrPeerCredentials is returned on platforms where the user on the other
+// side of a Unix socket can not be told.
+var ErrPeerCredentials = errors.New("peer credentials are not supported on this platform")
+
+// PeerIdentity identifies the Unix user connected to a Unix socket by the
+// credentials the kernel recorded for the connection. Root and the user
+// running gpiped are admins. Other users have the role of their token in
+// tokens, if any, and RoleUser otherwise.
+func PeerIdentity(conn *net.UnixConn, tokens *Tokens) (Identity, error) {
+	uid, err := peerUid(conn)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+
+	if uid == 0 || uid == os.Getuid() {
+		return Identity{User: name, Role: RoleAdmin}, nil
+	}
+	return Identity{User: name, Role: tokens.roleOrUser(name)}, nil
+}
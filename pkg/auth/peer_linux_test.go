@@ -0,0 +1,57 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package auth
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestPeerIdentity(t *testing.T) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The test connects to itself, and the user running gpiped is an admin
+	// whatever its tokens say.
+	tokens, err := NewTokens([]TokenEntry{{User: "nobody-else", Role: RoleReadOnly, Token: "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := PeerIdentity(conn, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Role != RoleAdmin || len(identity.User) == 0 {
+		t.Errorf("got %+v", identity)
+	}
+}
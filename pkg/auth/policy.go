@@ -0,0 +1,64 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Action is what a request does, as far as authorization is concerned.
+type Action string
+
+const (
+	// ActionRead looks at jobs, workflows and the daemon.
+	ActionRead Action = "read"
+	// ActionPublish creates jobs and workflows.
+	ActionPublish Action = "publish"
+	// ActionModify cancels, deletes or retries existing jobs and
+	// workflows.
+	ActionModify Action = "modify"
+	// ActionInspect looks at the output, environment and events of jobs,
+	// which are private to their owners.
+	ActionInspect Action = "inspect"
+	// ActionAdmin manages the daemon itself.
+	ActionAdmin Action = "admin"
+)
+
+var ErrForbidden = errors.New("forbidden")
+
+// Authorize decides whether identity may perform action on something owned
+// by owner. Anything may be read; admins may do anything; users may publish,
+// and modify and inspect what they own; read-only users may only read. Things
+// without an owner were published while authentication was disabled and can
+// only be modified and inspected by admins.
+func Authorize(identity Identity, action Action, owner string) error {
+	switch {
+	case action == ActionRead || identity.Role == RoleAdmin:
+		return nil
+	case identity.Role != RoleUser:
+		return fmt.Errorf("%w: %s users can only read", ErrForbidden, identity.Role)
+	case action == ActionPublish:
+		return nil
+	case action != ActionModify && action != ActionInspect:
+		return fmt.Errorf("%w: only admins can %s", ErrForbidden, action)
+	case len(owner) == 0:
+		return fmt.Errorf("%w: only admins can %s what has no owner", ErrForbidden, action)
+	case owner != identity.User:
+		return fmt.Errorf("%w: owned by %s", ErrForbidden, owner)
+	default:
+		return nil
+	}
+}
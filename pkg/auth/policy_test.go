@@ -0,0 +1,62 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	admin := Identity{User: "shimizu", Role: RoleAdmin}
+	user := Identity{User: "rei", Role: RoleUser}
+	readOnly := Identity{User: "viewer", Role: RoleReadOnly}
+
+	for _, tc := range []struct {
+		identity Identity
+		action   Action
+		owner    string
+		allowed  bool
+	}{
+		{admin, ActionRead, "rei", true},
+		{admin, ActionPublish, "", true},
+		{admin, ActionModify, "rei", true},
+		{admin, ActionModify, "", true},
+		{admin, ActionInspect, "rei", true},
+		{admin, ActionAdmin, "", true},
+		{user, ActionRead, "shimizu", true},
+		{user, ActionPublish, "", true},
+		{user, ActionModify, "rei", true},
+		{user, ActionModify, "shimizu", false},
+		{user, ActionModify, "", false},
+		{user, ActionInspect, "rei", true},
+		{user, ActionInspect, "shimizu", false},
+		{user, ActionInspect, "", false},
+		{user, ActionAdmin, "", false},
+		{readOnly, ActionRead, "rei", true},
+		{readOnly, ActionPublish, "", false},
+		{readOnly, ActionModify, "viewer", false},
+		{readOnly, ActionInspect, "viewer", false},
+		{readOnly, ActionAdmin, "", false},
+	} {
+		err := Authorize(tc.identity, tc.action, tc.owner)
+		if allowed := err == nil; allowed != tc.allowed {
+			t.Errorf("%s %s on %q: got %v", tc.identity.Role, tc.action, tc.owner, err)
+		}
+		if err != nil && !errors.Is(err, ErrForbidden) {
+			t.Errorf("%s %s on %q: %v is not ErrForbidden", tc.identity.Role, tc.action, tc.owner, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config is the configuration file of gpiped.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler/plugin"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PluginFifo      = "fifo"
+	PluginFairShare = "fair_share"
+
+	// BackendNvidiaSmi queries GPUs with nvidia-smi, the only backend so
+	// far.
+	BackendNvidiaSmi = "nvidia-smi"
+)
+
+// Config is every setting of gpiped, written in YAML or JSON. Settings
+// missing from the file keep their defaults.
+type Config struct {
+	Listen    Listen        `yaml:"listen"`
+	Scheduler Scheduler     `yaml:"scheduler"`
+	Quotas    quota.Config  `yaml:"quotas"`
+	Notify    notify.Config `yaml:"notify"`
+	Gpu       Gpu           `yaml:"gpu"`
+	History   History       `yaml:"history"`
+	Logs      Logs          `yaml:"logs"`
+	Auth      Auth          `yaml:"auth"`
+}
+
+type Listen struct {
+	// Port is the TCP port, 0 opens none.
+	Port int `yaml:"port"`
+	// Socket is the path of a Unix socket, empty opens none.
+	Socket string `yaml:"socket"`
+	TLS    TLS    `yaml:"tls"`
+}
+
+// TLS serves the TCP port over TLS if Cert and Key are set. ClientCA
+// additionally requires client certificates signed by its CAs.
+type TLS struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"client_ca"`
+}
+
+type Scheduler struct {
+	// Plugin is PluginFifo or PluginFairShare.
+	Plugin    string                 `yaml:"plugin"`
+	FairShare plugin.FairShareConfig `yaml:"fair_share"`
+	QueueSize int                    `yaml:"queue_size"`
+	// DefaultMemoryUsageLowWatermark is the memory usage in percent under
+	// which GPUs are free for processes which do not say.
+	DefaultMemoryUsageLowWatermark int `yaml:"default_memory_usage_low_watermark"`
+	// RunAsUsers are the users whose processes are run as them.
+	RunAsUsers []string `yaml:"run_as_users"`
+}
+
+type Gpu struct {
+	Backend string `yaml:"backend"`
+	// NvidiaSmi is the nvidia-smi command of BackendNvidiaSmi.
+	NvidiaSmi       string        `yaml:"nvidia_smi"`
+	RequestInterval time.Duration `yaml:"request_interval"`
+}
+
+type History struct {
+	Size   int           `yaml:"size"`
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+type Logs struct {
+	Dir string `yaml:"dir"`
+	// RotateSize and MaxTotalSize are in MiB.
+	RotateSize   int           `yaml:"rotate_size"`
+	MaxAge       time.Duration `yaml:"max_age"`
+	MaxTotalSize int           `yaml:"max_total_size"`
+}
+
+type Auth struct {
+	TokensFile string `yaml:"tokens_file"`
+	AuditLog   string `yaml:"audit_log"`
+}
+
+// Default returns the settings gpiped uses without a configuration file.
+func Default() Config {
+	return Config{
+		Listen: Listen{Port: 8000},
+		Scheduler: Scheduler{
+			Plugin:                         PluginFifo,
+			QueueSize:                      10,
+			DefaultMemoryUsageLowWatermark: 10,
+		},
+		Gpu: Gpu{
+			Backend:         BackendNvidiaSmi,
+			NvidiaSmi:       "nvidia-smi",
+			RequestInterval: 5 * time.Second,
+		},
+		History: History{Size: history.DefaultMaxCount},
+		Logs:    Logs{RotateSize: 100},
+	}
+}
+
+// Load reads path over the defaults. The result is not validated yet, so
+// that flags can be applied on top of it first.
+func Load(path string) (Config, error) {
+	c := Default()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	if err := c.Listen.Validate(); err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	if err := c.Scheduler.Validate(); err != nil {
+		return fmt.Errorf("scheduler: %v", err)
+	}
+	if err := c.Quotas.Validate(); err != nil {
+		return fmt.Errorf("quotas: %v", err)
+	}
+	if err := c.Notify.Validate(); err != nil {
+		return fmt.Errorf("notify: %v", err)
+	}
+	if err := c.Gpu.Validate(); err != nil {
+		return fmt.Errorf("gpu: %v", err)
+	}
+	if c.History.Size < 0 || c.History.MaxAge < 0 {
+		return fmt.Errorf("history: size and max_age must not be negative")
+	}
+	if c.Logs.RotateSize < 0 || c.Logs.MaxAge < 0 || c.Logs.MaxTotalSize < 0 {
+		return fmt.Errorf("logs: rotate_size, max_age and max_total_size must not be negative")
+	}
+	return nil
+}
+
+func (l *Listen) Validate() error {
+	if l.Port < 0 || l.Port > 65535 {
+		return fmt.Errorf("invalid port %d", l.Port)
+	}
+	if l.Port == 0 && len(l.Socket) == 0 {
+		return fmt.Errorf("neither a port nor a socket to listen on")
+	}
+	if (len(l.TLS.Cert) == 0) != (len(l.TLS.Key) == 0) {
+		return fmt.Errorf("tls: cert and key must be given together")
+	}
+	if len(l.TLS.ClientCA) != 0 && len(l.TLS.Cert) == 0 {
+		return fmt.Errorf("tls: client_ca requires cert and key")
+	}
+	return nil
+}
+
+func (s *Scheduler) Validate() error {
+	switch s.Plugin {
+	case PluginFifo, PluginFairShare:
+	default:
+		return fmt.Errorf("unknown plugin %q", s.Plugin)
+	}
+	if err := s.FairShare.Validate(); err != nil {
+		return fmt.Errorf("fair_share: %v", err)
+	}
+	if s.QueueSize <= 0 {
+		return fmt.Errorf("queue_size must be positive")
+	}
+	if s.DefaultMemoryUsageLowWatermark < 0 || s.DefaultMemoryUsageLowWatermark > 100 {
+		return fmt.Errorf("default_memory_usage_low_watermark must be between 0 and 100")
+	}
+	for _, user := range s.RunAsUsers {
+		if len(user) == 0 {
+			return fmt.Errorf("run_as_users must not contain empty users")
+		}
+	}
+	return nil
+}
+
+func (g *Gpu) Validate() error {
+	if g.Backend != BackendNvidiaSmi {
+		return fmt.Errorf("unknown backend %q", g.Backend)
+	}
+	if len(g.NvidiaSmi) == 0 {
+		return fmt.Errorf("nvidia_smi must not be empty")
+	}
+	if g.RequestInterval < time.Second {
+		return fmt.Errorf("request_interval must be at least 1s")
+	}
+	return nil
+}
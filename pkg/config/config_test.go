@@ -0,0 +1,101 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gpiped.yaml")
+	if err := ioutil.WriteFile(path, []byte("listen:\n  socket: /run/gpupipe.sock\nscheduler:\n  queue_size: 20\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.Listen.Socket = "/run/gpupipe.sock"
+	want.Scheduler.QueueSize = 20
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want the defaults with the settings of the file %+v", c, want)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("scheduler:\n  queue_sise: 20\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("unknown field was accepted")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if c := Default(); c.Validate() != nil {
+		t.Errorf("defaults are invalid: %v", c.Validate())
+	}
+
+	for _, c := range []struct {
+		name   string
+		modify func(c *Config)
+	}{
+		{"port out of range", func(c *Config) { c.Listen.Port = 65536 }},
+		{"nothing to listen on", func(c *Config) { c.Listen.Port = 0 }},
+		{"cert without key", func(c *Config) { c.Listen.TLS.Cert = "cert.pem" }},
+		{"client CA without cert", func(c *Config) { c.Listen.TLS.ClientCA = "ca.pem" }},
+		{"unknown plugin", func(c *Config) { c.Scheduler.Plugin = "lifo" }},
+		{"non-positive queue size", func(c *Config) { c.Scheduler.QueueSize = 0 }},
+		{"watermark over 100", func(c *Config) { c.Scheduler.DefaultMemoryUsageLowWatermark = 101 }},
+		{"empty run-as user", func(c *Config) { c.Scheduler.RunAsUsers = []string{""} }},
+		{"negative quota", func(c *Config) { c.Quotas.Default.MaxGpus = -1 }},
+		{"unknown GPU backend", func(c *Config) { c.Gpu.Backend = "rocm-smi" }},
+		{"request interval under a second", func(c *Config) { c.Gpu.RequestInterval = time.Millisecond }},
+		{"negative history size", func(c *Config) { c.History.Size = -1 }},
+		{"negative log size", func(c *Config) { c.Logs.MaxTotalSize = -1 }},
+	} {
+		config := Default()
+		c.modify(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: accepted", c.name)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := Default()
+	new := Default()
+	if live, restart := Diff(&old, &new); len(live) != 0 || len(restart) != 0 {
+		t.Errorf("equal configurations differ: %v, %v", live, restart)
+	}
+
+	new.Scheduler.QueueSize = 20
+	new.Quotas = quota.Config{Default: quota.Limits{MaxGpus: 1}}
+	new.Listen.Port = 8080
+	new.Logs.Dir = "/var/log/gpupipe"
+	live, restart := Diff(&old, &new)
+	if want := []string{"scheduler.queue_size", "quotas"}; !reflect.DeepEqual(live, want) {
+		t.Errorf("live: got %v, want %v", live, want)
+	}
+	if want := []string{"listen", "logs"}; !reflect.DeepEqual(restart, want) {
+		t.Errorf("restart: got %v, want %v", restart, want)
+	}
+}
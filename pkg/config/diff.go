@@ -0,0 +1,57 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "reflect"
+
+// setting is a part of the configuration compared on reload.
+type setting struct {
+	name string
+	// live is whether a running gpiped can apply a change of the setting.
+	live  bool
+	value func(c *Config) interface{}
+}
+
+var settings = []setting{
+	{"listen", false, func(c *Config) interface{} { return c.Listen }},
+	{"scheduler.plugin", true, func(c *Config) interface{} { return c.Scheduler.Plugin }},
+	{"scheduler.fair_share", true, func(c *Config) interface{} { return c.Scheduler.FairShare }},
+	{"scheduler.queue_size", true, func(c *Config) interface{} { return c.Scheduler.QueueSize }},
+	{"scheduler.default_memory_usage_low_watermark", false, func(c *Config) interface{} { return c.Scheduler.DefaultMemoryUsageLowWatermark }},
+	{"scheduler.run_as_users", true, func(c *Config) interface{} { return c.Scheduler.RunAsUsers }},
+	{"quotas", true, func(c *Config) interface{} { return c.Quotas }},
+	{"notify", true, func(c *Config) interface{} { return c.Notify }},
+	{"gpu", false, func(c *Config) interface{} { return c.Gpu }},
+	{"history", true, func(c *Config) interface{} { return c.History }},
+	{"logs", false, func(c *Config) interface{} { return c.Logs }},
+	{"auth.tokens_file", true, func(c *Config) interface{} { return c.Auth.TokensFile }},
+	{"auth.audit_log", false, func(c *Config) interface{} { return c.Auth.AuditLog }},
+}
+
+// Diff returns the settings which differ between old and new, split into the
+// ones a running gpiped applies and the ones which need a restart.
+func Diff(old, new *Config) (live, restart []string) {
+	for _, s := range settings {
+		if reflect.DeepEqual(s.value(old), s.value(new)) {
+			continue
+		}
+		if s.live {
+			live = append(live, s.name)
+		} else {
+			restart = append(restart, s.name)
+		}
+	}
+	return live, restart
+}
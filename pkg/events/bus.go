@@ -0,0 +1,131 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBacklogSize is how many past events a Bus keeps for subscribers
+// resuming after a disconnect.
+const DefaultBacklogSize = 1000
+
+type Subscription struct {
+	// C is closed when the subscription ends, either by Unsubscribe or
+	// because the subscriber fell more than its buffer size behind. In the
+	// latter case it can resume from the last event it received.
+	C <-chan Event
+
+	id int
+	ch chan Event
+}
+
+// Bus fans events out to subscribers and keeps a backlog of recent events so
+// that a subscriber can resume from the last event it has seen. Ids increase
+// by one from 1 for the lifetime of the Bus.
+type Bus struct {
+	mu          sync.Mutex
+	lastId      uint64
+	backlog     []Event
+	backlogSize int
+	nextSubId   int
+	subscribers map[int]*Subscription
+}
+
+func NewBus(backlogSize int) *Bus {
+	if backlogSize <= 0 {
+		backlogSize = DefaultBacklogSize
+	}
+	return &Bus{
+		backlogSize: backlogSize,
+		subscribers: make(map[int]*Subscription),
+	}
+}
+
+// Publish assigns the next id and the current time to e and delivers it. It
+// never blocks: a subscriber whose buffer is full is dropped.
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastId++
+	e.Id = b.lastId
+	e.Time = time.Now()
+
+	b.backlog = append(b.backlog, e)
+	if over := len(b.backlog) - b.backlogSize; over > 0 {
+		b.backlog = append(b.backlog[:0], b.backlog[over:]...)
+	}
+
+	for id, sub := range b.subscribers {
+		select {
+		case sub.ch <- e:
+		default:
+			delete(b.subscribers, id)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe registers a subscriber and returns the kept events with ids
+// greater than after, which precede anything delivered on the subscription.
+// An after greater than any id published so far, e.g. one from a previous
+// run of the daemon, replays nothing.
+func (b *Bus) Subscribe(after uint64, bufferSize int) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []Event
+	for _, e := range b.backlog {
+		if e.Id > after {
+			missed = append(missed, e)
+		}
+	}
+	return b.subscribe(bufferSize), missed
+}
+
+// SubscribeNew registers a subscriber which only receives the events
+// published from now on.
+func (b *Bus) SubscribeNew(bufferSize int) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.subscribe(bufferSize)
+}
+
+func (b *Bus) subscribe(bufferSize int) *Subscription {
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+
+	ch := make(chan Event, bufferSize)
+	sub := &Subscription{C: ch, id: b.nextSubId, ch: ch}
+	b.nextSubId++
+	b.subscribers[sub.id] = sub
+	return sub
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub.id]; !ok {
+		return
+	}
+	delete(b.subscribers, sub.id)
+	close(sub.ch)
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import "testing"
+
+func ids(events []Event) []uint64 {
+	var ids []uint64
+	for _, e := range events {
+		ids = append(ids, e.Id)
+	}
+	return ids
+}
+
+func equalIds(got, want []uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBusBacklog(t *testing.T) {
+	b := NewBus(3)
+	for i := 0; i < 5; i++ {
+		b.Publish(Event{Type: JobPublished})
+	}
+
+	for _, tc := range []struct {
+		after uint64
+		want  []uint64
+	}{
+		{0, []uint64{3, 4, 5}},
+		{3, []uint64{4, 5}},
+		{5, nil},
+		// Ids not published yet come from a previous run of the daemon.
+		{6, nil},
+	} {
+		sub, missed := b.Subscribe(tc.after, 1)
+		b.Unsubscribe(sub)
+		if got := ids(missed); !equalIds(got, tc.want) {
+			t.Errorf("after %d: got %v, want %v", tc.after, got, tc.want)
+		}
+	}
+}
+
+func TestBusDelivers(t *testing.T) {
+	b := NewBus(0)
+	sub, missed := b.Subscribe(0, 2)
+	if len(missed) != 0 {
+		t.Fatalf("got backlog %v from an empty bus", ids(missed))
+	}
+
+	b.Publish(Event{Type: JobPublished, JobId: "a"})
+	e := <-sub.C
+	if e.Id != 1 || e.JobId != "a" || e.Time.IsZero() {
+		t.Errorf("got %+v", e)
+	}
+
+	b.Unsubscribe(sub)
+	if _, ok := <-sub.C; ok {
+		t.Error("C is open after Unsubscribe")
+	}
+	// Unsubscribing twice is harmless.
+	b.Unsubscribe(sub)
+}
+
+func TestBusDropsSlowSubscriber(t *testing.T) {
+	b := NewBus(0)
+	slow, _ := b.Subscribe(0, 1)
+	fast, _ := b.Subscribe(0, 3)
+
+	// Publish never blocks, the subscriber which fell behind is dropped.
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: JobPublished})
+	}
+
+	var received []Event
+	for e := range slow.C {
+		received = append(received, e)
+	}
+	if got := ids(received); !equalIds(got, []uint64{1}) {
+		t.Errorf("slow subscriber got %v before being dropped", got)
+	}
+
+	// It resumes from the last event it received.
+	resumed, missed := b.Subscribe(1, 1)
+	defer b.Unsubscribe(resumed)
+	if got := ids(missed); !equalIds(got, []uint64{2, 3}) {
+		t.Errorf("resumed with %v", got)
+	}
+
+	b.Unsubscribe(fast)
+	received = nil
+	for e := range fast.C {
+		received = append(received, e)
+	}
+	if got := ids(received); !equalIds(got, []uint64{1, 2, 3}) {
+		t.Errorf("fast subscriber got %v", got)
+	}
+}
+
+func TestBusSubscribeNew(t *testing.T) {
+	b := NewBus(0)
+	b.Publish(Event{Type: JobPublished, JobId: "a"})
+
+	sub := b.SubscribeNew(1)
+	defer b.Unsubscribe(sub)
+	b.Publish(Event{Type: JobPublished, JobId: "b"})
+	if e := <-sub.C; e.JobId != "b" {
+		t.Errorf("got %+v, want the event published after subscribing", e)
+	}
+}
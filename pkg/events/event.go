@@ -0,0 +1,47 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import "time"
+
+type Type string
+
+const (
+	JobPublished       Type = "job.published"
+	JobStateChanged    Type = "job.state_changed"
+	JobSpawned         Type = "job.spawned"
+	JobExited          Type = "job.exited"
+	GpuFree            Type = "gpu.free"
+	GpuBusy            Type = "gpu.busy"
+	TelemetryDegraded  Type = "telemetry.degraded"
+	TelemetryRecovered Type = "telemetry.recovered"
+)
+
+// Event is something that happened in the daemon. Fields which do not apply
+// to Type are left empty.
+type Event struct {
+	Id            uint64    `json:"id"`
+	Type          Type      `json:"type"`
+	Time          time.Time `json:"time"`
+	JobId         string    `json:"job_id,omitempty"`
+	Name          string    `json:"name,omitempty"`
+	User          string    `json:"user,omitempty"`
+	Owner         string    `json:"owner,omitempty"`
+	State         string    `json:"state,omitempty"`
+	PreviousState string    `json:"previous_state,omitempty"`
+	GpuId         []int     `json:"gpu_id,omitempty"`
+	ExitCode      *int      `json:"exit_code,omitempty"`
+	Message       string    `json:"message,omitempty"`
+}
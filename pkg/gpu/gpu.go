@@ -22,6 +22,9 @@ import (
 	"strings"
 )
 
+// NvidiaSmi is the nvidia-smi command GPUs are queried with.
+var NvidiaSmi = "nvidia-smi"
+
 var query = []string{
 	"index",
 	"uuid",
@@ -47,7 +50,7 @@ type GpuInfo struct {
 }
 
 func GetGpuInfo() ([]GpuInfo, error) {
-	cmd := exec.Command("nvidia-smi", fmt.Sprintf("--query-gpu=%s", strings.Join(query, ",")), "--format=csv,noheader,nounits")
+	cmd := exec.Command(NvidiaSmi, fmt.Sprintf("--query-gpu=%s", strings.Join(query, ",")), "--format=csv,noheader,nounits")
 
 	var outbuf, errbuf bytes.Buffer
 	cmd.Stdout = &outbuf
@@ -0,0 +1,113 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// Query selects processes. Zero values match everything.
+type Query struct {
+	States []process.ProcessState
+	// Since and Until select processes which were running, or waiting to run,
+	// at some point between them.
+	Since time.Time
+	Until time.Time
+	User  string
+	Owner string
+	// Gpu selects processes using the GPU with this index. Negative values
+	// match every process.
+	Gpu    int
+	Offset int
+	Limit  int
+}
+
+func (q *Query) Match(p *process.Process) bool {
+	if len(q.States) != 0 {
+		matched := false
+		for _, state := range q.States {
+			if p.ProcessState == state {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if len(q.User) != 0 && p.User != q.User {
+		return false
+	}
+
+	if len(q.Owner) != 0 && p.Owner != q.Owner {
+		return false
+	}
+
+	if q.Gpu >= 0 {
+		matched := false
+		for _, id := range p.GpuId {
+			if id == q.Gpu {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	start := p.IssuedTime
+	if p.StartTime != nil {
+		start = *p.StartTime
+	}
+	if !q.Until.IsZero() && !start.Before(q.Until) {
+		return false
+	}
+	if !q.Since.IsZero() && p.EndTime != nil && p.EndTime.Before(q.Since) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the page of processes matching q, ordered by issued time, and
+// how many processes matched in total.
+func (q *Query) Apply(processes []process.Process) ([]process.Process, int) {
+	matched := make([]process.Process, 0)
+	for i := range processes {
+		if q.Match(&processes[i]) {
+			matched = append(matched, processes[i])
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].IssuedTime.Before(matched[j].IssuedTime)
+	})
+
+	total := len(matched)
+	if q.Offset > 0 {
+		if q.Offset >= len(matched) {
+			return matched[:0], total
+		}
+		matched = matched[q.Offset:]
+	}
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched, total
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+func TestQueryApply(t *testing.T) {
+	base := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := base.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+
+	processes := []process.Process{
+		// Issued in reverse order, so Apply has to sort them.
+		{Id: "c", User: "bob", Owner: "bob", GpuId: []int{2}, ProcessState: process.Pending, IssuedTime: *at(5)},
+		{Id: "b", User: "alice", GpuId: []int{1, 2}, ProcessState: process.Failed, IssuedTime: *at(2), StartTime: at(3), EndTime: at(4)},
+		{Id: "a", User: "alice", GpuId: []int{0}, ProcessState: process.Finished, IssuedTime: *at(0), StartTime: at(0), EndTime: at(1)},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query Query
+		want  []string
+		total int
+	}{
+		{"everything", Query{Gpu: -1}, []string{"a", "b", "c"}, 3},
+		{"state", Query{Gpu: -1, States: []process.ProcessState{process.Failed, process.Pending}}, []string{"b", "c"}, 2},
+		{"user", Query{Gpu: -1, User: "alice"}, []string{"a", "b"}, 2},
+		{"owner", Query{Gpu: -1, Owner: "bob"}, []string{"c"}, 1},
+		{"gpu", Query{Gpu: 2}, []string{"b", "c"}, 2},
+		{"ended before since", Query{Gpu: -1, Since: *at(2)}, []string{"b", "c"}, 2},
+		{"started after until", Query{Gpu: -1, Until: *at(3)}, []string{"a"}, 1},
+		{"running in window", Query{Gpu: -1, Since: *at(3), Until: *at(5)}, []string{"b"}, 1},
+		{"limit", Query{Gpu: -1, Limit: 2}, []string{"a", "b"}, 3},
+		{"offset", Query{Gpu: -1, Offset: 1, Limit: 1}, []string{"b"}, 3},
+		{"offset past end", Query{Gpu: -1, Offset: 5}, nil, 3},
+	} {
+		matched, total := tc.query.Apply(processes)
+
+		var ids []string
+		for _, p := range matched {
+			ids = append(ids, p.Id)
+		}
+		if !reflect.DeepEqual(ids, tc.want) || total != tc.total {
+			t.Errorf("%s: got %v of %d, want %v of %d", tc.name, ids, total, tc.want, tc.total)
+		}
+	}
+}
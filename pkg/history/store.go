@@ -0,0 +1,148 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// DefaultMaxCount is how many terminated processes a store keeps by default.
+const DefaultMaxCount = 1000
+
+// Store keeps terminated processes after the scheduler has removed them from
+// its queue. Records are dropped oldest first once there are more than
+// maxCount of them, or once they ended more than maxAge ago.
+type Store struct {
+	mu        sync.Mutex
+	maxCount  int
+	maxAge    time.Duration
+	processes []process.Process
+}
+
+// NewStore creates a store. A maxCount of 0 or less means DefaultMaxCount,
+// and a maxAge of 0 or less keeps records regardless of their age.
+func NewStore(maxCount int, maxAge time.Duration) *Store {
+	if maxCount <= 0 {
+		maxCount = DefaultMaxCount
+	}
+	return &Store{
+		maxCount: maxCount,
+		maxAge:   maxAge,
+	}
+}
+
+// SetLimits changes the limits of NewStore, pruning the records beyond them.
+func (s *Store) SetLimits(maxCount int, maxAge time.Duration) {
+	if maxCount <= 0 {
+		maxCount = DefaultMaxCount
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxCount = maxCount
+	s.maxAge = maxAge
+	s.prune()
+}
+
+func (s *Store) Add(p process.Process) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processes = append(s.processes, p)
+	s.prune()
+}
+
+func (s *Store) Get(id string) (process.Process, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	for i := len(s.processes) - 1; i >= 0; i-- {
+		if s.processes[i].Id == id {
+			return s.processes[i], true
+		}
+	}
+	return process.Process{}, false
+}
+
+// GetByName returns the latest record of a process with the given name.
+func (s *Store) GetByName(name string) (process.Process, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	for i := len(s.processes) - 1; i >= 0; i-- {
+		if s.processes[i].Name == name {
+			return s.processes[i], true
+		}
+	}
+	return process.Process{}, false
+}
+
+// HasId reports whether a record has id as its id or array id.
+func (s *Store) HasId(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	for i := range s.processes {
+		if s.processes[i].Id == id || s.processes[i].ArrayId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns every record, oldest first.
+func (s *Store) List() []process.Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	processes := make([]process.Process, len(s.processes))
+	copy(processes, s.processes)
+	return processes
+}
+
+func (s *Store) prune() {
+	drop := len(s.processes) - s.maxCount
+	if drop < 0 {
+		drop = 0
+	}
+
+	if s.maxAge > 0 {
+		deadline := time.Now().Add(-s.maxAge)
+		for drop < len(s.processes) && endTime(&s.processes[drop]).Before(deadline) {
+			drop++
+		}
+	}
+
+	if drop != 0 {
+		s.processes = append([]process.Process(nil), s.processes[drop:]...)
+	}
+}
+
+// endTime is when p terminated. Records are added in the order processes
+// leave the queue, which is close enough to this order for pruning.
+func endTime(p *process.Process) time.Time {
+	if p.EndTime != nil {
+		return *p.EndTime
+	}
+	return p.IssuedTime
+}
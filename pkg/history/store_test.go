@@ -0,0 +1,110 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+func TestStoreDropsOldest(t *testing.T) {
+	s := NewStore(2, 0)
+	for _, id := range []string{"a", "b", "c"} {
+		s.Add(process.Process{Id: id})
+	}
+
+	var ids []string
+	for _, p := range s.List() {
+		ids = append(ids, p.Id)
+	}
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Errorf("got %v, want [b c]", ids)
+	}
+	if _, ok := s.Get("a"); ok {
+		t.Error("the oldest record was kept")
+	}
+}
+
+func TestStoreGetReturnsLatest(t *testing.T) {
+	s := NewStore(0, 0)
+	s.Add(process.Process{Id: "a", ProcessState: process.Failed})
+	s.Add(process.Process{Id: "a", ProcessState: process.Finished})
+
+	p, ok := s.Get("a")
+	if !ok {
+		t.Fatal("a is not in the store")
+	}
+	if p.ProcessState != process.Finished {
+		t.Errorf("got the record in state %s", process.ProcessStateToString(p.ProcessState))
+	}
+}
+
+func TestStoreDropsExpired(t *testing.T) {
+	s := NewStore(0, time.Hour)
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now().Add(-time.Minute)
+
+	s.Add(process.Process{Id: "old", EndTime: &old})
+	s.Add(process.Process{Id: "recent", EndTime: &recent})
+
+	if _, ok := s.Get("old"); ok {
+		t.Error("a record older than the maximum age was kept")
+	}
+	if _, ok := s.Get("recent"); !ok {
+		t.Error("a recent record was dropped")
+	}
+}
+
+func TestStoreSetLimits(t *testing.T) {
+	s := NewStore(0, 0)
+	old := time.Now().Add(-2 * time.Hour)
+	recent := time.Now()
+	s.Add(process.Process{Id: "old", EndTime: &old})
+	for _, id := range []string{"a", "b", "c"} {
+		s.Add(process.Process{Id: id, EndTime: &recent})
+	}
+
+	s.SetLimits(2, time.Hour)
+	var ids []string
+	for _, p := range s.List() {
+		ids = append(ids, p.Id)
+	}
+	if len(ids) != 2 || ids[0] != "b" || ids[1] != "c" {
+		t.Errorf("got %v, want [b c]", ids)
+	}
+
+	// Zero keeps the default number of records.
+	s.SetLimits(0, 0)
+	for _, id := range []string{"d", "e"} {
+		s.Add(process.Process{Id: id, EndTime: &recent})
+	}
+	if n := len(s.List()); n != 4 {
+		t.Errorf("got %d records, want 4", n)
+	}
+}
+
+func TestStoreHasId(t *testing.T) {
+	s := NewStore(0, 0)
+	s.Add(process.Process{Id: "a", IssuedTime: time.Now()})
+	s.Add(process.Process{Id: "b", ArrayId: "array", IssuedTime: time.Now()})
+
+	for id, want := range map[string]bool{"a": true, "b": true, "array": true, "c": false} {
+		if got := s.HasId(id); got != want {
+			t.Errorf("%s: got %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import "sync"
+
+// DefaultTailSize is how many bytes of output a Buffer keeps by default.
+const DefaultTailSize = 64 * 1024
+
+// Buffer keeps the last bytes written to it and lets readers follow what is
+// written after them. It is safe for concurrent use.
+type Buffer struct {
+	mu      sync.Mutex
+	maxSize int
+	data    []byte
+	// end is the offset, counted from the very first byte ever written, of
+	// the byte following data.
+	end    int64
+	closed bool
+	// written is closed and replaced whenever data is appended or the
+	// buffer is closed.
+	written chan struct{}
+}
+
+func NewBuffer(maxSize int) *Buffer {
+	if maxSize <= 0 {
+		maxSize = DefaultTailSize
+	}
+	return &Buffer{
+		maxSize: maxSize,
+		written: make(chan struct{}),
+	}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data = append(b.data, p...)
+	if over := len(b.data) - b.maxSize; over > 0 {
+		b.data = append(b.data[:0], b.data[over:]...)
+	}
+	b.end += int64(len(p))
+	b.notify()
+	return len(p), nil
+}
+
+// Close tells followers that no more output is expected. Closing a nil
+// Buffer does nothing.
+func (b *Buffer) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		b.notify()
+	}
+}
+
+func (b *Buffer) notify() {
+	close(b.written)
+	b.written = make(chan struct{})
+}
+
+// Read returns what has been written since offset, or since the oldest byte
+// still kept if offset is older than that, along with the offset to continue
+// from. wait is closed once there is more to read, and closed reports whether
+// the buffer was closed.
+func (b *Buffer) Read(offset int64) (data []byte, next int64, wait <-chan struct{}, closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := b.end - int64(len(b.data))
+	if offset < start {
+		offset = start
+	}
+	if offset < b.end {
+		data = make([]byte, b.end-offset)
+		copy(data, b.data[offset-start:])
+	}
+	return data, b.end, b.written, b.closed
+}
+
+// Bytes returns every byte still kept.
+func (b *Buffer) Bytes() []byte {
+	data, _, _, _ := b.Read(0)
+	return data
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import "testing"
+
+func TestBufferKeepsTail(t *testing.T) {
+	b := NewBuffer(4)
+	b.Write([]byte("abc"))
+	b.Write([]byte("def"))
+
+	if got := string(b.Bytes()); got != "cdef" {
+		t.Errorf("got %q, want %q", got, "cdef")
+	}
+
+	for _, tc := range []struct {
+		offset int64
+		want   string
+	}{
+		// Offsets older than the tail start at the oldest byte kept.
+		{0, "cdef"},
+		{4, "ef"},
+		{6, ""},
+	} {
+		data, next, _, _ := b.Read(tc.offset)
+		if string(data) != tc.want || next != 6 {
+			t.Errorf("Read(%d) = %q, %d; want %q, 6", tc.offset, data, next, tc.want)
+		}
+	}
+}
+
+func TestBufferWakesFollowers(t *testing.T) {
+	b := NewBuffer(0)
+
+	_, next, wait, closed := b.Read(0)
+	if closed {
+		t.Fatal("a new buffer is closed")
+	}
+	select {
+	case <-wait:
+		t.Fatal("woken up before anything was written")
+	default:
+	}
+
+	b.Write([]byte("line\n"))
+	select {
+	case <-wait:
+	default:
+		t.Fatal("not woken up by a write")
+	}
+
+	data, _, wait, _ := b.Read(next)
+	if string(data) != "line\n" {
+		t.Errorf("got %q", data)
+	}
+
+	b.Close()
+	select {
+	case <-wait:
+	default:
+		t.Fatal("not woken up by Close")
+	}
+	if _, _, _, closed := b.Read(0); !closed {
+		t.Error("Close didn't close the buffer")
+	}
+	// Closing twice is fine.
+	b.Close()
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	StdoutFileName = "stdout.log"
+	StderrFileName = "stderr.log"
+)
+
+// Dir is the directory where jobs without explicit log paths write their
+// output, one <id>/ subdirectory per job.
+type Dir struct {
+	Path string
+	// MaxFileSize is the size at which log files are rotated, 0 disables
+	// rotation.
+	MaxFileSize int64
+	// MaxAge removes the logs of jobs which have not written anything for
+	// longer than this, 0 keeps them.
+	MaxAge time.Duration
+	// MaxTotalSize removes the logs of the least recently written jobs
+	// until the logs of finished jobs fit in it, 0 disables the limit.
+	MaxTotalSize int64
+}
+
+func NewDir(path string, maxFileSize int64, maxAge time.Duration, maxTotalSize int64) (*Dir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{
+		Path:         path,
+		MaxFileSize:  maxFileSize,
+		MaxAge:       maxAge,
+		MaxTotalSize: maxTotalSize,
+	}, nil
+}
+
+// JobLogPaths returns the default stdout and stderr log paths of a job.
+func (d *Dir) JobLogPaths(id string) (string, string) {
+	return filepath.Join(d.Path, id, StdoutFileName), filepath.Join(d.Path, id, StderrFileName)
+}
+
+// Open opens a log file for appending, rotating it at MaxFileSize.
+func (d *Dir) Open(path string) (io.WriteCloser, error) {
+	return OpenRotatingFile(path, d.MaxFileSize)
+}
+
+type jobLogs struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Cleanup removes the logs of finished jobs which are older than MaxAge,
+// then the least recently written ones while they exceed MaxTotalSize. Jobs
+// in inUse are never removed.
+func (d *Dir) Cleanup(inUse map[string]bool) {
+	if d.MaxAge <= 0 && d.MaxTotalSize <= 0 {
+		return
+	}
+
+	entries, err := ioutil.ReadDir(d.Path)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var finished []jobLogs
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() || inUse[entry.Name()] {
+			continue
+		}
+
+		job := jobLogs{path: filepath.Join(d.Path, entry.Name()), modTime: entry.ModTime()}
+		filepath.Walk(job.path, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.Mode().IsRegular() {
+				job.size += info.Size()
+			}
+			if info.ModTime().After(job.modTime) {
+				job.modTime = info.ModTime()
+			}
+			return nil
+		})
+		finished = append(finished, job)
+		total += job.size
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].modTime.Before(finished[j].modTime)
+	})
+
+	now := time.Now()
+	for _, job := range finished {
+		expired := d.MaxAge > 0 && now.Sub(job.modTime) > d.MaxAge
+		overflow := d.MaxTotalSize > 0 && total > d.MaxTotalSize
+		if !expired && !overflow {
+			continue
+		}
+
+		if err := os.RemoveAll(job.path); err != nil {
+			log.Println(err)
+			continue
+		}
+		total -= job.size
+	}
+}
+
+// RunCleanup calls Cleanup every interval. inUse returns the ids of jobs
+// which may still write logs.
+func (d *Dir) RunCleanup(interval time.Duration, inUse func() map[string]bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.Cleanup(inUse())
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeJobLogs creates the log directory of job id holding size bytes, last
+// written at modTime.
+func writeJobLogs(t *testing.T, d *Dir, id string, size int, modTime time.Time) {
+	t.Helper()
+	stdout, _ := d.JobLogPaths(id)
+	if err := os.MkdirAll(filepath.Dir(stdout), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stdout, []byte(strings.Repeat("x", size)), FileMode); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{stdout, filepath.Dir(stdout)} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func remainingJobs(t *testing.T, d *Dir) map[string]bool {
+	t.Helper()
+	entries, err := ioutil.ReadDir(d.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := make(map[string]bool)
+	for _, entry := range entries {
+		remaining[entry.Name()] = true
+	}
+	return remaining
+}
+
+func TestDirCleanup(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name         string
+		maxAge       time.Duration
+		maxTotalSize int64
+		want         []string
+	}{
+		{"no limits", 0, 0, []string{"old", "recent", "running"}},
+		{"max age", time.Hour, 0, []string{"recent", "running"}},
+		{"max total size", 0, 15, []string{"recent", "running"}},
+		{"max total size keeps what fits", 0, 20, []string{"old", "recent", "running"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := NewDir(t.TempDir(), 0, tc.maxAge, tc.maxTotalSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeJobLogs(t, d, "old", 10, now.Add(-2*time.Hour))
+			writeJobLogs(t, d, "recent", 10, now)
+			// Running jobs are neither removed nor counted.
+			writeJobLogs(t, d, "running", 100, now.Add(-3*time.Hour))
+
+			d.Cleanup(map[string]bool{"running": true})
+
+			remaining := remainingJobs(t, d)
+			if len(remaining) != len(tc.want) {
+				t.Errorf("remaining %v, want %v", remaining, tc.want)
+			}
+			for _, id := range tc.want {
+				if !remaining[id] {
+					t.Errorf("%s was removed", id)
+				}
+			}
+		})
+	}
+}
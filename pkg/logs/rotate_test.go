@@ -0,0 +1,107 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logs
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job", "stdout.log")
+	f, err := OpenRotatingFile(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"one\n", "two\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{path, path + ".1.gz"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode&^FileMode != 0 {
+			t.Errorf("%s has mode %v, want at most %v", name, mode, FileMode)
+		}
+	}
+}
+
+func TestRotatingFileSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdout.log")
+	f, err := OpenRotatingFile(path, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each write overflows the limit together with the previous one, so
+	// every write but the first starts a new segment.
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		path + ".1.gz": "first\n",
+		path + ".2.gz": "second\n",
+	} {
+		if got := readGzip(t, name); got != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+	if got, err := ioutil.ReadFile(path); err != nil || string(got) != "third\n" {
+		t.Errorf("current segment holds %q, %v", got, err)
+	}
+	for _, name := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("uncompressed %s is left: %v", name, err)
+		}
+	}
+
+	// Reopening continues after the newest segment.
+	if n := nextSegment(path); n != 3 {
+		t.Errorf("next segment is %d, want 3", n)
+	}
+}
+
+func readGzip(t *testing.T, name string) string {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Counter is a value which only goes up.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.value += v
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.value
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram returns a histogram with the given upper bucket bounds. A
+// +Inf bucket is always added.
+func NewHistogram(bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	if n := len(sorted); n == 0 || !math.IsInf(sorted[n-1], 1) {
+		sorted = append(sorted, math.Inf(1))
+	}
+	return &Histogram{
+		bounds:  sorted,
+		buckets: make([]uint64, len(sorted)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type histogramSnapshot struct {
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) snapshot() histogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return histogramSnapshot{
+		bounds:  h.bounds,
+		buckets: append([]uint64(nil), h.buckets...),
+		count:   h.count,
+		sum:     h.sum,
+	}
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one value of a gauge computed when metrics are collected.
+type Sample struct {
+	Labels []Label
+	Value  float64
+}
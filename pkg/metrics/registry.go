@@ -0,0 +1,133 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+// written by Registry.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type metric struct {
+	name      string
+	help      string
+	counter   *Counter
+	histogram *Histogram
+	gauge     func() []Sample
+}
+
+// Registry names metrics and writes them in the Prometheus text exposition
+// format, in the order they were added.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) AddCounter(name, help string, c *Counter) {
+	r.add(metric{name: name, help: help, counter: c})
+}
+
+func (r *Registry) AddHistogram(name, help string, h *Histogram) {
+	r.add(metric{name: name, help: help, histogram: h})
+}
+
+// AddGauge adds a gauge whose samples are computed by collect every time
+// metrics are written.
+func (r *Registry) AddGauge(name, help string, collect func() []Sample) {
+	r.add(metric{name: name, help: help, gauge: collect})
+}
+
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+
+		switch {
+		case m.counter != nil:
+			fmt.Fprintf(bw, "# TYPE %s counter\n", m.name)
+			fmt.Fprintf(bw, "%s %s\n", m.name, formatValue(m.counter.Value()))
+		case m.histogram != nil:
+			fmt.Fprintf(bw, "# TYPE %s histogram\n", m.name)
+			s := m.histogram.snapshot()
+			for i, bound := range s.bounds {
+				fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", m.name, formatValue(bound), s.buckets[i])
+			}
+			fmt.Fprintf(bw, "%s_sum %s\n", m.name, formatValue(s.sum))
+			fmt.Fprintf(bw, "%s_count %d\n", m.name, s.count)
+		case m.gauge != nil:
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", m.name)
+			for _, sample := range m.gauge() {
+				fmt.Fprintf(bw, "%s%s %s\n", m.name, formatLabels(sample.Labels), formatValue(sample.Value))
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = l.Name + "=\"" + escapeLabelValue(l.Value) + "\""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,66 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	c := NewCounter()
+	c.Add(2)
+	c.Inc()
+	r.AddCounter("jobs_total", "Jobs.\nAll of them.", c)
+
+	h := NewHistogram([]float64{10, 1})
+	for _, v := range []float64{0.5, 5, 50} {
+		h.Observe(v)
+	}
+	r.AddHistogram("latency_seconds", "Latency.", h)
+
+	r.AddGauge("queue", "Queued.", func() []Sample {
+		return []Sample{
+			{Labels: []Label{{Name: "state", Value: `say "hi"\`}}, Value: 1.5},
+			{Value: 0},
+		}
+	})
+
+	var b bytes.Buffer
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP jobs_total Jobs.\nAll of them.
+# TYPE jobs_total counter
+jobs_total 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="10"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 55.5
+latency_seconds_count 3
+# HELP queue Queued.
+# TYPE queue gauge
+queue{state="say \"hi\"\\"} 1.5
+queue 0
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultMaxAttempts is how many times a webhook delivery is attempted when
+// the configuration does not say.
+const DefaultMaxAttempts = 5
+
+// Config is the notification configuration of the daemon, written in YAML or
+// JSON.
+type Config struct {
+	// Webhooks are told about every job.
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	// Secret signs the webhooks above without a secret of their own. The
+	// webhooks given by jobs are not signed.
+	Secret      string `yaml:"secret"`
+	MaxAttempts int    `yaml:"max_attempts"`
+	// Smtp enables email to jobs which opt in to it.
+	Smtp *SmtpConfig `yaml:"smtp"`
+}
+
+// SmtpTLS selects how the connection to the SMTP server is secured.
+type SmtpTLS string
+
+const (
+	// SmtpStartTLS upgrades the connection with STARTTLS and fails if the
+	// server does not support it. It is the default.
+	SmtpStartTLS SmtpTLS = "starttls"
+	// SmtpImplicitTLS connects with TLS from the start, usually on port 465.
+	SmtpImplicitTLS SmtpTLS = "tls"
+	// SmtpNoTLS sends everything in the clear.
+	SmtpNoTLS SmtpTLS = "none"
+)
+
+// DefaultStderrLines is how many lines of stderr an email includes when the
+// configuration does not say.
+const DefaultStderrLines = 20
+
+type SmtpConfig struct {
+	Host     string  `yaml:"host"`
+	Port     int     `yaml:"port"`
+	Username string  `yaml:"username"`
+	Password string  `yaml:"password"`
+	TLS      SmtpTLS `yaml:"tls"`
+	From     string  `yaml:"from"`
+	// Domain is appended to users without one to address the owner of a
+	// job, e.g. shimizu becomes shimizu@<domain>.
+	Domain      string `yaml:"domain"`
+	StderrLines int    `yaml:"stderr_lines"`
+}
+
+type WebhookConfig struct {
+	types.Webhook `yaml:",inline"`
+	Secret        string `yaml:"secret"`
+	// fromJob is set on the webhooks of jobs, which must not reach internal
+	// hosts.
+	fromJob bool
+}
+
+// Validate checks the webhook. Unlike those of jobs, the webhooks of the
+// daemon are trusted to point at internal hosts.
+func (w *WebhookConfig) Validate() error {
+	if err := w.Webhook.Validate(); err != nil && !errors.Is(err, types.ErrInternalWebhook) {
+		return err
+	}
+	return nil
+}
+
+func LoadConfig(path string) (Config, error) {
+	var c Config
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].Validate(); err != nil {
+			return err
+		}
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	if c.Smtp != nil {
+		if err := c.Smtp.Validate(); err != nil {
+			return fmt.Errorf("smtp: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *SmtpConfig) Validate() error {
+	if len(c.Host) == 0 {
+		return fmt.Errorf("host must not be empty")
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid port %d", c.Port)
+	}
+	if _, err := mail.ParseAddress(c.From); err != nil {
+		return fmt.Errorf("invalid from address %q", c.From)
+	}
+	switch c.TLS {
+	case "", SmtpStartTLS, SmtpImplicitTLS, SmtpNoTLS:
+	default:
+		return fmt.Errorf("unknown tls mode %q", c.TLS)
+	}
+	if c.StderrLines < 0 {
+		return fmt.Errorf("stderr_lines must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestSmtpConfigValidate(t *testing.T) {
+	valid := SmtpConfig{Host: "smtp.example.com", Port: 587, From: "gpupipe@example.com"}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("minimal config is invalid: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(c *SmtpConfig)
+	}{
+		{"no host", func(c *SmtpConfig) { c.Host = "" }},
+		{"negative port", func(c *SmtpConfig) { c.Port = -1 }},
+		{"port out of range", func(c *SmtpConfig) { c.Port = 65536 }},
+		{"invalid from", func(c *SmtpConfig) { c.From = "gpupipe" }},
+		{"unknown tls", func(c *SmtpConfig) { c.TLS = "ssl" }},
+		{"negative stderr lines", func(c *SmtpConfig) { c.StderrLines = -1 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := valid
+			tc.modify(&c)
+			if err := c.Validate(); err == nil {
+				t.Error("invalid config was accepted")
+			}
+			config := Config{Smtp: &c}
+			if err := config.Validate(); err == nil {
+				t.Error("config with invalid smtp was accepted")
+			}
+		})
+	}
+}
+
+func TestConfigWebhooksMayBeInternal(t *testing.T) {
+	c := Config{Webhooks: []WebhookConfig{{Webhook: types.Webhook{Url: "http://127.0.0.1:8080/hook"}}}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("webhook of the daemon on the loopback address: %v", err)
+	}
+
+	c.Webhooks[0].Format = "teams"
+	if err := c.Validate(); err == nil {
+		t.Error("unknown format was accepted")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/google/uuid"
+)
+
+// smtpTimeout bounds a whole conversation with the SMTP server.
+const smtpTimeout = 30 * time.Second
+
+func (n *Notifier) sendEmail(c *SmtpConfig, maxAttempts int, email *types.EmailNotify, e types.NotifyEvent, p *process.Process) {
+	var owner string
+	if len(p.User) != 0 {
+		owner = p.User
+		if !strings.Contains(owner, "@") && len(c.Domain) != 0 {
+			owner += "@" + c.Domain
+		}
+	}
+
+	var to []string
+	for _, recipient := range email.To {
+		if recipientAllowed(c, owner, recipient) {
+			to = append(to, recipient)
+		} else {
+			log.Printf("not emailing %s of %s to %s, who is neither its user nor at the configured domain", e, p.Id, recipient)
+		}
+	}
+	if len(email.To) == 0 {
+		if len(owner) == 0 {
+			log.Printf("failed to email %s of %s: the job has no user", e, p.Id)
+			return
+		}
+		to = []string{owner}
+	}
+	if len(to) == 0 {
+		return
+	}
+
+	msg := buildEmail(c, to, e, p)
+	err := n.retry(maxAttempts, func() (bool, error) {
+		err := sendMail(c, to, msg)
+		// 5xx replies will not change by trying again.
+		protoErr, ok := err.(*textproto.Error)
+		return ok && protoErr.Code >= 500, err
+	})
+	if err != nil {
+		log.Printf("failed to email %s of %s to %s: %v", e, p.Id, strings.Join(to, ", "), err)
+	}
+}
+
+// recipientAllowed reports whether email about a job of owner may be sent to
+// recipient. Jobs may only email their owner and addresses at the configured
+// domain, so that gpiped is not a relay for anybody who can publish a job.
+func recipientAllowed(c *SmtpConfig, owner, recipient string) bool {
+	addr, err := mail.ParseAddress(recipient)
+	if err != nil {
+		return false
+	}
+	if len(owner) != 0 && strings.EqualFold(addr.Address, owner) {
+		return true
+	}
+	at := strings.LastIndex(addr.Address, "@")
+	return len(c.Domain) != 0 && strings.EqualFold(addr.Address[at+1:], c.Domain)
+}
+
+func buildEmail(c *SmtpConfig, to []string, e types.NotifyEvent, p *process.Process) []byte {
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace("[gpupipe] " + Summary(e, p))
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\n", c.From)
+	fmt.Fprintf(&b, "To: %s\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@gpupipe>\n", uuid.NewString())
+	fmt.Fprintf(&b, "MIME-Version: 1.0\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\n")
+	fmt.Fprintf(&b, "Content-Transfer-Encoding: 8bit\n\n")
+
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	if len(p.Name) != 0 {
+		fmt.Fprintf(w, "Name:\t%s\n", p.Name)
+	}
+	fmt.Fprintf(w, "Id:\t%s\n", p.Id)
+	if len(p.User) != 0 {
+		fmt.Fprintf(w, "User:\t%s\n", p.User)
+	}
+	fmt.Fprintf(w, "State:\t%s\n", process.ProcessStateToString(p.ProcessState))
+	if p.StartTime != nil {
+		fmt.Fprintf(w, "Exit code:\t%d\n", p.ExitCode)
+		fmt.Fprintf(w, "Runtime:\t%s\n", Runtime(p))
+	}
+	fmt.Fprintf(w, "GPU:\t%s\n", joinGpuIds(p.GpuId))
+	fmt.Fprintf(w, "Command:\t%s\n", strings.Join(p.Command, " "))
+	if len(p.ErrLogPath) != 0 {
+		fmt.Fprintf(w, "Stderr log:\t%s\n", p.ErrLogPath)
+	}
+	w.Flush()
+
+	lines := c.StderrLines
+	if lines == 0 {
+		lines = DefaultStderrLines
+	}
+	if p.StderrTail != nil {
+		if tail := lastLines(p.StderrTail.Bytes(), lines); len(tail) != 0 {
+			fmt.Fprintf(&b, "\nLast %d lines of stderr:\n\n%s\n", lines, tail)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// lastLines returns at most the last n lines of data.
+func lastLines(data []byte, n int) string {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sendMail(c *SmtpConfig, to []string, msg []byte) error {
+	port := c.Port
+	if port == 0 {
+		switch c.TLS {
+		case SmtpImplicitTLS:
+			port = 465
+		case SmtpNoTLS:
+			port = 25
+		default:
+			port = 587
+		}
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(port))
+	tlsConfig := &tls.Config{ServerName: c.Host}
+
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	var conn net.Conn
+	var err error
+	if c.TLS == SmtpImplicitTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, c.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if c.TLS == "" || c.TLS == SmtpStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Username) != 0 {
+		if err := client.Auth(smtp.PlainAuth("", c.Username, c.Password, c.Host)); err != nil {
+			return err
+		}
+	}
+
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		return err
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if address, err := mail.ParseAddress(rcpt); err == nil {
+			rcpt = address.Address
+		}
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
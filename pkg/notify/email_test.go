@@ -0,0 +1,276 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/logs"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+type receivedMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer is a minimal SMTP server without TLS or authentication which
+// accepts every mail and sends it on mails.
+type smtpServer struct {
+	listener net.Listener
+	mails    chan receivedMail
+}
+
+func newSmtpServer(t *testing.T) *smtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: listener, mails: make(chan receivedMail, 10)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) config() *SmtpConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &SmtpConfig{
+		Host:   addr.IP.String(),
+		Port:   addr.Port,
+		TLS:    SmtpNoTLS,
+		From:   "gpupipe <gpupipe@example.com>",
+		Domain: "example.com",
+	}
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost")
+	var m receivedMail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch {
+		case verb == "EHLO" || verb == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			m = receivedMail{from: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			m.to = append(m.to, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case verb == "DATA":
+			reply("354 end with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimSuffix(strings.TrimPrefix(line, "."), "\r\n") + "\n")
+			}
+			m.data = data.String()
+			s.mails <- m
+			reply("250 OK")
+		case verb == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) wait(t *testing.T) receivedMail {
+	t.Helper()
+	select {
+	case m := <-s.mails:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an email")
+		return receivedMail{}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	server := newSmtpServer(t)
+
+	start := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(90 * time.Minute)
+	stderr := logs.NewBuffer(1024)
+	stderr.Write([]byte("loading\nout of memory\n"))
+	failed := process.Process{
+		Id:           "job",
+		Name:         "train",
+		User:         "shimizu",
+		Command:      []string{"python", "train.py"},
+		GpuId:        []int{0, 1},
+		ProcessState: process.Failed,
+		ExitCode:     3,
+		StartTime:    &start,
+		EndTime:      &end,
+		StderrTail:   stderr,
+	}
+
+	for _, tc := range []struct {
+		name       string
+		to         []string
+		wantTo     []string
+		wantHeader string
+	}{
+		{name: "defaults to the user", wantTo: []string{"shimizu@example.com"}, wantHeader: "shimizu@example.com"},
+		{name: "explicit recipients", to: []string{"a@example.com", "B <b@Example.com>"}, wantTo: []string{"a@example.com", "b@Example.com"}, wantHeader: "a@example.com, B <b@Example.com>"},
+		{name: "other domains are dropped", to: []string{"a@example.org", "shimizu@example.com"}, wantTo: []string{"shimizu@example.com"}, wantHeader: "shimizu@example.com"},
+	} {
+		p := failed
+		p.Notify = &types.Notify{Email: &types.EmailNotify{To: tc.to}}
+		n := newTestNotifier(Config{Smtp: server.config()}, p)
+
+		n.handle(stateChanged(&p, process.Failed))
+		m := server.wait(t)
+
+		if m.from != "gpupipe@example.com" {
+			t.Errorf("%s: got sender %q", tc.name, m.from)
+		}
+		if strings.Join(m.to, ",") != strings.Join(tc.wantTo, ",") {
+			t.Errorf("%s: got recipients %v, want %v", tc.name, m.to, tc.wantTo)
+		}
+
+		msg, err := mail.ReadMessage(strings.NewReader(m.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		wantSubject := "[gpupipe] " + Summary(types.NotifyFailed, &p)
+		if got := msg.Header.Get("Subject"); got != wantSubject {
+			t.Errorf("%s: got subject %q, want %q", tc.name, got, wantSubject)
+		}
+		if got := msg.Header.Get("To"); got != tc.wantHeader {
+			t.Errorf("%s: got To %q", tc.name, got)
+		}
+
+		body, _ := ioutil.ReadAll(msg.Body)
+		for _, want := range []string{
+			"Name:       train",
+			"Id:         job",
+			"State:      " + process.ProcessStateToString(process.Failed),
+			"Exit code:  " + strconv.Itoa(p.ExitCode),
+			"Runtime:    " + Runtime(&p).String(),
+			"Command:    python train.py",
+			"loading\nout of memory",
+		} {
+			if !strings.Contains(string(body), want) {
+				t.Errorf("%s: body does not contain %q:\n%s", tc.name, want, body)
+			}
+		}
+	}
+}
+
+func TestEmailEventFilter(t *testing.T) {
+	server := newSmtpServer(t)
+
+	p := process.Process{
+		Id:     "job",
+		User:   "shimizu",
+		Notify: &types.Notify{Email: &types.EmailNotify{Events: []types.NotifyEvent{types.NotifyCancelled}}},
+	}
+	n := newTestNotifier(Config{Smtp: server.config()}, p)
+
+	for _, state := range []process.ProcessState{process.Active, process.Finished, process.Failed, process.Cancelled} {
+		n.handle(stateChanged(&p, state))
+	}
+	m := server.wait(t)
+	select {
+	case extra := <-server.mails:
+		t.Errorf("got a second email:\n%s", extra.data)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(m.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.Header.Get("Subject"), "[gpupipe] "+Summary(types.NotifyCancelled, &p); got != want {
+		t.Errorf("got subject %q, want %q", got, want)
+	}
+}
+
+func TestRecipientAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		domain, owner, recipient string
+		want                     bool
+	}{
+		{"example.com", "shimizu@example.com", "shimizu@example.com", true},
+		{"example.com", "shimizu@example.com", "Rei <rei@EXAMPLE.com>", true},
+		{"example.com", "shimizu@example.com", "rei@example.org", false},
+		{"example.com", "shimizu@example.com", "rei@mail.example.com", false},
+		{"", "shimizu@example.org", "Shimizu@example.org", true},
+		{"", "shimizu@example.org", "rei@example.org", false},
+		{"", "", "rei@example.org", false},
+		{"example.com", "", "not an address", false},
+	} {
+		c := &SmtpConfig{Domain: tc.domain}
+		if got := recipientAllowed(c, tc.owner, tc.recipient); got != tc.want {
+			t.Errorf("domain %q, owner %q: %q allowed %v, want %v", tc.domain, tc.owner, tc.recipient, got, tc.want)
+		}
+	}
+}
+
+func TestEmailWithoutAllowedRecipients(t *testing.T) {
+	server := newSmtpServer(t)
+
+	p := process.Process{
+		Id:           "job",
+		User:         "shimizu",
+		ProcessState: process.Failed,
+		Notify:       &types.Notify{Email: &types.EmailNotify{To: []string{"someone@example.org"}}},
+	}
+	n := newTestNotifier(Config{Smtp: server.config()}, p)
+
+	n.handle(stateChanged(&p, process.Failed))
+	select {
+	case m := <-server.mails:
+		t.Errorf("got an email to %v", m.to)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// Summary describes in one line what happened to p, for chat messages and
+// email subjects.
+func Summary(e types.NotifyEvent, p *process.Process) string {
+	job := p.Id
+	if len(p.Name) != 0 {
+		job = p.Name + " (" + p.Id + ")"
+	}
+	if len(p.User) != 0 {
+		job += " of " + p.User
+	}
+
+	switch e {
+	case types.NotifyPublished:
+		return fmt.Sprintf("job %s was published", job)
+	case types.NotifyStarted:
+		return fmt.Sprintf("job %s started on GPU %s", job, joinGpuIds(p.GpuId))
+	case types.NotifyFinished:
+		return fmt.Sprintf("job %s finished after %s", job, Runtime(p))
+	case types.NotifyFailed:
+		if len(p.ExitError) != 0 {
+			return fmt.Sprintf("job %s failed after %s: %s", job, Runtime(p), p.ExitError)
+		}
+		return fmt.Sprintf("job %s failed with exit code %d after %s", job, p.ExitCode, Runtime(p))
+	case types.NotifyCancelled:
+		return fmt.Sprintf("job %s was cancelled", job)
+	default:
+		return fmt.Sprintf("job %s: %s", job, e)
+	}
+}
+
+// Runtime returns how long p has run, rounded to seconds.
+func Runtime(p *process.Process) time.Duration {
+	if p.StartTime == nil {
+		return 0
+	}
+	end := time.Now()
+	if p.EndTime != nil {
+		end = *p.EndTime
+	}
+	return end.Sub(*p.StartTime).Round(time.Second)
+}
+
+func joinGpuIds(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,222 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+const (
+	// maxDeliveries bounds how many webhook deliveries are remembered.
+	maxDeliveries = 200
+	// eventsBufferSize is how far the notifier may fall behind the event
+	// bus before it has to resume from the backlog.
+	eventsBufferSize = 256
+	// initialBackoff is the wait after the first failed delivery attempt.
+	// It doubles with every further attempt, up to maxBackoff.
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+// Notifier tells webhooks about job lifecycle events published on an event
+// bus.
+type Notifier struct {
+	configMu sync.RWMutex
+	config   Config
+	events   *events.Bus
+	// lookup returns the current state of a job.
+	lookup func(id string) (process.Process, error)
+	client *http.Client
+	// jobClient posts to the webhooks of jobs and refuses to connect to
+	// internal hosts.
+	jobClient *http.Client
+	// backoff is the wait after the first failed delivery attempt.
+	backoff time.Duration
+
+	mu         sync.Mutex
+	deliveries []*types.WebhookDelivery
+}
+
+func NewNotifier(config Config, bus *events.Bus, lookup func(id string) (process.Process, error)) *Notifier {
+	n := &Notifier{
+		events:    bus,
+		lookup:    lookup,
+		client:    &http.Client{Timeout: webhookTimeout},
+		jobClient: newJobClient(),
+		backoff:   initialBackoff,
+	}
+	n.SetConfig(config)
+	return n
+}
+
+// SetConfig replaces the configuration. Deliveries in flight keep the one
+// they started with.
+func (n *Notifier) SetConfig(config Config) {
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+
+	n.configMu.Lock()
+	defer n.configMu.Unlock()
+	n.config = config
+}
+
+func (n *Notifier) currentConfig() Config {
+	n.configMu.RLock()
+	defer n.configMu.RUnlock()
+	return n.config
+}
+
+func (n *Notifier) Run() {
+	var lastId uint64
+	for {
+		sub, missed := n.events.Subscribe(lastId, eventsBufferSize)
+		for _, e := range missed {
+			lastId = e.Id
+			n.handle(e)
+		}
+		// The channel is closed if the notifier falls behind. Subscribe
+		// again and pick up where it stopped.
+		for e := range sub.C {
+			lastId = e.Id
+			n.handle(e)
+		}
+	}
+}
+
+// notifyEvent maps a bus event to the lifecycle event it stands for.
+func notifyEvent(e events.Event) (types.NotifyEvent, bool) {
+	switch e.Type {
+	case events.JobPublished:
+		return types.NotifyPublished, true
+	case events.JobStateChanged:
+		switch e.State {
+		case process.ProcessStateToString(process.Active):
+			return types.NotifyStarted, true
+		case process.ProcessStateToString(process.Finished):
+			return types.NotifyFinished, true
+		case process.ProcessStateToString(process.Failed):
+			return types.NotifyFailed, true
+		case process.ProcessStateToString(process.Cancelled):
+			return types.NotifyCancelled, true
+		}
+	}
+	return "", false
+}
+
+func (n *Notifier) handle(e events.Event) {
+	event, ok := notifyEvent(e)
+	if !ok {
+		return
+	}
+
+	config := n.currentConfig()
+
+	var hooks []WebhookConfig
+	for _, hook := range config.Webhooks {
+		if hook.Selects(event) {
+			if len(hook.Secret) == 0 {
+				hook.Secret = config.Secret
+			}
+			hooks = append(hooks, hook)
+		}
+	}
+
+	p, err := n.lookup(e.JobId)
+	if err != nil {
+		log.Printf("failed to notify %s of %s: %v", event, e.JobId, err)
+		return
+	}
+
+	// Hooks of jobs are chosen by whoever publishes the job, so they are
+	// never signed with the secret of the daemon.
+	if p.Notify != nil {
+		for _, hook := range p.Notify.Webhooks {
+			if hook.Selects(event) {
+				hooks = append(hooks, WebhookConfig{Webhook: hook, fromJob: true})
+			}
+		}
+	}
+
+	for _, hook := range hooks {
+		go n.deliver(hook, config.MaxAttempts, event, &p)
+	}
+
+	if config.Smtp != nil && p.Notify != nil && p.Notify.Email != nil && p.Notify.Email.Selects(event) {
+		go n.sendEmail(config.Smtp, config.MaxAttempts, p.Notify.Email, event, &p)
+	}
+}
+
+// Deliveries returns a copy of the most recent webhook deliveries, oldest
+// first.
+func (n *Notifier) Deliveries() []types.WebhookDelivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	deliveries := make([]types.WebhookDelivery, len(n.deliveries))
+	for i, d := range n.deliveries {
+		deliveries[i] = *d
+	}
+	return deliveries
+}
+
+func (n *Notifier) addDelivery(d *types.WebhookDelivery) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.deliveries = append(n.deliveries, d)
+	if over := len(n.deliveries) - maxDeliveries; over > 0 {
+		n.deliveries = append(n.deliveries[:0], n.deliveries[over:]...)
+	}
+}
+
+func (n *Notifier) recordAttempt(d *types.WebhookDelivery, statusCode int, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	d.Attempts++
+	d.LastAttemptTime = &now
+	d.StatusCode = statusCode
+	d.Delivered = err == nil
+	d.Error = ""
+	if err != nil {
+		d.Error = err.Error()
+	}
+}
+
+// retry calls attempt until it succeeds, fails permanently or maxAttempts is
+// reached, backing off exponentially in between.
+func (n *Notifier) retry(maxAttempts int, attempt func() (permanent bool, err error)) error {
+	backoff := n.backoff
+	for i := 1; ; i++ {
+		permanent, err := attempt()
+		if err == nil || permanent || i >= maxAttempts {
+			return err
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+type webhookRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// webhookServer records the webhook requests it receives and responds with
+// the status returned by status for the n-th request, counting from 1.
+type webhookServer struct {
+	*httptest.Server
+	status func(n int) int
+
+	mu       sync.Mutex
+	requests []webhookRequest
+	received chan struct{}
+}
+
+func newWebhookServer(t *testing.T, status func(n int) int) *webhookServer {
+	s := &webhookServer{status: status, received: make(chan struct{}, 100)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		s.mu.Lock()
+		s.requests = append(s.requests, webhookRequest{path: r.URL.Path, header: r.Header, body: body})
+		n := len(s.requests)
+		s.mu.Unlock()
+
+		code := http.StatusOK
+		if s.status != nil {
+			code = s.status(n)
+		}
+		w.WriteHeader(code)
+		s.received <- struct{}{}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// wait waits for n more requests.
+func (s *webhookServer) wait(t *testing.T, n int) []webhookRequest {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for webhook request %d", i+1)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]webhookRequest(nil), s.requests...)
+}
+
+func newTestNotifier(config Config, p process.Process) *Notifier {
+	n := NewNotifier(config, events.NewBus(0), func(id string) (process.Process, error) {
+		return p, nil
+	})
+	n.backoff = time.Millisecond
+	// The webhook servers of the tests listen on the loopback address.
+	n.jobClient = n.client
+	return n
+}
+
+// waitForAttempts returns the first delivery of n once it has been attempted
+// attempts times, or after a few seconds. Attempts are recorded after the
+// response has been read, so they may lag behind the requests received.
+func waitForAttempts(n *Notifier, attempts int) types.WebhookDelivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if deliveries := n.Deliveries(); len(deliveries) != 0 {
+			if d := deliveries[0]; d.Attempts == attempts || time.Now().After(deadline) {
+				return d
+			}
+		} else if time.Now().After(deadline) {
+			return types.WebhookDelivery{}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func stateChanged(p *process.Process, state process.ProcessState) events.Event {
+	return events.Event{
+		Type:  events.JobStateChanged,
+		JobId: p.Id,
+		State: process.ProcessStateToString(state),
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server := newWebhookServer(t, nil)
+
+	p := process.Process{
+		Id: "job",
+		Notify: &types.Notify{Webhooks: []types.Webhook{
+			{Url: server.URL + "/job", Events: []types.NotifyEvent{types.NotifyFinished}},
+		}},
+	}
+	n := newTestNotifier(Config{
+		Secret: "daemon secret",
+		Webhooks: []WebhookConfig{
+			{Webhook: types.Webhook{Url: server.URL + "/daemon"}},
+			{Webhook: types.Webhook{Url: server.URL + "/own"}, Secret: "own secret"},
+		},
+	}, p)
+
+	n.handle(stateChanged(&p, process.Finished))
+	requests := server.wait(t, 3)
+
+	want := map[string]string{"/daemon": "daemon secret", "/own": "own secret", "/job": ""}
+	for _, r := range requests {
+		secret, ok := want[r.path]
+		if !ok {
+			t.Errorf("unexpected request to %s", r.path)
+			continue
+		}
+		delete(want, r.path)
+
+		if got := r.header.Get(headerEvent); got != string(types.NotifyFinished) {
+			t.Errorf("%s: got event %q, want %q", r.path, got, types.NotifyFinished)
+		}
+		signature := r.header.Get(headerSignature)
+		if len(secret) == 0 {
+			if len(signature) != 0 {
+				t.Errorf("%s: got signature %q, want none", r.path, signature)
+			}
+		} else if signature != Sign(secret, r.body) {
+			t.Errorf("%s: got signature %q, want %q", r.path, signature, Sign(secret, r.body))
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	server := newWebhookServer(t, nil)
+
+	p := process.Process{Id: "job", Name: "train", ProcessState: process.Finished}
+	n := newTestNotifier(Config{Webhooks: []WebhookConfig{{Webhook: types.Webhook{Url: server.URL}}}}, p)
+
+	n.handle(stateChanged(&p, process.Finished))
+	requests := server.wait(t, 1)
+
+	var payload WebhookPayload
+	if err := json.Unmarshal(requests[0].body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != types.NotifyFinished || payload.Job.Id != p.Id || payload.Job.Name != p.Name {
+		t.Errorf("got payload %+v", payload)
+	}
+	if len(payload.Summary) == 0 {
+		t.Error("got an empty summary")
+	}
+	if got := requests[0].header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q", got)
+	}
+
+	d := waitForAttempts(n, 1)
+	if got := len(n.Deliveries()); got != 1 {
+		t.Fatalf("got %d deliveries, want 1", got)
+	}
+	if got := requests[0].header.Get(headerDelivery); got != d.Id {
+		t.Errorf("got delivery header %q, want %q", got, d.Id)
+	}
+	if d.JobId != p.Id || d.Event != types.NotifyFinished || d.Attempts != 1 {
+		t.Errorf("got delivery %+v", d)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		status        func(n int) int
+		wantAttempts  int
+		wantDelivered bool
+	}{
+		{
+			name: "retried until it succeeds",
+			status: func(n int) int {
+				if n < 3 {
+					return http.StatusServiceUnavailable
+				}
+				return http.StatusNoContent
+			},
+			wantAttempts:  3,
+			wantDelivered: true,
+		},
+		{
+			name:         "gives up after max attempts",
+			status:       func(int) int { return http.StatusInternalServerError },
+			wantAttempts: 4,
+		},
+		{
+			name:         "does not retry permanent errors",
+			status:       func(int) int { return http.StatusBadRequest },
+			wantAttempts: 1,
+		},
+	} {
+		server := newWebhookServer(t, tc.status)
+
+		p := process.Process{Id: "job"}
+		n := newTestNotifier(Config{
+			MaxAttempts: 4,
+			Webhooks:    []WebhookConfig{{Webhook: types.Webhook{Url: server.URL}}},
+		}, p)
+
+		n.handle(stateChanged(&p, process.Failed))
+		requests := server.wait(t, tc.wantAttempts)
+
+		d := waitForAttempts(n, tc.wantAttempts)
+		// Give an unwanted further attempt the chance to arrive.
+		time.Sleep(20 * time.Millisecond)
+
+		if got := len(server.wait(t, 0)); got != tc.wantAttempts {
+			t.Errorf("%s: got %d requests, want %d", tc.name, got, tc.wantAttempts)
+		}
+		if d.Attempts != tc.wantAttempts || d.Delivered != tc.wantDelivered {
+			t.Errorf("%s: got delivery %+v", tc.name, d)
+		}
+		for _, r := range requests[1:] {
+			if r.header.Get(headerDelivery) != requests[0].header.Get(headerDelivery) {
+				t.Errorf("%s: attempts have different delivery ids", tc.name)
+			}
+		}
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	server := newWebhookServer(t, nil)
+
+	p := process.Process{
+		Id: "job",
+		Notify: &types.Notify{Webhooks: []types.Webhook{
+			{Url: server.URL + "/job", Events: []types.NotifyEvent{types.NotifyStarted}},
+		}},
+	}
+	n := newTestNotifier(Config{Webhooks: []WebhookConfig{
+		// The default events are job.finished and job.failed.
+		{Webhook: types.Webhook{Url: server.URL + "/default"}},
+		{Webhook: types.Webhook{Url: server.URL + "/cancelled", Events: []types.NotifyEvent{types.NotifyCancelled}}},
+	}}, p)
+
+	for _, state := range []process.ProcessState{process.Active, process.Finished, process.Cancelled} {
+		n.handle(stateChanged(&p, state))
+	}
+	n.handle(events.Event{Type: events.GpuFree, GpuId: []int{0}})
+	requests := server.wait(t, 3)
+	time.Sleep(20 * time.Millisecond)
+
+	got := make(map[string]string)
+	for _, r := range server.wait(t, 0) {
+		if previous, ok := got[r.path]; ok {
+			t.Errorf("%s got %s and %s", r.path, previous, r.header.Get(headerEvent))
+		}
+		got[r.path] = r.header.Get(headerEvent)
+	}
+	want := map[string]string{
+		"/job":       string(types.NotifyStarted),
+		"/default":   string(types.NotifyFinished),
+		"/cancelled": string(types.NotifyCancelled),
+	}
+	if len(requests) != len(want) || len(got) != len(want) {
+		t.Fatalf("got events %v, want %v", got, want)
+	}
+	for path, event := range want {
+		if got[path] != event {
+			t.Errorf("%s got %q, want %q", path, got[path], event)
+		}
+	}
+}
+
+func TestJobWebhookRefusesInternalHosts(t *testing.T) {
+	server := newWebhookServer(t, nil)
+
+	// The notifier does not validate urls again, so this is refused when
+	// connecting, as names which resolve to internal hosts are.
+	p := process.Process{Id: "job", Notify: &types.Notify{Webhooks: []types.Webhook{{Url: server.URL}}}}
+	n := NewNotifier(Config{MaxAttempts: 3}, events.NewBus(0), func(id string) (process.Process, error) {
+		return p, nil
+	})
+	n.backoff = time.Millisecond
+
+	n.handle(stateChanged(&p, process.Failed))
+	d := waitForAttempts(n, 1)
+	time.Sleep(20 * time.Millisecond)
+
+	if got := len(server.wait(t, 0)); got != 0 {
+		t.Errorf("got %d requests", got)
+	}
+	if d = n.Deliveries()[0]; d.Delivered || d.Attempts != 1 {
+		t.Errorf("got delivery %+v, want one refused attempt", d)
+	}
+}
+
+func TestWebhookPayloadIsRedacted(t *testing.T) {
+	p := process.Process{
+		Id:     "job",
+		Env:    map[string]string{"API_KEY": "secret"},
+		Notify: &types.Notify{Webhooks: []types.Webhook{{Url: "https://hooks.example.com/secret"}}},
+	}
+	body, err := webhookBody(types.WebhookJSON, types.NotifyFinished, &p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(body, []byte("secret")) {
+		t.Errorf("payload %s leaks secrets", body)
+	}
+	if p.Env["API_KEY"] != "secret" {
+		t.Error("the job itself was redacted")
+	}
+}
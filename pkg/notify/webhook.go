@@ -0,0 +1,170 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/google/uuid"
+)
+
+const (
+	// webhookTimeout bounds a single delivery attempt.
+	webhookTimeout = 10 * time.Second
+)
+
+// Headers set on every webhook request. The signature is the hex encoded
+// HMAC-SHA256 of the body, prefixed with "sha256=".
+const (
+	headerEvent     = "X-Gpupipe-Event"
+	headerDelivery  = "X-Gpupipe-Delivery"
+	headerSignature = "X-Gpupipe-Signature"
+)
+
+// WebhookPayload is the body posted to webhooks of the json format. The job is
+// redacted, since webhooks are not necessarily trusted with its secrets.
+type WebhookPayload struct {
+	Event   types.NotifyEvent `json:"event"`
+	Time    time.Time         `json:"time"`
+	Summary string            `json:"summary"`
+	Job     process.Process   `json:"job"`
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+type discordPayload struct {
+	Content string `json:"content"`
+}
+
+func webhookBody(format types.WebhookFormat, e types.NotifyEvent, p *process.Process) ([]byte, error) {
+	summary := Summary(e, p)
+
+	switch format {
+	case types.WebhookSlack:
+		return json.Marshal(slackPayload{Text: summary})
+	case types.WebhookDiscord:
+		return json.Marshal(discordPayload{Content: summary})
+	default:
+		return json.Marshal(WebhookPayload{Event: e, Time: time.Now(), Summary: summary, Job: p.Redacted()})
+	}
+}
+
+// Sign returns the value of the signature header of body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newJobClient returns the client of the webhooks of jobs. Their urls are
+// checked when the job is published, but names may resolve to internal hosts,
+// so the address is checked again when connecting. They are not sent through
+// a proxy, which would hide where they connect to.
+func newJobClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: refuseInternal}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+}
+
+func refuseInternal(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || types.InternalIP(ip) {
+		return fmt.Errorf("%w: %s", types.ErrInternalWebhook, host)
+	}
+	return nil
+}
+
+// post sends one webhook request and returns the response status. A
+// permanent error is not worth retrying.
+func (n *Notifier) post(client *http.Client, req *http.Request) (statusCode int, permanent bool, err error) {
+	resp, err := client.Do(req)
+	if errors.Is(err, types.ErrInternalWebhook) {
+		return 0, true, err
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return resp.StatusCode, !retryable, fmt.Errorf("webhook responded %s", resp.Status)
+}
+
+func (n *Notifier) deliver(hook WebhookConfig, maxAttempts int, e types.NotifyEvent, p *process.Process) {
+	d := &types.WebhookDelivery{
+		Id:          uuid.NewString(),
+		Event:       e,
+		JobId:       p.Id,
+		Target:      types.RedactUrl(hook.Url),
+		CreatedTime: time.Now(),
+	}
+	n.addDelivery(d)
+
+	body, err := webhookBody(hook.Format, e, p)
+	if err != nil {
+		n.recordAttempt(d, 0, err)
+		log.Printf("failed to encode webhook %s: %v", d.Id, err)
+		return
+	}
+
+	err = n.retry(maxAttempts, func() (bool, error) {
+		req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+		if err != nil {
+			n.recordAttempt(d, 0, err)
+			return true, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "gpupipe")
+		req.Header.Set(headerEvent, string(e))
+		req.Header.Set(headerDelivery, d.Id)
+		if len(hook.Secret) != 0 {
+			req.Header.Set(headerSignature, Sign(hook.Secret, body))
+		}
+
+		client := n.client
+		if hook.fromJob {
+			client = n.jobClient
+		}
+		statusCode, permanent, err := n.post(client, req)
+		n.recordAttempt(d, statusCode, err)
+		return permanent, err
+	})
+	if err != nil {
+		log.Printf("failed to deliver webhook %s to %s after %d attempts: %v", d.Id, d.Target, d.Attempts, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// AnyUser in the users of an Impersonation allows every user with an account
+// but root.
+const AnyUser = "*"
+
+// Impersonation is the allowlist of users processes may be run as.
+type Impersonation struct {
+	users   map[string]bool
+	anyUser bool
+}
+
+// NewImpersonation allows users, where AnyUser stands for every user with an
+// account but root. Accounts with uid 0, whatever their name, have to be
+// listed by name.
+func NewImpersonation(users []string) *Impersonation {
+	i := &Impersonation{users: make(map[string]bool)}
+	for _, u := range users {
+		if u == AnyUser {
+			i.anyUser = true
+			continue
+		}
+		i.users[u] = true
+	}
+	return i
+}
+
+// Allows reports whether processes may be run as name. Processes without a
+// user are never allowed.
+func (i *Impersonation) Allows(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	if i.users[name] {
+		return true
+	}
+	if !i.anyUser {
+		return false
+	}
+	u, err := user.Lookup(name)
+	return err == nil && u.Uid != "0"
+}
+
+// credential looks up the uid, gid and supplementary groups of name.
+func credential(name string) (*syscall.Credential, *user.User, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has a non-numeric uid %s", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user %s has a non-numeric gid %s", name, u.Gid)
+	}
+
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to look up the groups of %s: %v", name, err)
+	}
+	groups := make([]uint32, 0, len(groupIds))
+	for _, g := range groupIds {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			continue
+		}
+		groups = append(groups, uint32(id))
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, u, nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import "testing"
+
+func TestImpersonationAllows(t *testing.T) {
+	for _, tc := range []struct {
+		users []string
+		name  string
+		want  bool
+	}{
+		{[]string{"shimizu"}, "shimizu", true},
+		{[]string{"shimizu"}, "rei", false},
+		{[]string{AnyUser}, "nobody", true},
+		{[]string{AnyUser}, "gpupipe-no-such-user", false},
+		{[]string{AnyUser}, "root", false},
+		{[]string{AnyUser, "root"}, "root", true},
+		{[]string{AnyUser}, "", false},
+	} {
+		if got := NewImpersonation(tc.users).Allows(tc.name); got != tc.want {
+			t.Errorf("%v allows %q: got %v, want %v", tc.users, tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux && (amd64 || arm64 || ppc64le)
+// +build linux
+// +build amd64 arm64 ppc64le
+
+package process
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// The system calls below take 32 bit ids on these architectures, which
+// are the ones NVIDIA drivers support. 32 bit architectures have separate
+// 32 bit variants of them.
+
+// openLogFileAs opens path like openLogFile, but with the filesystem
+// permissions of cred: files and directories it creates belong to cred, and
+// it may only open what cred could.
+//
+// The filesystem ids and groups are changed for a single thread, which is
+// thrown away afterwards rather than handed back to other goroutines.
+func openLogFileAs(path string, cred *syscall.Credential) (io.WriteCloser, error) {
+	type result struct {
+		fd  io.WriteCloser
+		err error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		// The thread is never unlocked, so it exits with this goroutine.
+		runtime.LockOSThread()
+
+		if err := setThreadFsCredential(cred); err != nil {
+			done <- result{err: err}
+			return
+		}
+		fd, err := openLogFile(path)
+		done <- result{fd: fd, err: err}
+	}()
+
+	r := <-done
+	return r.fd, r.err
+}
+
+// setThreadFsCredential makes the calling thread access files as cred. The
+// raw system calls only affect this thread, unlike their wrappers in
+// syscall, which change every thread of the process.
+func setThreadFsCredential(cred *syscall.Credential) error {
+	groups := cred.Groups
+	if len(groups) == 0 {
+		groups = []uint32{cred.Gid}
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(unsafe.Pointer(&groups[0])), 0); errno != 0 {
+		return fmt.Errorf("failed to set groups: %v", errno)
+	}
+
+	// setfsuid and setfsgid return the previous id instead of an error, so
+	// their effect is checked by asking for the id once more with an
+	// invalid one.
+	for _, c := range []struct {
+		trap uintptr
+		id   uint32
+		name string
+	}{
+		{syscall.SYS_SETFSGID, cred.Gid, "gid"},
+		{syscall.SYS_SETFSUID, cred.Uid, "uid"},
+	} {
+		syscall.RawSyscall(c.trap, uintptr(c.id), 0, 0)
+		current, _, _ := syscall.RawSyscall(c.trap, uintptr(^uint32(0)), 0, 0)
+		if uint32(current) != c.id {
+			return fmt.Errorf("failed to set filesystem %s %d", c.name, c.id)
+		}
+	}
+	return nil
+}
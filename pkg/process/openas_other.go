@@ -0,0 +1,28 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux || !(amd64 || arm64 || ppc64le)
+// +build !linux !amd64,!arm64,!ppc64le
+
+package process
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+)
+
+func openLogFileAs(path string, cred *syscall.Credential) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("opening logs as another user is not supported on this platform")
+}
@@ -16,74 +16,268 @@ package process
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/Shikugawa/gpupipe/pkg/logs"
 	"github.com/Shikugawa/gpupipe/pkg/types"
 	"github.com/google/uuid"
 )
 
 type Process struct {
-	Id                      string       `json:"id"`
-	Pid                     int          `json:"pid"`
-	RootPath                string       `json:"rootpath"`
-	Command                 []string     `json:"command"`
-	IssuedTime              time.Time    `json:"issued_time"`
-	GpuId                   []int        `json:"gpu_id"`
-	ProcessState            ProcessState `json:"process_state"`
-	LogPath                 string       `json:"log_path"`
-	ErrLogPath              string       `json:"err_log_path"`
-	MemoryUsageLowWatermark int          `json:"memory_usage_low_watermark"`
+	Id    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	User  string `json:"user,omitempty"`
+	Owner string `json:"owner,omitempty"`
+	// RunAs is the Unix user the command is run as. The command is run as
+	// the user of gpiped if it is empty.
+	RunAs                   string             `json:"run_as,omitempty"`
+	Pid                     int                `json:"pid"`
+	RootPath                string             `json:"rootpath"`
+	Command                 []string           `json:"command"`
+	IssuedTime              time.Time          `json:"issued_time"`
+	GpuId                   []int              `json:"gpu_id"`
+	ProcessState            ProcessState       `json:"process_state"`
+	LogPath                 string             `json:"log_path"`
+	ErrLogPath              string             `json:"err_log_path"`
+	MemoryUsageLowWatermark int                `json:"memory_usage_low_watermark"`
+	ExitCode                int                `json:"exit_code"`
+	DependsOn               []types.Dependency `json:"depends_on,omitempty"`
+	WorkflowId              string             `json:"workflow_id,omitempty"`
+	Env                     map[string]string  `json:"env,omitempty"`
+	ArrayId                 string             `json:"array_id,omitempty"`
+	ArrayIndex              *int               `json:"array_index,omitempty"`
+	Attempts                int                `json:"attempts"`
+	StartTime               *time.Time         `json:"start_time,omitempty"`
+	EndTime                 *time.Time         `json:"end_time,omitempty"`
+	ExitError               string             `json:"exit_error,omitempty"`
+	StateHistory            []StateTransition  `json:"state_history"`
+	Notify                  *types.Notify      `json:"notify,omitempty"`
+	// HoldReason tells why a pending process which could run is held back
+	// by its quota.
+	HoldReason string `json:"hold_reason,omitempty"`
+	// StdoutTail and StderrTail keep the last output of the command and are
+	// shared by every snapshot of the process.
+	StdoutTail *logs.Buffer `json:"-"`
+	StderrTail *logs.Buffer `json:"-"`
 }
 
-func (p *Process) Spawn(ch *chan bool) {
-	var outFd *os.File
-	var errFd *os.File
+// StateTransition records when a process entered a state.
+type StateTransition struct {
+	State ProcessState `json:"state"`
+	Time  time.Time    `json:"time"`
+}
 
-	if len(p.LogPath) != 0 {
-		tmpFd, err := os.OpenFile(p.LogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Println(err)
-			*ch <- false
-		}
-		outFd = tmpFd
-	} else {
-		outFd, _ = os.Open(os.DevNull)
+// SetState moves the process to state and records the transition. CanSpawn
+// is only a marker used within a single scheduling pass and is not recorded.
+func (p *Process) SetState(state ProcessState) {
+	p.ProcessState = state
+	if state == CanSpawn {
+		return
+	}
+	if n := len(p.StateHistory); n != 0 && p.StateHistory[n-1].State == state {
+		return
 	}
-	defer outFd.Close()
 
-	if len(p.ErrLogPath) != 0 {
-		tmpFd, err := os.OpenFile(p.ErrLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Println(err)
-			*ch <- false
+	now := time.Now()
+	p.StateHistory = append(p.StateHistory, StateTransition{State: state, Time: now})
+
+	if state == Active {
+		p.StartTime = &now
+	} else if IsTerminal(state) && p.EndTime == nil {
+		p.EndTime = &now
+	}
+
+	if IsTerminal(state) {
+		p.StdoutTail.Close()
+		p.StderrTail.Close()
+	}
+}
+
+// AccountedUser is who the process is accounted to: its owner, or the user
+// it claims if requests are not authenticated.
+func (p *Process) AccountedUser() string {
+	if len(p.Owner) != 0 {
+		return p.Owner
+	}
+	return p.User
+}
+
+// Snapshot returns a copy of p which can be handed to other goroutines while
+// p keeps changing.
+func (p *Process) Snapshot() Process {
+	snapshot := *p
+	snapshot.StateHistory = make([]StateTransition, len(p.StateHistory))
+	copy(snapshot.StateHistory, p.StateHistory)
+	return snapshot
+}
+
+// redactedValue replaces the values of the environment of redacted processes.
+const redactedValue = "<redacted>"
+
+// Redacted returns a copy of p for those who may see a process but not its
+// secrets: the values of its environment are replaced and its webhook urls
+// are reduced to their scheme and host, since both commonly carry
+// credentials.
+func (p Process) Redacted() Process {
+	if p.Env != nil {
+		env := make(map[string]string, len(p.Env))
+		for k := range p.Env {
+			env[k] = redactedValue
 		}
-		outFd = tmpFd
-	} else {
-		errFd, _ = os.Open(os.DevNull)
+		p.Env = env
+	}
+	if p.Notify != nil {
+		p.Notify = p.Notify.Redacted()
+	}
+	return p
+}
+
+// ExitEvent reports that a spawned process has terminated. Err is set when
+// the process could not be waited on or was killed by a signal.
+type ExitEvent struct {
+	Id       string
+	ExitCode int
+	Err      error
+}
+
+func (e *ExitEvent) Success() bool {
+	return e.Err == nil && e.ExitCode == 0
+}
+
+// LogOpener opens a log file for appending.
+type LogOpener func(path string) (io.WriteCloser, error)
+
+// userPath is the PATH of processes run as another user, whose environment
+// does not come from gpiped.
+const userPath = "/usr/local/bin:/usr/bin:/bin"
+
+// Spawn starts the command and returns once it is running. Its completion is
+// reported on exited from a separate goroutine, so Spawn must be called from
+// the goroutine which owns p. Log files are opened with open, or with
+// OpenLogFile if it is nil.
+func (p *Process) Spawn(exited chan<- ExitEvent, open LogOpener) error {
+	p.Attempts++
+
+	if open == nil {
+		open = p.OpenLogFile
 	}
 
-	defer errFd.Close()
+	stdout, outFd, err := openOutput(p.LogPath, p.StdoutTail, open)
+	if err != nil {
+		return err
+	}
+	stderr, errFd, err := openOutput(p.ErrLogPath, p.StderrTail, open)
+	if err != nil {
+		closeLogFile(outFd)
+		return err
+	}
 
 	cmd := exec.Command(p.Command[0], p.Command[1:]...)
 	log.Println(cmd.String())
 	cmd.Dir = p.RootPath
-	cmd.Stdout = outFd
-	cmd.Stderr = errFd
+	if len(p.RunAs) != 0 {
+		cred, u, err := credential(p.RunAs)
+		if err != nil {
+			closeLogFile(outFd)
+			closeLogFile(errFd)
+			return fmt.Errorf("failed to run as %s: %v", p.RunAs, err)
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+		// The environment of gpiped may hold its own secrets, so the
+		// process only gets what a login of its user would.
+		cmd.Env = []string{"PATH=" + userPath, "HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username}
+	}
+	if len(p.Env) != 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		for k, v := range p.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 
 	if err := cmd.Start(); err != nil {
-		log.Println(err)
-		*ch <- false
+		closeLogFile(outFd)
+		closeLogFile(errFd)
+		return err
 	}
 
 	p.Pid = cmd.Process.Pid
+	id := p.Id
+
+	go func() {
+		defer closeLogFile(outFd)
+		defer closeLogFile(errFd)
 
-	// TODO: プロセスが異常終了した場合はfalseにしたい
-	cmd.Wait()
-	*ch <- true
+		event := ExitEvent{Id: id}
+		if err := cmd.Wait(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() >= 0 {
+				event.ExitCode = exitErr.ExitCode()
+			} else {
+				event.ExitCode = -1
+				event.Err = err
+			}
+		}
+		exited <- event
+	}()
+
+	return nil
+}
+
+// openOutput returns where one output stream of the command goes: tail and,
+// if path is set, the log file at path.
+func openOutput(path string, tail *logs.Buffer, open LogOpener) (io.Writer, io.Closer, error) {
+	if len(path) == 0 {
+		return tail, nil, nil
+	}
+	fd, err := open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.MultiWriter(fd, tail), fd, nil
+}
+
+func closeLogFile(fd io.Closer) {
+	if fd != nil {
+		fd.Close()
+	}
+}
+
+// OpenLogFile opens path for appending as the user the process is run as,
+// creating its directory if needed. Paths of processes run as another user
+// are chosen by that user, so gpiped may not open them with its own
+// permissions.
+func (p *Process) OpenLogFile(path string) (io.WriteCloser, error) {
+	if len(p.RunAs) == 0 {
+		return openLogFile(path)
+	}
+
+	cred, _, err := credential(p.RunAs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s as %s: %v", path, p.RunAs, err)
+	}
+	fd, err := openLogFileAs(path, cred)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s as %s: %v", path, p.RunAs, err)
+	}
+	return fd, nil
+}
+
+// openLogFile opens path for appending, creating its directory if needed so
+// that log paths can be made unique per job.
+func openLogFile(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, logs.FileMode)
 }
 
 func (p *Process) Terminate() error {
@@ -97,15 +291,34 @@ func (p *Process) Terminate() error {
 }
 
 func NewProcess(r *types.ProcessPublishRequest) *Process {
-	return &Process{
-		Id:                      uuid.NewString(),
+	state := Pending
+	if len(r.DependsOn) != 0 {
+		state = Blocked
+	}
+
+	id := r.Id
+	if len(id) == 0 {
+		id = uuid.NewString()
+	}
+
+	p := &Process{
+		Id:                      id,
+		Name:                    r.Name,
+		User:                    r.User,
+		Owner:                   r.Owner,
 		RootPath:                r.RootPath,
 		Command:                 r.Command,
 		IssuedTime:              time.Now(),
 		GpuId:                   r.TargetGpu,
-		ProcessState:            Pending,
 		LogPath:                 r.LogPath,
 		ErrLogPath:              r.ErrLogPath,
 		MemoryUsageLowWatermark: r.MemoryUsageLowWatermark,
+		DependsOn:               r.DependsOn,
+		Env:                     r.Env,
+		Notify:                  r.Notify,
+		StdoutTail:              logs.NewBuffer(logs.DefaultTailSize),
+		StderrTail:              logs.NewBuffer(logs.DefaultTailSize),
 	}
+	p.SetState(state)
+	return p
 }
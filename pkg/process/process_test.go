@@ -0,0 +1,161 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package process
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/logs"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestSpawnCapturesOutput(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "job", "out.log")
+	p := NewProcess(&types.ProcessPublishRequest{
+		Command: []string{"sh", "-c", "echo out; echo err >&2"},
+		LogPath: logPath,
+	})
+
+	exited := make(chan ExitEvent, 1)
+	if err := p.Spawn(exited, nil); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-exited; !e.Success() {
+		t.Fatalf("exited with code %d: %v", e.ExitCode, e.Err)
+	}
+
+	if got := string(p.StdoutTail.Bytes()); got != "out\n" {
+		t.Errorf("kept stdout %q", got)
+	}
+	if got := string(p.StderrTail.Bytes()); got != "err\n" {
+		t.Errorf("kept stderr %q", got)
+	}
+
+	written, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "out\n" {
+		t.Errorf("logged %q", written)
+	}
+}
+
+func TestTerminalStateClosesOutput(t *testing.T) {
+	p := NewProcess(&types.ProcessPublishRequest{Command: []string{"true"}})
+	p.SetState(Active)
+	if _, _, _, closed := p.StdoutTail.Read(0); closed {
+		t.Fatal("output of an active process is closed")
+	}
+
+	p.SetState(Failed)
+	for _, tail := range []*logs.Buffer{p.StdoutTail, p.StderrTail} {
+		if _, _, _, closed := tail.Read(0); !closed {
+			t.Error("output of a failed process is still open")
+		}
+	}
+}
+
+func TestOpenLogFileAsOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("opening files as another user requires root")
+	}
+	cred, _, err := credential("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shared := filepath.Join(dir, "shared")
+	if err := os.Mkdir(shared, 0777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0777|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Process{RunAs: "nobody"}
+	if _, err := p.OpenLogFile(filepath.Join(dir, "root.log")); err == nil {
+		t.Error("opened a log in a directory nobody may not write to")
+	}
+
+	path := filepath.Join(shared, "job", "stdout.log")
+	fd, err := p.OpenLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd.Close()
+
+	for _, name := range []string{path, filepath.Dir(path)} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if stat.Uid != cred.Uid || stat.Gid != cred.Gid {
+			t.Errorf("%s belongs to %d:%d, want %d:%d", name, stat.Uid, stat.Gid, cred.Uid, cred.Gid)
+		}
+	}
+
+	// The identity of the calling goroutine is left alone.
+	if fd, err := openLogFile(filepath.Join(dir, "root.log")); err != nil {
+		t.Errorf("gpiped can no longer open its own logs: %v", err)
+	} else {
+		fd.Close()
+	}
+}
+
+func TestSpawnAsOwnerHasMinimalEnvironment(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("running processes as another user requires root")
+	}
+	if _, _, err := credential("nobody"); err != nil {
+		t.Skip(err)
+	}
+	t.Setenv("GPIPED_SECRET", "secret")
+
+	p := NewProcess(&types.ProcessPublishRequest{
+		Command: []string{"sh", "-c", `echo "$(id -un) $USER ${GPIPED_SECRET:-unset} $JOB"`},
+		Env:     map[string]string{"JOB": "1"},
+	})
+	p.RunAs = "nobody"
+
+	exited := make(chan ExitEvent, 1)
+	if err := p.Spawn(exited, nil); err != nil {
+		t.Fatal(err)
+	}
+	if e := <-exited; !e.Success() {
+		t.Fatalf("exited with code %d: %v", e.ExitCode, e.Err)
+	}
+	if got := string(p.StdoutTail.Bytes()); got != "nobody nobody unset 1\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSpawnAsUnknownUser(t *testing.T) {
+	p := NewProcess(&types.ProcessPublishRequest{Command: []string{"true"}})
+	p.RunAs = "no-such-user-gpupipe"
+	if err := p.Spawn(make(chan ExitEvent, 1), nil); err == nil {
+		t.Error("spawned as an unknown user")
+	}
+}
@@ -14,6 +14,11 @@
 
 package process
 
+import (
+	"fmt"
+	"strings"
+)
+
 type ProcessState int
 
 const (
@@ -21,6 +26,12 @@ const (
 	CanSpawn
 	Active
 	Finished
+	Failed
+	// Blocked processes wait for the jobs they depend on to terminate.
+	Blocked
+	// Cancelled processes will never run because a dependency can no longer
+	// be satisfied.
+	Cancelled
 )
 
 func ProcessStateToString(state ProcessState) string {
@@ -32,7 +43,28 @@ func ProcessStateToString(state ProcessState) string {
 		return "Active"
 	} else if state == Finished {
 		return "Finished"
+	} else if state == Failed {
+		return "Failed"
+	} else if state == Blocked {
+		return "Blocked"
+	} else if state == Cancelled {
+		return "Cancelled"
 	} else {
 		return ""
 	}
 }
+
+// ParseProcessState is the inverse of ProcessStateToString. It ignores case.
+func ParseProcessState(s string) (ProcessState, error) {
+	for state := Pending; state <= Cancelled; state++ {
+		if strings.EqualFold(s, ProcessStateToString(state)) {
+			return state, nil
+		}
+	}
+	return Pending, fmt.Errorf("unknown process state %q", s)
+}
+
+// IsTerminal reports whether a process in this state will never run again.
+func IsTerminal(state ProcessState) bool {
+	return state == Finished || state == Failed || state == Cancelled
+}
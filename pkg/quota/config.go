@@ -0,0 +1,103 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Limits are the quota of one user. Zero limits are unlimited.
+type Limits struct {
+	// MaxGpus is how many GPUs the processes of the user may hold at once.
+	MaxGpus int `yaml:"max_gpus" json:"max_gpus,omitempty"`
+	// MaxPendingJobs is how many processes of the user may wait in the
+	// queue.
+	MaxPendingJobs int `yaml:"max_pending_jobs" json:"max_pending_jobs,omitempty"`
+	// MaxGpuHoursPerDay is how much GPU time the processes of the user may
+	// use from midnight to midnight.
+	MaxGpuHoursPerDay float64 `yaml:"max_gpu_hours_per_day" json:"max_gpu_hours_per_day,omitempty"`
+}
+
+func (l *Limits) Validate() error {
+	if l.MaxGpus < 0 || l.MaxPendingJobs < 0 || l.MaxGpuHoursPerDay < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+// GroupLimits are the quota of every member of a Unix group.
+type GroupLimits struct {
+	Group  string `yaml:"group"`
+	Limits `yaml:",inline"`
+}
+
+// Config is the quota configuration, written in YAML or JSON:
+//
+//	default:
+//	  max_gpus: 2
+//	  max_pending_jobs: 10
+//	users:
+//	  shimizu:
+//	    max_gpus: 4
+//	groups:
+//	  - group: students
+//	    max_gpu_hours_per_day: 24
+//
+// Users have their own limits if they have any, those of the first group they
+// are a member of otherwise, and the default ones if they are in none.
+type Config struct {
+	Default Limits            `yaml:"default"`
+	Users   map[string]Limits `yaml:"users"`
+	Groups  []GroupLimits     `yaml:"groups"`
+}
+
+func LoadConfig(path string) (Config, error) {
+	var c Config
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	for user, limits := range c.Users {
+		if err := limits.Validate(); err != nil {
+			return fmt.Errorf("user %s: %v", user, err)
+		}
+	}
+	for i, g := range c.Groups {
+		if len(g.Group) == 0 {
+			return fmt.Errorf("group %d has no name", i)
+		}
+		if err := g.Limits.Validate(); err != nil {
+			return fmt.Errorf("group %s: %v", g.Group, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"fmt"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// Usage is what a user currently uses of their quota.
+type Usage struct {
+	// Gpus are held by the running processes of the user.
+	Gpus int `json:"gpus"`
+	// PendingJobs are the processes of the user waiting in the queue.
+	PendingJobs   int     `json:"pending_jobs"`
+	GpuHoursToday float64 `json:"gpu_hours_today"`
+}
+
+// Report is the quota of a user and what they use of it.
+type Report struct {
+	User   string `json:"user"`
+	Limits Limits `json:"limits"`
+	Usage  Usage  `json:"usage"`
+}
+
+type runningProcess struct {
+	user string
+	gpus int
+}
+
+// Quotas enforces the configured limits. It accounts for the GPU time of
+// every user today by being told when processes start and stop. Its methods
+// are safe to call concurrently.
+type Quotas struct {
+	config Config
+
+	mu sync.Mutex
+	// limits caches the limits of every user, since resolving their groups
+	// reads the group database.
+	limits map[string]Limits
+	// gpuHours is the GPU time used by every user from the start of day
+	// until updated.
+	gpuHours map[string]float64
+	running  map[string]runningProcess
+	day      time.Time
+	updated  time.Time
+
+	// now is the clock, replaced in tests.
+	now func() time.Time
+}
+
+func NewQuotas(c Config) *Quotas {
+	now := time.Now()
+	return &Quotas{
+		config:   c,
+		limits:   make(map[string]Limits),
+		gpuHours: make(map[string]float64),
+		running:  make(map[string]runningProcess),
+		day:      startOfDay(now),
+		updated:  now,
+		now:      time.Now,
+	}
+}
+
+// SetConfig replaces the limits, keeping the GPU time used today.
+func (q *Quotas) SetConfig(c Config) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.config = c
+	q.limits = make(map[string]Limits)
+}
+
+// Limits returns the limits of name.
+func (q *Quotas) Limits(name string) Limits {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limits, ok := q.limits[name]; ok {
+		return limits
+	}
+	limits := q.resolve(name)
+	q.limits[name] = limits
+	return limits
+}
+
+func (q *Quotas) resolve(name string) Limits {
+	if limits, ok := q.config.Users[name]; ok {
+		return limits
+	}
+	if len(q.config.Groups) == 0 {
+		return q.config.Default
+	}
+
+	groups := make(map[string]bool)
+	if u, err := user.Lookup(name); err == nil {
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if g, err := user.LookupGroupId(id); err == nil {
+					groups[g.Name] = true
+				}
+			}
+		}
+	}
+	for _, g := range q.config.Groups {
+		if groups[g.Group] {
+			return g.Limits
+		}
+	}
+	return q.config.Default
+}
+
+// GpuHoursToday returns the GPU time name used since midnight, including the
+// time of their running processes so far.
+func (q *Quotas) GpuHoursToday(name string) float64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.update(q.now())
+	return q.gpuHours[name]
+}
+
+func (q *Quotas) ProcessStarted(p *process.Process) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.update(q.now())
+	q.running[p.Id] = runningProcess{user: p.AccountedUser(), gpus: len(p.GpuId)}
+}
+
+func (q *Quotas) ProcessStopped(p *process.Process) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.update(q.now())
+	delete(q.running, p.Id)
+}
+
+// update adds the GPU time of running processes since the last update,
+// starting over at midnight.
+func (q *Quotas) update(now time.Time) {
+	if day := startOfDay(now); day.After(q.day) {
+		q.day = day
+		q.updated = day
+		q.gpuHours = make(map[string]float64)
+	}
+
+	elapsed := now.Sub(q.updated)
+	if elapsed <= 0 {
+		return
+	}
+	q.updated = now
+	for _, r := range q.running {
+		q.gpuHours[r.user] += float64(r.gpus) * elapsed.Hours()
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// CheckPublish returns why processes of name, who currently uses usage, may
+// not be queued, or nil if they may.
+func (q *Quotas) CheckPublish(name string, usage Usage, processes []*process.Process) error {
+	limits := q.Limits(name)
+
+	if limits.MaxPendingJobs != 0 && usage.PendingJobs+len(processes) > limits.MaxPendingJobs {
+		return fmt.Errorf("%s has %d pending jobs, %d more would exceed the quota of %d",
+			name, usage.PendingJobs, len(processes), limits.MaxPendingJobs)
+	}
+	if limits.MaxGpus != 0 {
+		for _, p := range processes {
+			if len(p.GpuId) > limits.MaxGpus {
+				return fmt.Errorf("a job of %s needs %d GPUs, more than the quota of %d at once",
+					name, len(p.GpuId), limits.MaxGpus)
+			}
+		}
+	}
+	return q.checkGpuHours(name, limits)
+}
+
+// CheckSpawn returns why p, a process of name, who currently uses usage, has
+// to wait, or nil if it may be spawned.
+func (q *Quotas) CheckSpawn(name string, usage Usage, p *process.Process) error {
+	limits := q.Limits(name)
+
+	if limits.MaxGpus != 0 && usage.Gpus+len(p.GpuId) > limits.MaxGpus {
+		return fmt.Errorf("%s holds %d GPUs, %d more would exceed the quota of %d",
+			name, usage.Gpus, len(p.GpuId), limits.MaxGpus)
+	}
+	return q.checkGpuHours(name, limits)
+}
+
+func (q *Quotas) checkGpuHours(name string, limits Limits) error {
+	if limits.MaxGpuHoursPerDay == 0 {
+		return nil
+	}
+	if used := q.GpuHoursToday(name); used >= limits.MaxGpuHoursPerDay {
+		return fmt.Errorf("%s used %.1f GPU-hours today, the quota is %.1f",
+			name, used, limits.MaxGpuHoursPerDay)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quota
+
+import (
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// testClock is a clock which only moves when told to.
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// newTestQuotas returns quotas whose clock starts at start.
+func newTestQuotas(c Config, start time.Time) (*Quotas, *testClock) {
+	clock := &testClock{t: start}
+	q := NewQuotas(c)
+	q.now = clock.now
+	q.day = startOfDay(start)
+	q.updated = start
+	return q, clock
+}
+
+func testProcess(id, owner string, gpus int) *process.Process {
+	return &process.Process{Id: id, Owner: owner, GpuId: make([]int, gpus)}
+}
+
+func TestGpuHoursToday(t *testing.T) {
+	q, clock := newTestQuotas(Config{}, time.Date(2021, 4, 1, 22, 0, 0, 0, time.Local))
+
+	p := testProcess("a", "rei", 2)
+	q.ProcessStarted(p)
+	clock.advance(time.Hour)
+	if got := q.GpuHoursToday("rei"); got != 2 {
+		t.Errorf("after an hour on 2 GPUs: got %v GPU-hours, want 2", got)
+	}
+
+	// The running process keeps accruing after midnight, from zero.
+	clock.advance(90 * time.Minute)
+	if got := q.GpuHoursToday("rei"); got != 1 {
+		t.Errorf("half an hour after midnight: got %v GPU-hours, want 1", got)
+	}
+
+	q.ProcessStopped(p)
+	clock.advance(time.Hour)
+	if got := q.GpuHoursToday("rei"); got != 1 {
+		t.Errorf("after stopping: got %v GPU-hours, want 1", got)
+	}
+
+	// Days without any update start over too.
+	clock.advance(48 * time.Hour)
+	if got := q.GpuHoursToday("rei"); got != 0 {
+		t.Errorf("days later: got %v GPU-hours, want 0", got)
+	}
+}
+
+func TestLimitsPrecedence(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	gid, err := u.GroupIds()
+	if err != nil || len(gid) == 0 {
+		t.Skip("groups of the current user are unknown")
+	}
+	g, err := user.LookupGroupId(gid[0])
+	if err != nil {
+		t.Skip(err)
+	}
+
+	userLimits := Limits{MaxGpus: 1}
+	groupLimits := Limits{MaxGpus: 2}
+	defaultLimits := Limits{MaxGpus: 3}
+
+	for _, c := range []struct {
+		name   string
+		config Config
+		user   string
+		want   Limits
+	}{
+		{
+			name:   "user over group",
+			config: Config{Default: defaultLimits, Users: map[string]Limits{u.Username: userLimits}, Groups: []GroupLimits{{Group: g.Name, Limits: groupLimits}}},
+			user:   u.Username,
+			want:   userLimits,
+		},
+		{
+			name:   "group over default",
+			config: Config{Default: defaultLimits, Groups: []GroupLimits{{Group: g.Name, Limits: groupLimits}}},
+			user:   u.Username,
+			want:   groupLimits,
+		},
+		{
+			name:   "first group",
+			config: Config{Default: defaultLimits, Groups: []GroupLimits{{Group: g.Name, Limits: groupLimits}, {Group: g.Name, Limits: userLimits}}},
+			user:   u.Username,
+			want:   groupLimits,
+		},
+		{
+			name:   "default without a group",
+			config: Config{Default: defaultLimits, Groups: []GroupLimits{{Group: "gpupipe-no-such-group", Limits: groupLimits}}},
+			user:   u.Username,
+			want:   defaultLimits,
+		},
+		{
+			name:   "default of unknown users",
+			config: Config{Default: defaultLimits, Groups: []GroupLimits{{Group: g.Name, Limits: groupLimits}}},
+			user:   "gpupipe-no-such-user",
+			want:   defaultLimits,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := NewQuotas(c.config).Limits(c.user); got != c.want {
+				t.Errorf("got %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMaxGpus(t *testing.T) {
+	q := NewQuotas(Config{Default: Limits{MaxGpus: 2}})
+
+	// A job needing more GPUs than the quota could never run.
+	if err := q.CheckPublish("rei", Usage{}, []*process.Process{testProcess("a", "rei", 3)}); err == nil {
+		t.Error("published a job needing more GPUs than the quota")
+	}
+	// One within it may wait for the GPUs held by others to be released,
+	if err := q.CheckPublish("rei", Usage{Gpus: 2}, []*process.Process{testProcess("a", "rei", 1)}); err != nil {
+		t.Errorf("refused a job within the quota: %v", err)
+	}
+	if err := q.CheckSpawn("rei", Usage{Gpus: 2}, testProcess("a", "rei", 1)); err == nil {
+		t.Error("spawned a job exceeding the quota together with the running ones")
+	}
+	// and run once they are.
+	if err := q.CheckSpawn("rei", Usage{Gpus: 1}, testProcess("a", "rei", 1)); err != nil {
+		t.Errorf("held a job within the quota: %v", err)
+	}
+}
+
+func TestMaxPendingJobs(t *testing.T) {
+	q := NewQuotas(Config{Users: map[string]Limits{"rei": {MaxPendingJobs: 2}}})
+
+	two := []*process.Process{testProcess("a", "rei", 0), testProcess("b", "rei", 0)}
+	if err := q.CheckPublish("rei", Usage{}, two); err != nil {
+		t.Errorf("refused jobs within the quota: %v", err)
+	}
+	if err := q.CheckPublish("rei", Usage{PendingJobs: 1}, two); err == nil {
+		t.Error("published jobs exceeding the quota")
+	}
+	if err := q.CheckPublish("shimizu", Usage{PendingJobs: 100}, two); err != nil {
+		t.Errorf("zero limits are not unlimited: %v", err)
+	}
+}
+
+func TestMaxGpuHoursPerDay(t *testing.T) {
+	q, clock := newTestQuotas(Config{Default: Limits{MaxGpuHoursPerDay: 2}}, time.Date(2021, 4, 1, 12, 0, 0, 0, time.Local))
+
+	p := testProcess("a", "rei", 1)
+	q.ProcessStarted(p)
+	clock.advance(2 * time.Hour)
+	q.ProcessStopped(p)
+
+	if err := q.CheckPublish("rei", Usage{}, []*process.Process{testProcess("b", "rei", 1)}); err == nil {
+		t.Error("published a job after the GPU-hours of the day were used")
+	}
+	if err := q.CheckSpawn("rei", Usage{}, testProcess("b", "rei", 1)); err == nil {
+		t.Error("spawned a job after the GPU-hours of the day were used")
+	}
+
+	clock.advance(12 * time.Hour)
+	if err := q.CheckSpawn("rei", Usage{}, testProcess("b", "rei", 1)); err != nil {
+		t.Errorf("held a job the next day: %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, c := range []Config{
+		{Default: Limits{MaxGpus: -1}},
+		{Users: map[string]Limits{"rei": {MaxPendingJobs: -1}}},
+		{Groups: []GroupLimits{{Limits: Limits{MaxGpus: 1}}}},
+		{Groups: []GroupLimits{{Group: "students", Limits: Limits{MaxGpuHoursPerDay: -1}}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	q, clock := newTestQuotas(Config{Default: Limits{MaxGpus: 1}}, time.Date(2021, 4, 1, 12, 0, 0, 0, time.Local))
+	p := testProcess("a", "rei", 1)
+	q.ProcessStarted(p)
+	clock.advance(time.Hour)
+	if limits := q.Limits("rei"); limits.MaxGpus != 1 {
+		t.Fatalf("got %+v", limits)
+	}
+
+	// Cached limits are resolved again, and the GPU time used today is kept.
+	q.SetConfig(Config{Default: Limits{MaxGpus: 2}})
+	if limits := q.Limits("rei"); limits.MaxGpus != 2 {
+		t.Errorf("got %+v after changing the default", limits)
+	}
+	clock.advance(time.Hour)
+	if got := q.GpuHoursToday("rei"); got != 2 {
+		t.Errorf("got %v GPU-hours, want 2", got)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestPublishSweep(t *testing.T) {
+	s, _ := newTestScheduler(t)
+
+	processes, err := s.Publish(&types.ProcessPublishRequest{
+		Name:    "train",
+		Command: []string{"sleep", "${seconds}"},
+		Sweep: &types.Sweep{
+			Mode:       types.SweepGrid,
+			Parameters: map[string][]types.SweepValue{"seconds": {"10", "20", "30"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 3 {
+		t.Fatalf("got %d processes, want 3", len(processes))
+	}
+
+	arrayId := processes[0].ArrayId
+	for i, p := range processes {
+		if p.ArrayId != arrayId || p.ArrayIndex == nil || *p.ArrayIndex != i {
+			t.Errorf("process %d has array %s[%v]", i, p.ArrayId, p.ArrayIndex)
+		}
+		if want := []string{"train[0]", "train[1]", "train[2]"}[i]; p.Name != want {
+			t.Errorf("process %d is named %s, want %s", i, p.Name, want)
+		}
+	}
+
+	if _, err := s.Publish(&types.ProcessPublishRequest{Command: []string{"true"}}); err != nil {
+		t.Fatal(err)
+	}
+	if array := s.ListArray(arrayId); len(array) != 3 {
+		t.Errorf("listed %d processes of the array, want 3", len(array))
+	}
+	if all := s.List(); len(all) != 4 {
+		t.Errorf("got %d queued processes, want 4", len(all))
+	}
+
+	if deleted := s.DeleteArray(arrayId); deleted != 3 {
+		t.Errorf("deleted %d processes, want 3", deleted)
+	}
+	if remaining := s.List(); len(remaining) != 1 || remaining[0].ArrayId != "" {
+		t.Errorf("got %v after deleting the array", remaining)
+	}
+	if deleted := s.DeleteArray(arrayId); deleted != 0 {
+		t.Errorf("deleted %d processes of a deleted array", deleted)
+	}
+}
+
+func TestPublishSweepOverflow(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	s.MaxPendingQueueSize = 2
+
+	_, err := s.Publish(&types.ProcessPublishRequest{
+		Command: []string{"true"},
+		Sweep: &types.Sweep{
+			Mode:   types.SweepList,
+			Values: []map[string]types.SweepValue{{"a": "1"}, {"a": "2"}, {"a": "3"}},
+		},
+	})
+	if err == nil {
+		t.Fatal("a sweep larger than the queue was accepted")
+	}
+	if processes := s.List(); len(processes) != 0 {
+		t.Errorf("a rejected sweep queued %d processes", len(processes))
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// Every mutation or read of the scheduler state is expressed as one of these
+// commands and handed to the goroutine running Scheduler.Run. Commands which
+// return something carry their own reply channel.
+
+type publishCommand struct {
+	request *types.ProcessPublishRequest
+	result  chan publishResult
+}
+
+type publishResult struct {
+	processes []process.Process
+	err       error
+}
+
+// deleteCommand removes the process with the given id, or every process of
+// the array when arrayId is set.
+type deleteCommand struct {
+	id      string
+	arrayId string
+	result  chan int
+}
+
+// listCommand lists copies of every queued process, or only of the process
+// with the given id or the processes of the array when id or arrayId is set.
+type listCommand struct {
+	id      string
+	arrayId string
+	result  chan []process.Process
+}
+
+type terminateCommand struct {
+	done chan struct{}
+}
+
+type reconfigureCommand struct {
+	apply func()
+	done  chan struct{}
+}
+
+type workflowOp int
+
+const (
+	publishWorkflow workflowOp = iota
+	listWorkflows
+	getWorkflow
+	cancelWorkflow
+	retryWorkflow
+)
+
+type workflowCommand struct {
+	op      workflowOp
+	id      string
+	request *types.WorkflowPublishRequest
+	result  chan workflowResult
+}
+
+type workflowResult struct {
+	statuses []types.WorkflowStatus
+	err      error
+}
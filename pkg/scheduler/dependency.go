@@ -0,0 +1,154 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// findProcess looks up a queued process by id, falling back to the most
+// recently published process with that name. Processes which have already
+// left the queue are looked up in the history the same way, and returned as
+// copies.
+func (s *Scheduler) findProcess(ref string) *process.Process {
+	var named *process.Process
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*process.Process)
+		if p.Id == ref {
+			return p
+		}
+		if len(p.Name) != 0 && p.Name == ref {
+			named = p
+		}
+	}
+	if named != nil {
+		return named
+	}
+
+	if p, ok := s.History.Get(ref); ok {
+		return &p
+	}
+	if p, ok := s.History.GetByName(ref); ok && len(ref) != 0 {
+		return &p
+	}
+	return nil
+}
+
+// resolveDependencyReferences validates the dependencies of a publish
+// request and rewrites every reference to the id of the upstream job, so that
+// reusing a name later does not change what an existing job waits for.
+func (s *Scheduler) resolveDependencyReferences(r *types.ProcessPublishRequest) ([]types.Dependency, error) {
+	if len(r.Name) != 0 {
+		if p := s.findProcess(r.Name); p != nil && !process.IsTerminal(p.ProcessState) {
+			return nil, fmt.Errorf("%w: job named %s is already queued", ErrConflict, r.Name)
+		}
+	}
+
+	var resolved []types.Dependency
+	for _, dep := range r.DependsOn {
+		if err := dep.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+
+		upstream := s.findProcess(dep.Job)
+		if upstream == nil {
+			return nil, fmt.Errorf("%w: unknown dependency %s", ErrInvalidRequest, dep.Job)
+		}
+
+		condition := dep.Condition
+		if len(condition) == 0 {
+			condition = types.AfterSuccess
+		}
+		resolved = append(resolved, types.Dependency{Job: upstream.Id, Condition: condition})
+	}
+	return resolved, nil
+}
+
+func dependencySatisfied(condition types.DependencyCondition, upstream process.ProcessState) bool {
+	switch condition {
+	case types.AfterAny:
+		return true
+	case types.AfterFailure:
+		return upstream == process.Failed || upstream == process.Cancelled
+	default:
+		return upstream == process.Finished
+	}
+}
+
+// resolveDependencies moves blocked processes to Pending once all of their
+// dependencies are satisfied, and cancels them once any dependency can no
+// longer be satisfied. Cancelling a process may in turn settle the processes
+// depending on it, so this repeats until nothing changes.
+func (s *Scheduler) resolveDependencies() {
+	for changed := true; changed; {
+		changed = false
+
+		for e := s.Queue.Front(); e != nil; e = e.Next() {
+			p := e.Value.(*process.Process)
+			if p.ProcessState != process.Blocked {
+				continue
+			}
+
+			waiting := false
+			for _, dep := range p.DependsOn {
+				// An upstream job which is no longer even in the history is
+				// treated as cancelled.
+				upstreamState := process.Cancelled
+				if upstream := s.findProcess(dep.Job); upstream != nil {
+					upstreamState = upstream.ProcessState
+				}
+
+				if !process.IsTerminal(upstreamState) {
+					waiting = true
+					continue
+				}
+
+				if !dependencySatisfied(dep.Condition, upstreamState) {
+					s.setState(p, process.Cancelled)
+					log.Printf("cancel %s because dependency %s is %s", p.Id, dep.Job, process.ProcessStateToString(upstreamState))
+					break
+				}
+			}
+
+			if p.ProcessState == process.Blocked && !waiting {
+				s.setState(p, process.Pending)
+			}
+			if p.ProcessState != process.Blocked {
+				changed = true
+			}
+		}
+	}
+}
+
+// hasBlockedDependents reports whether any blocked process still needs the
+// outcome of the process with the given id.
+func (s *Scheduler) hasBlockedDependents(id string) bool {
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*process.Process)
+		if p.ProcessState != process.Blocked {
+			continue
+		}
+		for _, dep := range p.DependsOn {
+			if dep.Job == id {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"container/list"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// queueOf returns a scheduler, which is not running, holding processes.
+func queueOf(processes ...*process.Process) *Scheduler {
+	s := &Scheduler{Queue: list.New(), History: history.NewStore(0, 0), Events: events.NewBus(0), Metrics: newMetrics()}
+	for _, p := range processes {
+		s.Queue.PushBack(p)
+	}
+	return s
+}
+
+func TestResolveDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		condition types.DependencyCondition
+		upstream  process.ProcessState
+		want      process.ProcessState
+	}{
+		{types.AfterSuccess, process.Active, process.Blocked},
+		{types.AfterSuccess, process.Finished, process.Pending},
+		{types.AfterSuccess, process.Failed, process.Cancelled},
+		{types.AfterSuccess, process.Cancelled, process.Cancelled},
+		{types.AfterAny, process.Finished, process.Pending},
+		{types.AfterAny, process.Failed, process.Pending},
+		{types.AfterFailure, process.Finished, process.Cancelled},
+		{types.AfterFailure, process.Failed, process.Pending},
+		{types.AfterFailure, process.Cancelled, process.Pending},
+	} {
+		upstream := &process.Process{Id: "a", ProcessState: tc.upstream}
+		dependent := &process.Process{
+			Id:           "b",
+			ProcessState: process.Blocked,
+			DependsOn:    []types.Dependency{{Job: "a", Condition: tc.condition}},
+		}
+		queueOf(upstream, dependent).resolveDependencies()
+
+		if dependent.ProcessState != tc.want {
+			t.Errorf("%s on %s: got %s, want %s", tc.condition,
+				process.ProcessStateToString(tc.upstream),
+				process.ProcessStateToString(dependent.ProcessState),
+				process.ProcessStateToString(tc.want))
+		}
+	}
+}
+
+func TestResolveDependenciesCascades(t *testing.T) {
+	a := &process.Process{Id: "a", ProcessState: process.Failed}
+	b := &process.Process{
+		Id:           "b",
+		ProcessState: process.Blocked,
+		DependsOn:    []types.Dependency{{Job: "a", Condition: types.AfterSuccess}},
+	}
+	c := &process.Process{
+		Id:           "c",
+		ProcessState: process.Blocked,
+		DependsOn:    []types.Dependency{{Job: "b", Condition: types.AfterSuccess}},
+	}
+	// c is queued before b, so cancelling b has to settle c on a later round.
+	queueOf(a, c, b).resolveDependencies()
+
+	for _, p := range []*process.Process{b, c} {
+		if p.ProcessState != process.Cancelled {
+			t.Errorf("%s: got %s, want Cancelled", p.Id, process.ProcessStateToString(p.ProcessState))
+		}
+	}
+}
+
+func TestResolveDependencyReferences(t *testing.T) {
+	s := queueOf(
+		&process.Process{Id: "1", Name: "train", ProcessState: process.Finished},
+		&process.Process{Id: "2", Name: "train", ProcessState: process.Active},
+	)
+
+	resolved, err := s.resolveDependencyReferences(&types.ProcessPublishRequest{
+		DependsOn: []types.Dependency{{Job: "train"}, {Job: "1", Condition: types.AfterAny}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Dependency{
+		{Job: "2", Condition: types.AfterSuccess},
+		{Job: "1", Condition: types.AfterAny},
+	}
+	if len(resolved) != len(want) {
+		t.Fatalf("got %v, want %v", resolved, want)
+	}
+	for i := range want {
+		if resolved[i] != want[i] {
+			t.Fatalf("got %v, want %v", resolved, want)
+		}
+	}
+
+	for _, r := range []*types.ProcessPublishRequest{
+		{DependsOn: []types.Dependency{{Job: "unknown"}}},
+		{DependsOn: []types.Dependency{{Job: "1", Condition: "after_everything"}}},
+		{Name: "train", DependsOn: []types.Dependency{{Job: "1"}}},
+	} {
+		if _, err := s.resolveDependencyReferences(r); err == nil {
+			t.Errorf("%+v was accepted", *r)
+		}
+	}
+}
+
+func TestDependencyOnReapedProcess(t *testing.T) {
+	for _, tc := range []struct {
+		command []string
+		want    process.ProcessState
+	}{
+		{command: []string{"true"}, want: process.Finished},
+		{command: []string{"false"}, want: process.Cancelled},
+	} {
+		s, gpuInfos := newTestScheduler(t)
+
+		upstream := publishOne(t, s, tc.command...)
+		waitFor(t, gpuInfos, func() bool {
+			_, reaped := s.History.Get(upstream.Id)
+			return reaped
+		})
+
+		processes, err := s.Publish(&types.ProcessPublishRequest{
+			Command:   []string{"true"},
+			DependsOn: []types.Dependency{{Job: upstream.Id}},
+		})
+		if err != nil {
+			t.Fatalf("%v: %v", tc.command, err)
+		}
+		id := processes[0].Id
+		waitFor(t, gpuInfos, func() bool { return process.IsTerminal(stateOf(s, id)) })
+
+		if got := stateOf(s, id); got != tc.want {
+			t.Errorf("%v: dependent ended in state %s, want %s", tc.command,
+				process.ProcessStateToString(got), process.ProcessStateToString(tc.want))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+func jobEvent(t events.Type, p *process.Process) events.Event {
+	return events.Event{
+		Type:  t,
+		JobId: p.Id,
+		Name:  p.Name,
+		User:  p.User,
+		Owner: p.Owner,
+		State: process.ProcessStateToString(p.ProcessState),
+	}
+}
+
+// enqueue appends a newly created process to the queue.
+func (s *Scheduler) enqueue(p *process.Process) {
+	s.Queue.PushBack(p)
+	s.Metrics.Published.Inc()
+	s.Events.Publish(jobEvent(events.JobPublished, p))
+}
+
+// setState moves p to state, and counts and publishes the transition. It
+// must not be used for the CanSpawn marker, which is not a transition of its
+// own.
+func (s *Scheduler) setState(p *process.Process, state process.ProcessState) {
+	previous := p.ProcessState
+	if n := len(p.StateHistory); n != 0 {
+		previous = p.StateHistory[n-1].State
+	}
+
+	p.SetState(state)
+	if state == previous {
+		return
+	}
+
+	var observers []ProcessObserver
+	if observer, ok := s.SchedulePlugin.(ProcessObserver); ok {
+		observers = append(observers, observer)
+	}
+	if s.Quotas != nil {
+		observers = append(observers, s.Quotas)
+	}
+	for _, observer := range observers {
+		if state == process.Active {
+			observer.ProcessStarted(p)
+		} else if previous == process.Active {
+			observer.ProcessStopped(p)
+		}
+	}
+
+	switch state {
+	case process.Finished:
+		s.Metrics.Finished.Inc()
+	case process.Failed:
+		s.Metrics.Failed.Inc()
+	case process.Cancelled:
+		s.Metrics.Cancelled.Inc()
+	}
+
+	e := jobEvent(events.JobStateChanged, p)
+	e.PreviousState = process.ProcessStateToString(previous)
+	if state == process.Failed {
+		e.Message = p.ExitError
+	}
+	s.Events.Publish(e)
+}
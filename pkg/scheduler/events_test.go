@@ -0,0 +1,51 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+func TestCancelPublishesEachTransitionOnce(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	p := publishOne(t, s, "sleep", "10")
+	cancelOnceActive(t, s, gpuInfos, p.Id)
+
+	sub, backlog := s.Events.Subscribe(0, 1)
+	defer s.Events.Unsubscribe(sub)
+
+	var got []string
+	for _, e := range backlog {
+		if e.Type == events.JobStateChanged && e.JobId == p.Id {
+			got = append(got, e.PreviousState+" -> "+e.State)
+		}
+	}
+	pending := process.ProcessStateToString(process.Pending)
+	active := process.ProcessStateToString(process.Active)
+	cancelled := process.ProcessStateToString(process.Cancelled)
+	want := []string{pending + " -> " + active, active + " -> " + cancelled}
+	if len(got) != len(want) {
+		t.Fatalf("got transitions %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got transitions %v, want %v", got, want)
+		}
+	}
+}
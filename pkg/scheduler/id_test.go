@@ -0,0 +1,93 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/google/uuid"
+)
+
+func TestPublishWithId(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	id := uuid.NewString()
+
+	processes, err := s.Publish(&types.ProcessPublishRequest{Id: id, Command: []string{"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processes[0].Id != id {
+		t.Errorf("got id %s, want %s", processes[0].Id, id)
+	}
+
+	for _, r := range []*types.ProcessPublishRequest{
+		{Id: id, Command: []string{"true"}},
+		{Id: "job-1", Command: []string{"true"}},
+	} {
+		if _, err := s.Publish(r); err == nil {
+			t.Errorf("id %s was accepted", r.Id)
+		}
+	}
+}
+
+func TestPublishSweepWithId(t *testing.T) {
+	s, _ := newTestScheduler(t)
+	id := uuid.NewString()
+
+	processes, err := s.Publish(&types.ProcessPublishRequest{
+		Id:      id,
+		Command: []string{"true"},
+		Sweep: &types.Sweep{
+			Mode:   types.SweepList,
+			Values: []map[string]types.SweepValue{{"a": "1"}, {"a": "2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range processes {
+		if p.ArrayId != id || p.Id == id {
+			t.Errorf("got process %s of array %s, want array %s", p.Id, p.ArrayId, id)
+		}
+	}
+
+	if _, err := s.Publish(&types.ProcessPublishRequest{Id: id, Command: []string{"true"}}); err == nil {
+		t.Error("the id of an array was reused")
+	}
+}
+
+func TestPublishWorkflowWithId(t *testing.T) {
+	s, _ := newTestScheduler(t)
+
+	r := twoSteps("true")
+	r.Id = uuid.NewString()
+	w, err := s.PublishWorkflow(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Id != r.Id {
+		t.Errorf("got workflow id %s, want %s", w.Id, r.Id)
+	}
+
+	if _, err := s.PublishWorkflow(twoSteps("true")); err != nil {
+		t.Fatal(err)
+	}
+	r2 := twoSteps("true")
+	r2.Id = r.Id
+	if _, err := s.PublishWorkflow(r2); err == nil {
+		t.Error("a workflow id was reused")
+	}
+}
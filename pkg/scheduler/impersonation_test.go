@@ -0,0 +1,67 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestImpersonationRefusesProcessesWithoutOwner(t *testing.T) {
+	s, _ := newTestScheduler(t, func(s *Scheduler) {
+		s.Impersonation = process.NewImpersonation([]string{process.AnyUser})
+	})
+
+	for _, tc := range []struct {
+		owner   string
+		wantErr error
+	}{
+		{owner: "", wantErr: ErrForbidden},
+		{owner: "root", wantErr: ErrForbidden},
+		{owner: "gpupipe-no-such-user", wantErr: ErrForbidden},
+		{owner: "nobody"},
+	} {
+		_, err := s.Publish(&types.ProcessPublishRequest{Owner: tc.owner, Command: []string{"true"}, TargetGpu: []int{0}})
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("owner %q: got error %v, want %v", tc.owner, err, tc.wantErr)
+		}
+	}
+}
+
+func TestImpersonationRunsAsOwner(t *testing.T) {
+	s, _ := newTestScheduler(t, func(s *Scheduler) {
+		s.Impersonation = process.NewImpersonation([]string{"nobody"})
+	})
+
+	processes, err := s.Publish(&types.ProcessPublishRequest{Owner: "nobody", Command: []string{"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runAs := processes[0].RunAs; runAs != "nobody" {
+		t.Errorf("run as %q, want the owner", runAs)
+	}
+
+	_, err = s.PublishWorkflow(&types.WorkflowPublishRequest{
+		Name:  "w",
+		Owner: "rei",
+		Steps: []types.ProcessPublishRequest{{Name: "a", Owner: "rei", Command: []string{"true"}}},
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Errorf("workflow of a user who is not allowed: got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import "github.com/Shikugawa/gpupipe/pkg/metrics"
+
+// SchedulingLatencyBuckets are the bucket bounds, in seconds, of
+// Metrics.SchedulingLatency. Jobs commonly wait hours for GPUs.
+var SchedulingLatencyBuckets = []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600}
+
+// Metrics counts what the scheduler does with processes.
+type Metrics struct {
+	Published   *metrics.Counter
+	Started     *metrics.Counter
+	Finished    *metrics.Counter
+	Failed      *metrics.Counter
+	Cancelled   *metrics.Counter
+	SpawnErrors *metrics.Counter
+	// SchedulingLatency observes the seconds from publishing a process
+	// to spawning it, including the time it waited for dependencies.
+	SchedulingLatency *metrics.Histogram
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		Published:         metrics.NewCounter(),
+		Started:           metrics.NewCounter(),
+		Finished:          metrics.NewCounter(),
+		Failed:            metrics.NewCounter(),
+		Cancelled:         metrics.NewCounter(),
+		SpawnErrors:       metrics.NewCounter(),
+		SchedulingLatency: metrics.NewHistogram(SchedulingLatencyBuckets),
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/metrics"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+func TestMetricsCountEachTerminationOnce(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	finished := publishOne(t, s, "true")
+	failed := publishOne(t, s, "false")
+	cancelled := publishOne(t, s, "sleep", "10")
+	waitFor(t, gpuInfos, func() bool {
+		return process.IsTerminal(stateOf(s, finished.Id)) && process.IsTerminal(stateOf(s, failed.Id))
+	})
+	cancelOnceActive(t, s, gpuInfos, cancelled.Id)
+
+	for name, tc := range map[string]struct {
+		counter *metrics.Counter
+		want    float64
+	}{
+		"published": {s.Metrics.Published, 3},
+		"started":   {s.Metrics.Started, 3},
+		"finished":  {s.Metrics.Finished, 1},
+		"failed":    {s.Metrics.Failed, 1},
+		"cancelled": {s.Metrics.Cancelled, 1},
+	} {
+		if got := tc.counter.Value(); got != tc.want {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
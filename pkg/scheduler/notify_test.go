@@ -0,0 +1,157 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// receiveUntil collects notifications until last returns true for one, and
+// then whatever arrives shortly after it.
+func receiveUntil(t *testing.T, received <-chan string, last func(string) bool) []string {
+	t.Helper()
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) == 0 || !last(got[len(got)-1]) {
+		select {
+		case notification := <-received:
+			got = append(got, notification)
+		case <-timeout:
+			t.Fatalf("timed out waiting for the last notification, got %q", got)
+		}
+	}
+	for {
+		select {
+		case notification := <-received:
+			got = append(got, notification)
+		case <-time.After(50 * time.Millisecond):
+			return got
+		}
+	}
+}
+
+func TestCancelledJobIsNotNotifiedAsFinished(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Gpupipe-Event")
+	}))
+	defer server.Close()
+
+	n := notify.NewNotifier(notify.Config{Webhooks: []notify.WebhookConfig{{Webhook: types.Webhook{
+		Url:    server.URL,
+		Events: []types.NotifyEvent{types.NotifyFinished, types.NotifyCancelled},
+	}}}}, s.Events, s.Get)
+	go n.Run()
+
+	p := publishOne(t, s, "sleep", "10")
+	cancelOnceActive(t, s, gpuInfos, p.Id)
+
+	got := receiveUntil(t, received, func(event string) bool { return event == string(types.NotifyCancelled) })
+	if len(got) != 1 {
+		t.Errorf("got webhooks %v, want only %s", got, types.NotifyCancelled)
+	}
+}
+
+// serveSmtp accepts every mail sent to listener, without TLS or
+// authentication, and sends its subject on subjects.
+func serveSmtp(listener net.Listener, subjects chan<- string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			conn.Write([]byte("220 localhost\r\n"))
+			for inData := false; ; {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				line = strings.TrimRight(line, "\r\n")
+
+				switch {
+				case inData && line == ".":
+					inData = false
+					conn.Write([]byte("250 OK\r\n"))
+				case inData:
+					if strings.HasPrefix(line, "Subject: ") {
+						subjects <- strings.TrimPrefix(line, "Subject: ")
+					}
+				case strings.EqualFold(line, "DATA"):
+					inData = true
+					conn.Write([]byte("354 go ahead\r\n"))
+				case strings.EqualFold(line, "QUIT"):
+					conn.Write([]byte("221 bye\r\n"))
+					return
+				default:
+					conn.Write([]byte("250 OK\r\n"))
+				}
+			}
+		}()
+	}
+}
+
+func TestCancelledJobIsNotEmailedAsFinished(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	subjects := make(chan string, 10)
+	go serveSmtp(listener, subjects)
+
+	addr := listener.Addr().(*net.TCPAddr)
+	n := notify.NewNotifier(notify.Config{Smtp: &notify.SmtpConfig{
+		Host: addr.IP.String(),
+		Port: addr.Port,
+		TLS:  notify.SmtpNoTLS,
+		From: "gpupipe@example.com",
+	}}, s.Events, s.Get)
+	go n.Run()
+
+	processes, err := s.Publish(&types.ProcessPublishRequest{
+		Command: []string{"sleep", "10"},
+		User:    "shimizu@example.com",
+		Notify: &types.Notify{Email: &types.EmailNotify{
+			To:     []string{"shimizu@example.com"},
+			Events: []types.NotifyEvent{types.NotifyFinished, types.NotifyCancelled},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancelOnceActive(t, s, gpuInfos, processes[0].Id)
+
+	got := receiveUntil(t, subjects, func(subject string) bool { return strings.HasSuffix(subject, "was cancelled") })
+	if len(got) != 1 {
+		t.Errorf("got emails %q, want only the cancellation", got)
+	}
+}
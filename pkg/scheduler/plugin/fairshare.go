@@ -0,0 +1,181 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultHalfLife is how long it takes for past usage to count half as much
+// when the configuration does not say.
+const DefaultHalfLife = 24 * time.Hour
+
+// FairShareConfig is the fair-share configuration, written in YAML or JSON:
+//
+//	half_life: 24h
+//	shares:
+//	  shimizu: 2
+type FairShareConfig struct {
+	HalfLife time.Duration `yaml:"half_life"`
+	// DefaultShare is the share of users not listed in Shares, 1 if it is
+	// not set.
+	DefaultShare float64 `yaml:"default_share"`
+	// Shares weights the usage of users. A user with share 2 may use twice
+	// the GPU time of a user with share 1.
+	Shares map[string]float64 `yaml:"shares"`
+}
+
+func LoadFairShareConfig(path string) (FairShareConfig, error) {
+	var c FairShareConfig
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := yaml.UnmarshalStrict(b, &c); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return c, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *FairShareConfig) Validate() error {
+	if c.HalfLife < 0 {
+		return fmt.Errorf("negative half_life %s", c.HalfLife)
+	}
+	if c.DefaultShare < 0 {
+		return fmt.Errorf("negative default_share %v", c.DefaultShare)
+	}
+	for user, share := range c.Shares {
+		if share <= 0 {
+			return fmt.Errorf("share of %s must be positive", user)
+		}
+	}
+	return nil
+}
+
+type runningProcess struct {
+	user string
+	gpus int
+}
+
+// FairSharePlugin selects the process of the user who used the least GPU
+// time relative to their share. Usage is counted in GPU-hours and decays
+// exponentially with the configured half-life, so that past usage is
+// forgiven over time. Processes of the same user are selected in FIFO order.
+type FairSharePlugin struct {
+	halfLife     time.Duration
+	defaultShare float64
+	shares       map[string]float64
+
+	// usage is the decayed GPU-hours of every user as of updated.
+	usage   map[string]float64
+	running map[string]runningProcess
+	updated time.Time
+
+	// now is the clock, replaced in tests.
+	now func() time.Time
+}
+
+func (f *FairSharePlugin) Select(canSpawnProcess []*process.Process) *process.Process {
+	f.update(f.now())
+
+	sort.SliceStable(canSpawnProcess, func(i, j int) bool {
+		a, b := f.priority(canSpawnProcess[i]), f.priority(canSpawnProcess[j])
+		if a != b {
+			return a < b
+		}
+		return canSpawnProcess[i].IssuedTime.Before(canSpawnProcess[j].IssuedTime)
+	})
+	return canSpawnProcess[0]
+}
+
+func (f *FairSharePlugin) ProcessStarted(p *process.Process) {
+	f.update(f.now())
+	f.running[p.Id] = runningProcess{user: p.AccountedUser(), gpus: len(p.GpuId)}
+}
+
+func (f *FairSharePlugin) ProcessStopped(p *process.Process) {
+	f.update(f.now())
+	delete(f.running, p.Id)
+}
+
+// priority is the usage of the user of p relative to their share. Lower
+// goes first.
+func (f *FairSharePlugin) priority(p *process.Process) float64 {
+	user := p.AccountedUser()
+	return f.usage[user] / f.share(user)
+}
+
+func (f *FairSharePlugin) share(user string) float64 {
+	if share, ok := f.shares[user]; ok {
+		return share
+	}
+	return f.defaultShare
+}
+
+// update decays the usage up to now and adds the GPU time of running
+// processes since the last update.
+func (f *FairSharePlugin) update(now time.Time) {
+	elapsed := now.Sub(f.updated)
+	f.updated = now
+	if elapsed <= 0 {
+		return
+	}
+
+	decay := math.Exp2(-float64(elapsed) / float64(f.halfLife))
+	for user := range f.usage {
+		f.usage[user] *= decay
+	}
+	for _, r := range f.running {
+		f.usage[r.user] += float64(r.gpus) * elapsed.Hours()
+	}
+}
+
+// SetConfig changes the half-life and shares, keeping the usage so far. It
+// must be called from the goroutine running the scheduler.
+func (f *FairSharePlugin) SetConfig(c FairShareConfig) {
+	f.update(f.now())
+
+	f.halfLife = c.HalfLife
+	if f.halfLife == 0 {
+		f.halfLife = DefaultHalfLife
+	}
+	f.defaultShare = c.DefaultShare
+	if f.defaultShare == 0 {
+		f.defaultShare = 1
+	}
+	f.shares = c.Shares
+}
+
+func NewFairSharePlugin(c FairShareConfig) *FairSharePlugin {
+	f := &FairSharePlugin{
+		usage:   make(map[string]float64),
+		running: make(map[string]runningProcess),
+		updated: time.Now(),
+		now:     time.Now,
+	}
+	f.SetConfig(c)
+	return f
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plugin
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// testClock is a clock which only moves when told to.
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestFairShare(c FairShareConfig) (*FairSharePlugin, *testClock) {
+	clock := &testClock{t: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)}
+	f := NewFairSharePlugin(c)
+	f.now = clock.now
+	f.updated = clock.t
+	return f, clock
+}
+
+func testProcess(id, owner string, gpus int, issued time.Time) *process.Process {
+	return &process.Process{Id: id, Owner: owner, GpuId: make([]int, gpus), IssuedTime: issued}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestFairShareDecay(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		elapsed time.Duration
+		want    float64
+	}{
+		{name: "no time", elapsed: 0, want: 8},
+		{name: "one half-life", elapsed: 2 * time.Hour, want: 4},
+		{name: "two half-lives", elapsed: 4 * time.Hour, want: 2},
+		{name: "half a half-life", elapsed: time.Hour, want: 8 / math.Sqrt2},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f, clock := newTestFairShare(FairShareConfig{HalfLife: 2 * time.Hour})
+			f.usage["rei"] = 8
+
+			clock.advance(c.elapsed)
+			f.update(clock.now())
+			if got := f.usage["rei"]; !almostEqual(got, c.want) {
+				t.Errorf("got %v GPU-hours, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestFairShareDefaults(t *testing.T) {
+	f, _ := newTestFairShare(FairShareConfig{})
+	if f.halfLife != DefaultHalfLife {
+		t.Errorf("half-life %s, want %s", f.halfLife, DefaultHalfLife)
+	}
+	// Without a default share, unlisted users have share 1.
+	if share := f.share("rei"); share != 1 {
+		t.Errorf("default share %v, want 1", share)
+	}
+
+	f, _ = newTestFairShare(FairShareConfig{DefaultShare: 0.5, Shares: map[string]float64{"shimizu": 2}})
+	if share := f.share("rei"); share != 0.5 {
+		t.Errorf("default share %v, want 0.5", share)
+	}
+	if share := f.share("shimizu"); share != 2 {
+		t.Errorf("listed share %v, want 2", share)
+	}
+}
+
+func TestFairShareSelect(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		config FairShareConfig
+		usage  map[string]float64
+		want   string
+	}{
+		{
+			name:  "least usage first",
+			usage: map[string]float64{"rei": 2, "shimizu": 1},
+			want:  "shimizu",
+		},
+		{
+			name:  "no usage first",
+			usage: map[string]float64{"rei": 2},
+			want:  "shimizu",
+		},
+		{
+			name:   "usage relative to the share",
+			config: FairShareConfig{Shares: map[string]float64{"rei": 4}},
+			usage:  map[string]float64{"rei": 2, "shimizu": 1},
+			want:   "rei",
+		},
+		{
+			name:   "default share of unlisted users",
+			config: FairShareConfig{DefaultShare: 4, Shares: map[string]float64{"shimizu": 1}},
+			usage:  map[string]float64{"rei": 2, "shimizu": 1},
+			want:   "rei",
+		},
+		{
+			name:  "FIFO on equal usage",
+			usage: map[string]float64{"rei": 1, "shimizu": 1},
+			want:  "rei",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			f, clock := newTestFairShare(c.config)
+			for user, usage := range c.usage {
+				f.usage[user] = usage
+			}
+
+			// rei has been waiting longer.
+			candidates := []*process.Process{
+				testProcess("b", "shimizu", 1, clock.t),
+				testProcess("a", "rei", 1, clock.t.Add(-time.Minute)),
+			}
+			if got := f.Select(candidates); got.Owner != c.want {
+				t.Errorf("selected the process of %s, want %s", got.Owner, c.want)
+			}
+		})
+	}
+}
+
+func TestFairShareAccountsRunningProcesses(t *testing.T) {
+	f, clock := newTestFairShare(FairShareConfig{HalfLife: time.Hour})
+
+	p := testProcess("a", "rei", 2, clock.t)
+	f.ProcessStarted(p)
+
+	// Usage accrues while p runs, decaying as it goes, which update
+	// approximates by adding the GPU time of every interval undecayed.
+	clock.advance(30 * time.Minute)
+	f.Select([]*process.Process{testProcess("b", "shimizu", 1, clock.t)})
+	if got := f.usage["rei"]; !almostEqual(got, 1) {
+		t.Errorf("after 30 minutes on 2 GPUs: got %v GPU-hours, want 1", got)
+	}
+
+	clock.advance(30 * time.Minute)
+	f.ProcessStopped(p)
+	want := 1/math.Sqrt2 + 1
+	if got := f.usage["rei"]; !almostEqual(got, want) {
+		t.Errorf("after an hour on 2 GPUs: got %v GPU-hours, want %v", got, want)
+	}
+
+	// Stopped processes do not count anymore.
+	clock.advance(time.Hour)
+	f.update(clock.now())
+	if got := f.usage["rei"]; !almostEqual(got, want/2) {
+		t.Errorf("an hour after stopping: got %v GPU-hours, want %v", got, want/2)
+	}
+
+	// Processes without an owner are accounted to their user.
+	f.ProcessStarted(&process.Process{Id: "c", User: "shimizu", GpuId: []int{0}})
+	clock.advance(time.Hour)
+	f.update(clock.now())
+	if got := f.usage["shimizu"]; !almostEqual(got, 1) {
+		t.Errorf("process without an owner: got %v GPU-hours, want 1", got)
+	}
+}
+
+func TestFairShareConfigValidate(t *testing.T) {
+	for _, c := range []FairShareConfig{
+		{HalfLife: -time.Hour},
+		{DefaultShare: -1},
+		{Shares: map[string]float64{"rei": 0}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%+v was accepted", c)
+		}
+	}
+	if c := (FairShareConfig{HalfLife: time.Hour, Shares: map[string]float64{"rei": 2}}); c.Validate() != nil {
+		t.Errorf("%+v was rejected", c)
+	}
+}
+
+func TestFairShareSetConfig(t *testing.T) {
+	f, clock := newTestFairShare(FairShareConfig{HalfLife: time.Hour})
+	f.ProcessStarted(testProcess("a", "rei", 1, clock.t))
+
+	// The usage of running processes up to now counts with the old
+	// half-life, and is kept.
+	clock.advance(time.Hour)
+	f.SetConfig(FairShareConfig{HalfLife: 2 * time.Hour, DefaultShare: 2, Shares: map[string]float64{"shimizu": 4}})
+	if got := f.usage["rei"]; !almostEqual(got, 1) {
+		t.Errorf("got %v GPU-hours, want 1", got)
+	}
+	if f.halfLife != 2*time.Hour || f.share("rei") != 2 || f.share("shimizu") != 4 {
+		t.Errorf("got half-life %s and shares %v, %v", f.halfLife, f.share("rei"), f.share("shimizu"))
+	}
+
+	// Processes keep running, and the new half-life applies from now.
+	clock.advance(2 * time.Hour)
+	f.update(clock.now())
+	if got := f.usage["rei"]; !almostEqual(got, 0.5+2) {
+		t.Errorf("got %v GPU-hours, want 2.5", got)
+	}
+
+	// Zero falls back to the defaults.
+	f.SetConfig(FairShareConfig{})
+	if f.halfLife != DefaultHalfLife || f.share("rei") != 1 || f.share("shimizu") != 1 {
+		t.Errorf("got half-life %s and shares %v, %v", f.halfLife, f.share("rei"), f.share("shimizu"))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// observingPlugin selects like firstPlugin and keeps the processes it was
+// told are running.
+type observingPlugin struct {
+	firstPlugin
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func (o *observingPlugin) ProcessStarted(p *process.Process) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.running[p.Id] = true
+}
+
+func (o *observingPlugin) ProcessStopped(p *process.Process) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.running, p.Id)
+}
+
+func (o *observingPlugin) isRunning(id string) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.running[id]
+}
+
+func TestProcessObserver(t *testing.T) {
+	observer := &observingPlugin{running: make(map[string]bool)}
+	s, gpuInfos := newTestScheduler(t, func(s *Scheduler) { s.SchedulePlugin = observer })
+
+	p := publishOne(t, s, "sleep", "10")
+	waitFor(t, gpuInfos, func() bool { return observer.isRunning(p.Id) })
+
+	if !s.Delete(p.Id) {
+		t.Fatalf("failed to delete %s", p.Id)
+	}
+	waitFor(t, gpuInfos, func() bool { return !observer.isRunning(p.Id) })
+}
+
+func TestSetSchedulePluginReplaysRunningProcesses(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	running := publishOne(t, s, "sleep", "10")
+	waitFor(t, gpuInfos, func() bool { return stateOf(s, running.Id) == process.Active })
+	finished := publishOne(t, s, "true")
+	waitFor(t, gpuInfos, func() bool { return process.IsTerminal(stateOf(s, finished.Id)) })
+
+	observer := &observingPlugin{running: make(map[string]bool)}
+	s.Reconfigure(func() { s.SetSchedulePlugin(observer) })
+	if !observer.isRunning(running.Id) || observer.isRunning(finished.Id) {
+		t.Fatalf("plugin was not told only of the running process %s", running.Id)
+	}
+
+	if !s.Delete(running.Id) {
+		t.Fatalf("failed to delete %s", running.Id)
+	}
+	waitFor(t, gpuInfos, func() bool { return !observer.isRunning(running.Id) })
+}
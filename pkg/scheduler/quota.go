@@ -0,0 +1,91 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+)
+
+// Quota returns the limits of user and what they currently use of them.
+func (s *Scheduler) Quota(user string) quota.Report {
+	report := quota.Report{User: user}
+	if s.Quotas == nil {
+		return report
+	}
+
+	report.Limits = s.Quotas.Limits(user)
+	for _, p := range s.List() {
+		if p.AccountedUser() == user {
+			addUsage(&report.Usage, &p)
+		}
+	}
+	report.Usage.GpuHoursToday = s.Quotas.GpuHoursToday(user)
+	return report
+}
+
+// quotaUsage returns what user currently uses of their quota. GPU time is
+// left to the quotas themselves.
+func (s *Scheduler) quotaUsage(user string) quota.Usage {
+	var usage quota.Usage
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*process.Process)
+		if p.AccountedUser() == user {
+			addUsage(&usage, p)
+		}
+	}
+	return usage
+}
+
+func addUsage(usage *quota.Usage, p *process.Process) {
+	switch p.ProcessState {
+	case process.Active:
+		usage.Gpus += len(p.GpuId)
+	case process.Pending, process.Blocked, process.CanSpawn:
+		usage.PendingJobs++
+	}
+}
+
+// checkPublishQuota refuses processes which would exceed the quota of their
+// user.
+func (s *Scheduler) checkPublishQuota(processes []*process.Process) error {
+	if s.Quotas == nil || len(processes) == 0 {
+		return nil
+	}
+
+	user := processes[0].AccountedUser()
+	if err := s.Quotas.CheckPublish(user, s.quotaUsage(user), processes); err != nil {
+		return fmt.Errorf("%w: %v", ErrQuotaExceeded, err)
+	}
+	return nil
+}
+
+// holdByQuota reports whether p has to wait for its user to be within their
+// quota, and records why in p.
+func (s *Scheduler) holdByQuota(p *process.Process) bool {
+	if s.Quotas == nil {
+		return false
+	}
+
+	user := p.AccountedUser()
+	if err := s.Quotas.CheckSpawn(user, s.quotaUsage(user), p); err != nil {
+		p.HoldReason = err.Error()
+		return true
+	}
+	p.HoldReason = ""
+	return false
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestQuotaMaxGpus(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t, func(s *Scheduler) {
+		s.Quotas = quota.NewQuotas(quota.Config{Default: quota.Limits{MaxGpus: 1}})
+	})
+
+	publish := func(gpus ...int) (process.Process, error) {
+		processes, err := s.Publish(&types.ProcessPublishRequest{
+			Owner:     "rei",
+			Command:   []string{"sleep", "10"},
+			TargetGpu: gpus,
+		})
+		if err != nil {
+			return process.Process{}, err
+		}
+		return processes[0], nil
+	}
+
+	// A job needing more GPUs than the quota is refused right away.
+	if _, err := publish(0, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("job needing 2 GPUs: got %v", err)
+	}
+
+	running, err := publish(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, gpuInfos, func() bool { return stateOf(s, running.Id) == process.Active })
+
+	// One which only exceeds it together with the running job waits,
+	held, err := publish(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, gpuInfos, func() bool {
+		p, err := s.Get(held.Id)
+		return err == nil && len(p.HoldReason) != 0
+	})
+	if state := stateOf(s, held.Id); state != process.Pending {
+		t.Errorf("held job is in state %d", state)
+	}
+	if usage := s.Quota("rei").Usage; usage.Gpus != 1 || usage.PendingJobs != 1 {
+		t.Errorf("got usage %+v", usage)
+	}
+
+	// and runs once the GPU is released.
+	if !s.Delete(running.Id) {
+		t.Fatalf("failed to delete %s", running.Id)
+	}
+	cancelOnceActive(t, s, gpuInfos, held.Id)
+}
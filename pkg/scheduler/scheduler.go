@@ -16,188 +16,569 @@ package scheduler
 
 import (
 	"container/list"
-	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os/user"
+	"time"
 
+	"github.com/Shikugawa/gpupipe/pkg/events"
 	"github.com/Shikugawa/gpupipe/pkg/gpu"
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/logs"
 	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
 	"github.com/Shikugawa/gpupipe/pkg/types"
 	"github.com/Shikugawa/gpupipe/pkg/watcher"
+	"github.com/google/uuid"
 )
 
+// Scheduler owns the process queue. The queue is only ever touched by the
+// goroutine running Run; the exported methods send commands to that
+// goroutine and wait for the reply, so they are safe to call concurrently.
 type Scheduler struct {
-	Queue                          *list.List
-	Watcher                        *watcher.Agent
-	TargetGpuInfos                 chan []gpu.GpuInfo
-	MaxPendingQueueSize            int
-	ProcessEventHandler            *ProcessEventHandler
-	SchedulePlugin                 SchedulePlugin
+	Queue               *list.List
+	Watcher             *watcher.Agent
+	TargetGpuInfos      <-chan []gpu.GpuInfo
+	MaxPendingQueueSize int
+	SchedulePlugin      SchedulePlugin
+	History             *history.Store
+	Events              *events.Bus
+	Metrics             *Metrics
+	// LogDir, if set, holds the logs of processes published without log
+	// paths and rotates every log file.
+	LogDir *logs.Dir
+	// Impersonation, if set, runs processes as their owner, who has to be
+	// allowed by it.
+	Impersonation *process.Impersonation
+	// Quotas, if set, limits what every user may queue and run.
+	Quotas                         *quota.Quotas
 	defaultMemoryUsageLowWatermark int
+
+	publishCh     chan publishCommand
+	deleteCh      chan deleteCommand
+	listCh        chan listCommand
+	exitCh        chan process.ExitEvent
+	terminateCh   chan terminateCommand
+	reconfigureCh chan reconfigureCommand
+	workflowCh    chan workflowCommand
+
+	workflows     map[string]*workflow
+	workflowOrder []string
+}
+
+// Publish queues r and returns a copy of every process created for it. A
+// request with a sweep creates one process per parameter set.
+func (s *Scheduler) Publish(r *types.ProcessPublishRequest) ([]process.Process, error) {
+	result := make(chan publishResult, 1)
+	s.publishCh <- publishCommand{request: r, result: result}
+	res := <-result
+	return res.processes, res.err
+}
+
+// List returns a copy of every queued process.
+func (s *Scheduler) List() []process.Process {
+	return s.sendListCommand(listCommand{})
 }
 
-func (s *Scheduler) Publish(r *types.ProcessPublishRequest) error {
-	if s.Queue.Len() >= s.MaxPendingQueueSize {
-		return fmt.Errorf("failed to publish pending process with queue size overflow")
+// ListArray returns a copy of every process expanded from the sweep with the
+// given array id.
+func (s *Scheduler) ListArray(arrayId string) []process.Process {
+	return s.sendListCommand(listCommand{arrayId: arrayId})
+}
+
+// Get returns a copy of the process with the given id, looking it up in the
+// history if it is no longer queued.
+func (s *Scheduler) Get(id string) (process.Process, error) {
+	if processes := s.sendListCommand(listCommand{id: id}); len(processes) != 0 {
+		return processes[0], nil
 	}
-	s.Queue.PushBack(process.NewProcess(r))
-	return nil
+	if p, ok := s.History.Get(id); ok {
+		return p, nil
+	}
+	return process.Process{}, fmt.Errorf("%w: unknown process %s", ErrNotFound, id)
+}
+
+// Query returns the page of queued and terminated processes matching q, and
+// how many matched in total.
+func (s *Scheduler) Query(q history.Query) ([]process.Process, int) {
+	processes := append(s.History.List(), s.List()...)
+	return q.Apply(processes)
+}
+
+func (s *Scheduler) sendListCommand(c listCommand) []process.Process {
+	c.result = make(chan []process.Process, 1)
+	s.listCh <- c
+	return <-c.result
 }
 
-func (s *Scheduler) List() ([]byte, error) {
-	processSet := make(map[string][]process.Process)
-	processSet["processes"] = make([]process.Process, 0)
+func (s *Scheduler) Delete(id string) bool {
+	result := make(chan int, 1)
+	s.deleteCh <- deleteCommand{id: id, result: result}
+	return <-result != 0
+}
 
+// DeleteArray removes every process of the array and returns how many were
+// removed.
+func (s *Scheduler) DeleteArray(arrayId string) int {
+	result := make(chan int, 1)
+	s.deleteCh <- deleteCommand{arrayId: arrayId, result: result}
+	return <-result
+}
+
+func (s *Scheduler) TerminateAllActiveProcess() {
+	done := make(chan struct{})
+	s.terminateCh <- terminateCommand{done: done}
+	<-done
+}
+
+// Reconfigure calls apply from the goroutine running the scheduler, between
+// scheduling passes, so that apply may change its exported settings such as
+// MaxPendingQueueSize, and replace the plugin with SetSchedulePlugin.
+func (s *Scheduler) Reconfigure(apply func()) {
+	done := make(chan struct{})
+	s.reconfigureCh <- reconfigureCommand{apply: apply, done: done}
+	<-done
+}
+
+// SetSchedulePlugin replaces SchedulePlugin with plugin. A plugin which is a
+// ProcessObserver is told about the processes already running, as if it had
+// seen them start. It must be called from Reconfigure.
+func (s *Scheduler) SetSchedulePlugin(plugin SchedulePlugin) {
+	s.SchedulePlugin = plugin
+
+	observer, ok := plugin.(ProcessObserver)
+	if !ok {
+		return
+	}
 	for e := s.Queue.Front(); e != nil; e = e.Next() {
-		queuedProcess := e.Value.(*process.Process)
-		processSet["processes"] = append(processSet["processes"], *queuedProcess)
+		if p := e.Value.(*process.Process); p.ProcessState == process.Active {
+			observer.ProcessStarted(p)
+		}
+	}
+}
+
+// SetImpersonation replaces Impersonation. Queued processes which have not
+// started yet are run as the new one decides, and fail if their owner may no
+// longer be run as. It must be called from Reconfigure.
+func (s *Scheduler) SetImpersonation(impersonation *process.Impersonation) {
+	s.Impersonation = impersonation
+
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*process.Process)
+		if p.ProcessState == process.Active || process.IsTerminal(p.ProcessState) {
+			continue
+		}
+
+		if err := s.checkImpersonation(p.Owner); err != nil {
+			p.ExitError = err.Error()
+			s.setState(p, process.Failed)
+			continue
+		}
+		p.RunAs = ""
+		if impersonation != nil {
+			p.RunAs = p.Owner
+		}
+	}
+}
+
+func (s *Scheduler) Run() {
+	for {
+		select {
+		case infos := <-s.TargetGpuInfos:
+			s.schedule(infos)
+		case c := <-s.publishCh:
+			processes, err := s.publish(c.request)
+			c.result <- publishResult{processes: processes, err: err}
+		case c := <-s.deleteCh:
+			if len(c.arrayId) != 0 {
+				c.result <- s.deleteArray(c.arrayId)
+			} else if s.delete(c.id) {
+				c.result <- 1
+			} else {
+				c.result <- 0
+			}
+		case c := <-s.listCh:
+			c.result <- s.list(c.id, c.arrayId)
+		case e := <-s.exitCh:
+			s.onExit(e)
+		case c := <-s.workflowCh:
+			c.result <- s.handleWorkflowCommand(c)
+		case c := <-s.terminateCh:
+			s.terminateAllActiveProcess()
+			close(c.done)
+		case c := <-s.reconfigureCh:
+			c.apply()
+			close(c.done)
+		}
+	}
+}
+
+func (s *Scheduler) publish(r *types.ProcessPublishRequest) ([]process.Process, error) {
+	if err := r.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	requests := []types.ProcessPublishRequest{*r}
+	var sweepParams []map[string]string
+	if r.Sweep != nil {
+		var err error
+		if sweepParams, err = r.Sweep.Expand(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+		requests = requests[:0]
+		for i, params := range sweepParams {
+			requests = append(requests, r.Instantiate(i, params))
+		}
+	}
+
+	if err := s.checkImpersonation(r.Owner); err != nil {
+		return nil, err
+	}
+
+	if s.Queue.Len()+len(requests) > s.MaxPendingQueueSize {
+		return nil, fmt.Errorf("%w: failed to publish pending process with queue size overflow", ErrQueueFull)
 	}
 
-	b, err := json.Marshal(processSet)
+	if len(r.Id) != 0 {
+		if _, err := uuid.Parse(r.Id); err != nil {
+			return nil, fmt.Errorf("%w: invalid id %s: %v", ErrInvalidRequest, r.Id, err)
+		}
+		if s.idInUse(r.Id) {
+			return nil, fmt.Errorf("%w: id %s is already used", ErrConflict, r.Id)
+		}
+	}
+
+	dependsOn, err := s.resolveDependencyReferences(r)
 	if err != nil {
 		return nil, err
 	}
 
-	return b, nil
+	arrayId := ""
+	if r.Sweep != nil {
+		arrayId = r.Id
+		if len(arrayId) == 0 {
+			arrayId = uuid.NewString()
+		}
+	}
+
+	var processes []*process.Process
+	for i := range requests {
+		p := s.newProcess(&requests[i])
+		p.DependsOn = dependsOn
+		if r.Sweep != nil {
+			index := i
+			p.ArrayId = arrayId
+			p.ArrayIndex = &index
+			if len(r.Name) != 0 {
+				p.Name = fmt.Sprintf("%s[%d]", r.Name, i)
+			}
+		}
+		processes = append(processes, p)
+	}
+
+	if err := s.checkPublishQuota(processes); err != nil {
+		return nil, err
+	}
+
+	var published []process.Process
+	for _, p := range processes {
+		s.enqueue(p)
+		published = append(published, p.Snapshot())
+	}
+	return published, nil
 }
 
-func (s *Scheduler) Delete(id string) bool {
+// newProcess creates a process for r, defaulting its log paths to LogDir and
+// running it as its owner if Impersonation is set.
+func (s *Scheduler) newProcess(r *types.ProcessPublishRequest) *process.Process {
+	p := process.NewProcess(r)
+	if s.LogDir != nil {
+		stdout, stderr := s.LogDir.JobLogPaths(p.Id)
+		if len(p.LogPath) == 0 {
+			p.LogPath = stdout
+		}
+		if len(p.ErrLogPath) == 0 {
+			p.ErrLogPath = stderr
+		}
+	}
+	if s.Impersonation != nil {
+		p.RunAs = p.Owner
+	}
+	return p
+}
+
+// checkImpersonation refuses processes of owners who they may not be run as.
+// Processes without an owner, published while authentication is disabled,
+// are refused too, as they would run as the user of gpiped.
+func (s *Scheduler) checkImpersonation(owner string) error {
+	if s.Impersonation == nil {
+		return nil
+	}
+	if len(owner) == 0 {
+		return fmt.Errorf("%w: processes are run as their owner, which requires authentication", ErrForbidden)
+	}
+	// Owners without an account would only fail once they are spawned.
+	if _, err := user.Lookup(owner); err != nil {
+		return fmt.Errorf("%w: gpiped can not run processes as %s: %v", ErrForbidden, owner, err)
+	}
+	if !s.Impersonation.Allows(owner) {
+		return fmt.Errorf("%w: gpiped may not run processes as %s", ErrForbidden, owner)
+	}
+	return nil
+}
+
+func (s *Scheduler) idInUse(id string) bool {
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		p := e.Value.(*process.Process)
+		if p.Id == id || p.ArrayId == id {
+			return true
+		}
+	}
+	// Ids of terminated processes still name them in the history, and in
+	// dependencies.
+	return s.History.HasId(id)
+}
+
+func (s *Scheduler) list(id, arrayId string) []process.Process {
+	processes := make([]process.Process, 0)
+
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		queuedProcess := e.Value.(*process.Process)
+		if len(id) != 0 && queuedProcess.Id != id {
+			continue
+		}
+		if len(arrayId) != 0 && queuedProcess.ArrayId != arrayId {
+			continue
+		}
+		processes = append(processes, queuedProcess.Snapshot())
+	}
+
+	return processes
+}
+
+func (s *Scheduler) delete(id string) bool {
 	for e := s.Queue.Front(); e != nil; e = e.Next() {
 		queuedProcess := e.Value.(*process.Process)
 		if queuedProcess.Id == id {
-			s.terminateActiveProcess(queuedProcess)
-			s.Queue.Remove(e)
+			s.remove(e)
+			s.resolveDependencies()
 			return true
 		}
 	}
 	return false
 }
 
-func (s *Scheduler) TerminateAllActiveProcess() {
+func (s *Scheduler) deleteArray(arrayId string) int {
+	deleted := 0
+	var next *list.Element
+	for e := s.Queue.Front(); e != nil; e = next {
+		next = e.Next()
+		queuedProcess := e.Value.(*process.Process)
+		if queuedProcess.ArrayId == arrayId {
+			s.remove(e)
+			deleted++
+		}
+	}
+
+	if deleted != 0 {
+		s.resolveDependencies()
+	}
+	return deleted
+}
+
+// remove takes a process off the queue and records it in the history. A
+// process which hasn't terminated yet is terminated and cancelled first.
+func (s *Scheduler) remove(e *list.Element) {
+	p := e.Value.(*process.Process)
+	if !process.IsTerminal(p.ProcessState) {
+		s.terminateActiveProcess(p)
+		s.setState(p, process.Cancelled)
+	}
+
+	s.Queue.Remove(e)
+	s.History.Add(p.Snapshot())
+}
+
+// terminateAllActiveProcess stops every running process on shutdown. They
+// are cancelled, as none of them got to finish.
+func (s *Scheduler) terminateAllActiveProcess() {
 	for e := s.Queue.Front(); e != nil; e = e.Next() {
-		s.terminateActiveProcess(e.Value.(*process.Process))
+		p := e.Value.(*process.Process)
+		if p.ProcessState == process.Active {
+			s.terminateActiveProcess(p)
+			s.setState(p, process.Cancelled)
+		}
 	}
 }
 
+// terminateActiveProcess sends SIGTERM to p if it is running. It leaves the
+// state of p to the caller, whose transition is the only one recorded; the
+// exit of p is ignored by onExit once p is no longer Active.
 func (s *Scheduler) terminateActiveProcess(p *process.Process) error {
-	if p.ProcessState == process.Active {
-		if err := p.Terminate(); err != nil {
-			return err
-		}
-		p.ProcessState = process.Finished
+	if p.ProcessState != process.Active {
+		return nil
 	}
-	return nil
+	return p.Terminate()
 }
 
-func (s *Scheduler) Run() {
-	for {
-		currentTargetGpuInfos := <-s.TargetGpuInfos
-		for e := s.Queue.Front(); e != nil; e = e.Next() {
-			queuedProcess := e.Value.(*process.Process)
+func (s *Scheduler) onExit(e process.ExitEvent) {
+	for el := s.Queue.Front(); el != nil; el = el.Next() {
+		p := el.Value.(*process.Process)
 
-			if queuedProcess.ProcessState != process.Pending {
-				if queuedProcess.ProcessState == process.Finished {
-					s.Queue.Remove(e)
-				}
-				continue
-			}
+		if p.Id != e.Id {
+			continue
+		}
+		// The process may have been cancelled while it was running.
+		if p.ProcessState != process.Active {
+			break
+		}
 
-			canSpawn := true
+		p.ExitCode = e.ExitCode
+		if e.Err != nil {
+			p.ExitError = e.Err.Error()
+		}
 
-			for _, requestGpuId := range queuedProcess.GpuId {
-				requestGpuIdAvailable := true
+		exited := jobEvent(events.JobExited, p)
+		exited.GpuId = p.GpuId
+		exited.ExitCode = &p.ExitCode
+		exited.Message = p.ExitError
+		s.Events.Publish(exited)
+
+		if e.Success() {
+			s.setState(p, process.Finished)
+			log.Printf("finish to exec %s", e.Id)
+		} else {
+			s.setState(p, process.Failed)
+			log.Printf("%s exited with code %d: %v", e.Id, e.ExitCode, e.Err)
+		}
+		break
+	}
 
-				for _, gpuInfo := range currentTargetGpuInfos {
-					if requestGpuId == gpuInfo.Index {
-						memoryUsageLowWatermark := 0
+	s.resolveDependencies()
 
-						if queuedProcess.MemoryUsageLowWatermark == 0 {
-							memoryUsageLowWatermark = s.defaultMemoryUsageLowWatermark
-						} else {
-							memoryUsageLowWatermark = queuedProcess.MemoryUsageLowWatermark
-						}
-						if memoryUsageLowWatermark < gpuInfo.MemoryUsage {
-							requestGpuIdAvailable = false
-							break
-						}
-					}
-				}
+	// The GPUs held by this process are free now. Ask for a fresh sample
+	// instead of waiting for the next tick of the watcher.
+	s.Watcher.Refresh()
+}
 
-				if !requestGpuIdAvailable {
-					log.Printf("requested GPU ID %d has not be available", requestGpuId)
-					canSpawn = false
-					break
-				}
-			}
+func (s *Scheduler) schedule(currentTargetGpuInfos []gpu.GpuInfo) {
+	s.resolveDependencies()
 
-			if !canSpawn {
-				log.Printf("process can't be executed")
-				continue
+	var next *list.Element
+	for e := s.Queue.Front(); e != nil; e = next {
+		next = e.Next()
+		queuedProcess := e.Value.(*process.Process)
+
+		if queuedProcess.ProcessState != process.Pending {
+			// Terminated processes are kept while blocked processes still
+			// need to know how they ended.
+			if process.IsTerminal(queuedProcess.ProcessState) && !s.hasBlockedDependents(queuedProcess.Id) {
+				s.remove(e)
 			}
-			queuedProcess.ProcessState = process.CanSpawn
+			continue
 		}
 
-		var canSpawnProcess []*process.Process
+		canSpawn := true
 
-		for e := s.Queue.Front(); e != nil; e = e.Next() {
-			queuedProcess := e.Value.(*process.Process)
+		for _, requestGpuId := range queuedProcess.GpuId {
+			requestGpuIdAvailable := true
 
-			if queuedProcess.ProcessState == process.CanSpawn {
-				canSpawnProcess = append(canSpawnProcess, queuedProcess)
+			for _, gpuInfo := range currentTargetGpuInfos {
+				if requestGpuId == gpuInfo.Index {
+					memoryUsageLowWatermark := 0
+
+					if queuedProcess.MemoryUsageLowWatermark == 0 {
+						memoryUsageLowWatermark = s.defaultMemoryUsageLowWatermark
+					} else {
+						memoryUsageLowWatermark = queuedProcess.MemoryUsageLowWatermark
+					}
+					if memoryUsageLowWatermark < gpuInfo.MemoryUsage {
+						requestGpuIdAvailable = false
+						break
+					}
+				}
+			}
+
+			if !requestGpuIdAvailable {
+				log.Printf("requested GPU ID %d has not be available", requestGpuId)
+				canSpawn = false
+				break
 			}
 		}
 
-		if len(canSpawnProcess) == 0 {
-			log.Printf("no ready process")
+		if !canSpawn {
+			log.Printf("process can't be executed")
 			continue
 		}
+		if s.holdByQuota(queuedProcess) {
+			log.Printf("%s is held: %s", queuedProcess.Id, queuedProcess.HoldReason)
+			continue
+		}
+		queuedProcess.SetState(process.CanSpawn)
+	}
 
-		shouldSpawnProcess := s.SchedulePlugin.Select(canSpawnProcess)
-		shouldSpawnProcess.ProcessState = process.Active
-
-		ch := make(chan bool)
-		go shouldSpawnProcess.Spawn(&ch)
-		s.ProcessEventHandler.AddTaskStatusChannel(shouldSpawnProcess.Id, &ch)
+	var canSpawnProcess []*process.Process
 
-		for e := s.Queue.Front(); e != nil; e = e.Next() {
-			queuedProcess := e.Value.(*process.Process)
+	for e := s.Queue.Front(); e != nil; e = e.Next() {
+		queuedProcess := e.Value.(*process.Process)
 
-			if queuedProcess.ProcessState == process.CanSpawn {
-				queuedProcess.ProcessState = process.Pending
-			}
+		if queuedProcess.ProcessState == process.CanSpawn {
+			canSpawnProcess = append(canSpawnProcess, queuedProcess)
 		}
 	}
-}
 
-func (s *Scheduler) OnSuccess(id string) {
-	for e := s.Queue.Front(); e != nil; e = e.Next() {
-		p := e.Value.(*process.Process)
+	if len(canSpawnProcess) == 0 {
+		log.Printf("no ready process")
+		return
+	}
 
-		if p.Id == id {
-			p.ProcessState = process.Finished
-			log.Printf("finish to exec %s", id)
-			break
-		}
+	shouldSpawnProcess := s.SchedulePlugin.Select(canSpawnProcess)
+
+	if err := shouldSpawnProcess.Spawn(s.exitCh, s.logOpener(shouldSpawnProcess)); err != nil {
+		// Spawning would fail the same way on every pass, e.g. for a
+		// command or a user which does not exist, so it is not retried.
+		s.Metrics.SpawnErrors.Inc()
+		log.Printf("failed to spawn %s: %v", shouldSpawnProcess.Id, err)
+		shouldSpawnProcess.ExitError = err.Error()
+		s.setState(shouldSpawnProcess, process.Failed)
+	} else {
+		s.setState(shouldSpawnProcess, process.Active)
+		s.Metrics.Started.Inc()
+		s.Metrics.SchedulingLatency.Observe(time.Since(shouldSpawnProcess.IssuedTime).Seconds())
+
+		spawned := jobEvent(events.JobSpawned, shouldSpawnProcess)
+		spawned.GpuId = shouldSpawnProcess.GpuId
+		s.Events.Publish(spawned)
 	}
-}
 
-func (s *Scheduler) OnError(id string) {
 	for e := s.Queue.Front(); e != nil; e = e.Next() {
-		p := e.Value.(*process.Process)
+		queuedProcess := e.Value.(*process.Process)
 
-		if p.Id == id {
-			p.ProcessState = process.CanSpawn
-			log.Printf("failed to spawn %s", id)
-			break
+		if queuedProcess.ProcessState == process.CanSpawn {
+			queuedProcess.SetState(process.Pending)
 		}
 	}
 }
 
-func NewScheduler(maxPendingQueueSize, gpuInfoRequestInterval, defaultMemoryUsageLowWatermark int, plugin SchedulePlugin) *Scheduler {
-	targetGpuInfos := make(chan []gpu.GpuInfo)
-	watcher := watcher.NewAgent(gpuInfoRequestInterval)
-	go watcher.Run(targetGpuInfos)
+// logOpener returns how the log files of p are opened. LogDir opens and
+// rotates them, except for log paths chosen by the user p is run as: gpiped
+// must not write there with its own permissions, so they are opened as that
+// user and not rotated.
+func (s *Scheduler) logOpener(p *process.Process) process.LogOpener {
+	if s.LogDir == nil {
+		return nil
+	}
+	return func(path string) (io.WriteCloser, error) {
+		stdout, stderr := s.LogDir.JobLogPaths(p.Id)
+		if len(p.RunAs) != 0 && path != stdout && path != stderr {
+			return p.OpenLogFile(path)
+		}
+		return s.LogDir.Open(path)
+	}
+}
 
+func NewScheduler(maxPendingQueueSize, gpuInfoRequestInterval, defaultMemoryUsageLowWatermark int, plugin SchedulePlugin, historyStore *history.Store, logDir *logs.Dir) *Scheduler {
 	if defaultMemoryUsageLowWatermark > 100 {
 		defaultMemoryUsageLowWatermark = 100
 	}
@@ -206,18 +587,34 @@ func NewScheduler(maxPendingQueueSize, gpuInfoRequestInterval, defaultMemoryUsag
 		defaultMemoryUsageLowWatermark = 0
 	}
 
-	scheduler := Scheduler{
+	bus := events.NewBus(events.DefaultBacklogSize)
+
+	agent := watcher.NewAgent(gpuInfoRequestInterval)
+	agent.Events = bus
+	agent.BusyMemoryUsage = defaultMemoryUsageLowWatermark
+	// The scheduler only cares about the current state of GPUs, so a stale
+	// snapshot is replaced rather than queued behind a slow scheduling pass.
+	targetGpuInfos := agent.Broker.Subscribe(1, watcher.DropOldest).C
+	go agent.Run()
+
+	return &Scheduler{
 		Queue:                          list.New(),
-		Watcher:                        watcher,
+		Watcher:                        agent,
 		TargetGpuInfos:                 targetGpuInfos,
 		MaxPendingQueueSize:            maxPendingQueueSize,
 		SchedulePlugin:                 plugin,
+		History:                        historyStore,
+		Events:                         bus,
+		Metrics:                        newMetrics(),
+		LogDir:                         logDir,
 		defaultMemoryUsageLowWatermark: defaultMemoryUsageLowWatermark,
+		publishCh:                      make(chan publishCommand),
+		deleteCh:                       make(chan deleteCommand),
+		listCh:                         make(chan listCommand),
+		exitCh:                         make(chan process.ExitEvent),
+		terminateCh:                    make(chan terminateCommand),
+		reconfigureCh:                  make(chan reconfigureCommand),
+		workflowCh:                     make(chan workflowCommand),
+		workflows:                      make(map[string]*workflow),
 	}
-
-	processEventHandler := NewProcessEventHandler(&scheduler)
-	go processEventHandler.Run()
-
-	scheduler.ProcessEventHandler = processEventHandler
-	return &scheduler
 }
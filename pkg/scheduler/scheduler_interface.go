@@ -20,7 +20,12 @@ type SchedulePlugin interface {
 	Select(canSpawnProcess []*process.Process) *process.Process
 }
 
-type SchedulerCallback interface {
-	OnSuccess(id string)
-	OnError(id string)
+// ProcessObserver is implemented by plugins which account for the processes
+// they select, e.g. for the GPU time used by every user. Its methods are
+// called from the goroutine running the scheduler, like Select.
+type ProcessObserver interface {
+	// ProcessStarted is called when p is spawned.
+	ProcessStarted(p *process.Process)
+	// ProcessStopped is called when p, which was running, terminates.
+	ProcessStopped(p *process.Process)
 }
@@ -0,0 +1,341 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/gpu"
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/logs"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/Shikugawa/gpupipe/pkg/watcher"
+	"github.com/google/uuid"
+)
+
+// firstPlugin selects the first process which can be spawned.
+type firstPlugin struct{}
+
+func (firstPlugin) Select(canSpawnProcess []*process.Process) *process.Process {
+	return canSpawnProcess[0]
+}
+
+// newTestScheduler returns a running scheduler which does not sample GPUs.
+// Every send on the returned channel triggers a scheduling pass. configure
+// runs before the scheduler starts.
+func newTestScheduler(t *testing.T, configure ...func(s *Scheduler)) (*Scheduler, chan<- []gpu.GpuInfo) {
+	t.Helper()
+
+	gpuInfos := make(chan []gpu.GpuInfo)
+	s := &Scheduler{
+		Queue:               list.New(),
+		Watcher:             watcher.NewAgent(1),
+		TargetGpuInfos:      gpuInfos,
+		MaxPendingQueueSize: 1000,
+		SchedulePlugin:      firstPlugin{},
+		History:             history.NewStore(0, 0),
+		Events:              events.NewBus(0),
+		Metrics:             newMetrics(),
+		publishCh:           make(chan publishCommand),
+		deleteCh:            make(chan deleteCommand),
+		listCh:              make(chan listCommand),
+		exitCh:              make(chan process.ExitEvent),
+		terminateCh:         make(chan terminateCommand),
+		workflowCh:          make(chan workflowCommand),
+		reconfigureCh:       make(chan reconfigureCommand),
+		workflows:           make(map[string]*workflow),
+	}
+	for _, c := range configure {
+		c(s)
+	}
+	go s.Run()
+	t.Cleanup(s.TerminateAllActiveProcess)
+	return s, gpuInfos
+}
+
+// waitFor triggers scheduling passes until cond holds, and fails the test if
+// it doesn't within a few seconds.
+func waitFor(t *testing.T, gpuInfos chan<- []gpu.GpuInfo, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		gpuInfos <- nil
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func publishOne(t *testing.T, s *Scheduler, command ...string) process.Process {
+	t.Helper()
+
+	processes, err := s.Publish(&types.ProcessPublishRequest{Command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return processes[0]
+}
+
+func stateOf(s *Scheduler, id string) process.ProcessState {
+	if p, err := s.Get(id); err == nil {
+		return p.ProcessState
+	}
+	return process.Pending
+}
+
+// cancelOnceActive waits until the process id runs and deletes it.
+func cancelOnceActive(t *testing.T, s *Scheduler, gpuInfos chan<- []gpu.GpuInfo, id string) {
+	t.Helper()
+
+	waitFor(t, gpuInfos, func() bool { return stateOf(s, id) == process.Active })
+	if !s.Delete(id) {
+		t.Fatalf("failed to delete %s", id)
+	}
+}
+
+func TestDeleteRecordsOnlyCancelled(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	p := publishOne(t, s, "sleep", "10")
+	cancelOnceActive(t, s, gpuInfos, p.Id)
+
+	removed, ok := s.History.Get(p.Id)
+	if !ok {
+		t.Fatalf("%s is not in the history", p.Id)
+	}
+	var states []process.ProcessState
+	for _, transition := range removed.StateHistory {
+		states = append(states, transition.State)
+	}
+	want := []process.ProcessState{process.Pending, process.Active, process.Cancelled}
+	if len(states) != len(want) {
+		t.Fatalf("got transitions %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("got transitions %v, want %v", states, want)
+		}
+	}
+}
+
+// TestConcurrentCommands publishes, lists and deletes processes from many
+// goroutines while the processes exit. Run it with -race.
+func TestNewProcessDefaultsLogPaths(t *testing.T) {
+	dir, err := logs.NewDir(t.TempDir(), 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Scheduler{LogDir: dir}
+
+	p := s.newProcess(&types.ProcessPublishRequest{Command: []string{"true"}, ErrLogPath: "/tmp/err.log"})
+	stdout, _ := dir.JobLogPaths(p.Id)
+	if p.LogPath != stdout {
+		t.Errorf("got log path %q, want %q", p.LogPath, stdout)
+	}
+	if p.ErrLogPath != "/tmp/err.log" {
+		t.Errorf("explicit error log path was replaced by %q", p.ErrLogPath)
+	}
+
+	s.LogDir = nil
+	if p := s.newProcess(&types.ProcessPublishRequest{Command: []string{"true"}}); len(p.LogPath) != 0 || len(p.ErrLogPath) != 0 {
+		t.Errorf("got log paths %q and %q without a log directory", p.LogPath, p.ErrLogPath)
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	const publishers, perPublisher = 4, 10
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	var idsMu sync.Mutex
+	var ids []string
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perPublisher; j++ {
+				command := []string{"true"}
+				if j%2 == 0 {
+					command = []string{"sleep", "0.01"}
+				}
+				processes, err := s.Publish(&types.ProcessPublishRequest{Command: command})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				idsMu.Lock()
+				ids = append(ids, processes[0].Id)
+				idsMu.Unlock()
+			}
+		}()
+	}
+
+	// Every reader pauses between its calls, like the watcher between
+	// samples. Tight request and reply loops can starve the publishers on a
+	// single CPU.
+	var readers sync.WaitGroup
+	repeat := func(f func()) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				f()
+				select {
+				case <-done:
+					return
+				case <-time.After(time.Millisecond):
+				}
+			}
+		}()
+	}
+	repeat(func() { gpuInfos <- nil })
+	repeat(func() {
+		for _, p := range s.List() {
+			s.Get(p.Id)
+		}
+		s.Query(history.Query{Gpu: -1})
+	})
+	repeat(func() {
+		if processes := s.List(); len(processes) != 0 {
+			s.Delete(processes[len(processes)-1].Id)
+		}
+	})
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	waitFor(t, gpuInfos, func() bool { return len(s.List()) == 0 })
+
+	if len(ids) != publishers*perPublisher {
+		t.Fatalf("published %d processes, want %d", len(ids), publishers*perPublisher)
+	}
+	for _, id := range ids {
+		p, err := s.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !process.IsTerminal(p.ProcessState) {
+			t.Errorf("%s ended in state %s", id, process.ProcessStateToString(p.ProcessState))
+		}
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	s, _ := newTestScheduler(t)
+
+	if _, err := s.Publish(&types.ProcessPublishRequest{Name: "train", Command: []string{"sleep", "10"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		request *types.ProcessPublishRequest
+		want    error
+	}{
+		{&types.ProcessPublishRequest{}, ErrInvalidRequest},
+		{&types.ProcessPublishRequest{Command: []string{"true"}, DependsOn: []types.Dependency{{Job: "unknown"}}}, ErrInvalidRequest},
+		{&types.ProcessPublishRequest{Command: []string{"true"}, Id: "job-1"}, ErrInvalidRequest},
+		{&types.ProcessPublishRequest{Command: []string{"true"}, Name: "train"}, ErrConflict},
+	} {
+		if _, err := s.Publish(tc.request); !errors.Is(err, tc.want) {
+			t.Errorf("%+v: got %v, want %v", *tc.request, err, tc.want)
+		}
+	}
+
+	s.MaxPendingQueueSize = 1
+	if _, err := s.Publish(&types.ProcessPublishRequest{Command: []string{"true"}}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got %v for a full queue, want %v", err, ErrQueueFull)
+	}
+	if _, err := s.Get("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v for an unknown id, want %v", err, ErrNotFound)
+	}
+}
+
+func TestPublishIdOfTerminatedProcess(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	for _, r := range []*types.ProcessPublishRequest{
+		{Id: uuid.NewString(), Command: []string{"true"}},
+		{Id: uuid.NewString(), Command: []string{"true"}, Sweep: &types.Sweep{Mode: types.SweepGrid, Parameters: map[string][]types.SweepValue{"lr": {"0.1"}}}},
+	} {
+		processes, err := s.Publish(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, gpuInfos, func() bool {
+			_, reaped := s.History.Get(processes[0].Id)
+			return reaped
+		})
+
+		// The id still names the process in the history and dependencies.
+		if _, err := s.Publish(&types.ProcessPublishRequest{Id: r.Id, Command: []string{"true"}}); !errors.Is(err, ErrConflict) {
+			t.Errorf("reusing id %s: got %v, want %v", r.Id, err, ErrConflict)
+		}
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t, func(s *Scheduler) { s.MaxPendingQueueSize = 1 })
+
+	p := publishOne(t, s, "sleep", "10")
+	if _, err := s.Publish(&types.ProcessPublishRequest{Command: []string{"true"}}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("publishing to a full queue: got %v", err)
+	}
+
+	s.Reconfigure(func() { s.MaxPendingQueueSize = 2 })
+	if _, err := s.Publish(&types.ProcessPublishRequest{Command: []string{"true"}}); err != nil {
+		t.Errorf("publishing after growing the queue: %v", err)
+	}
+	cancelOnceActive(t, s, gpuInfos, p.Id)
+}
+
+func TestSpawnErrorFailsProcess(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+	sub := s.Events.SubscribeNew(16)
+	defer s.Events.Unsubscribe(sub)
+
+	p := publishOne(t, s, "/nonexistent/gpupipe-command")
+	waitFor(t, gpuInfos, func() bool { return stateOf(s, p.Id) == process.Failed })
+
+	got, err := s.Get(p.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ExitError) == 0 {
+		t.Error("the spawn error was not recorded")
+	}
+	if n := s.Metrics.SpawnErrors.Value(); n != 1 {
+		t.Errorf("got %v spawn errors, want 1", n)
+	}
+
+	for e := range sub.C {
+		if e.Type == events.JobStateChanged && e.JobId == p.Id {
+			if e.State != process.ProcessStateToString(process.Failed) || e.Message != got.ExitError {
+				t.Errorf("got event %+v", e)
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,373 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/google/uuid"
+)
+
+// maxWorkflows bounds how many workflows are remembered. When exceeded, the
+// oldest terminated workflows are forgotten first.
+const maxWorkflows = 100
+
+type workflow struct {
+	id          string
+	name        string
+	createdTime time.Time
+	request     *types.WorkflowPublishRequest
+	// steps holds the latest process of every step, in the order of
+	// request.Steps. Processes stay reachable here after they have been
+	// removed from the queue.
+	steps []*process.Process
+}
+
+func (w *workflow) terminated() bool {
+	for _, p := range w.steps {
+		if !process.IsTerminal(p.ProcessState) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *workflow) state() string {
+	var running, waiting, done, failed, cancelled bool
+	for _, p := range w.steps {
+		switch p.ProcessState {
+		case process.Active:
+			running = true
+		case process.Finished:
+			done = true
+		case process.Failed:
+			failed = true
+		case process.Cancelled:
+			cancelled = true
+		default:
+			waiting = true
+		}
+	}
+
+	switch {
+	case running || (waiting && (done || failed || cancelled)):
+		return "Running"
+	case waiting:
+		return "Pending"
+	case failed:
+		return "Failed"
+	case cancelled:
+		return "Cancelled"
+	default:
+		return "Finished"
+	}
+}
+
+func (w *workflow) status() types.WorkflowStatus {
+	status := types.WorkflowStatus{
+		Id:    w.id,
+		Name:  w.name,
+		Owner: w.request.Owner,
+		State: w.state(),
+		Steps: make([]types.WorkflowStepStatus, 0, len(w.steps)),
+	}
+	for i, p := range w.steps {
+		status.Steps = append(status.Steps, types.WorkflowStepStatus{
+			Name:  w.request.Steps[i].Name,
+			Id:    p.Id,
+			State: process.ProcessStateToString(p.ProcessState),
+		})
+	}
+	return status
+}
+
+// createSteps creates processes for the steps of w whose index is in rerun.
+// Steps which are not rerun keep their current process and must have
+// finished; dependencies on them are settled right away, and the created
+// processes whose dependencies can't be met anymore are returned in
+// cancelled too.
+func (s *Scheduler) createSteps(w *workflow, rerun map[int]bool) (created, cancelled []*process.Process, err error) {
+	stepIndex := make(map[string]int)
+	for i, step := range w.request.Steps {
+		stepIndex[step.Name] = i
+	}
+
+	// Ids are chosen up front so that steps can refer to each other before
+	// their processes exist.
+	ids := make(map[int]string)
+	for i := range w.request.Steps {
+		if rerun[i] {
+			ids[i] = uuid.NewString()
+		}
+	}
+
+	steps := make([]*process.Process, len(w.steps))
+	copy(steps, w.steps)
+
+	for i, step := range w.request.Steps {
+		if !rerun[i] {
+			continue
+		}
+
+		unmet := false
+		var dependsOn []types.Dependency
+		for _, dep := range step.DependsOn {
+			if err := dep.Validate(); err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+			}
+
+			condition := dep.Condition
+			if len(condition) == 0 {
+				condition = types.AfterSuccess
+			}
+
+			if j, ok := stepIndex[dep.Job]; ok {
+				if !rerun[j] {
+					if !dependencySatisfied(condition, steps[j].ProcessState) {
+						unmet = true
+					}
+					continue
+				}
+				dependsOn = append(dependsOn, types.Dependency{Job: ids[j], Condition: condition})
+				continue
+			}
+
+			upstream := s.findProcess(dep.Job)
+			if upstream == nil {
+				return nil, nil, fmt.Errorf("%w: unknown dependency %s of step %s", ErrInvalidRequest, dep.Job, step.Name)
+			}
+			dependsOn = append(dependsOn, types.Dependency{Job: upstream.Id, Condition: condition})
+		}
+
+		step.Id = ids[i]
+		step.DependsOn = dependsOn
+		p := s.newProcess(&step)
+		p.WorkflowId = w.id
+		if unmet {
+			cancelled = append(cancelled, p)
+		}
+		steps[i] = p
+		created = append(created, p)
+	}
+
+	if s.Queue.Len()+len(created) > s.MaxPendingQueueSize {
+		return nil, nil, fmt.Errorf("%w: failed to publish workflow with queue size overflow", ErrQueueFull)
+	}
+	if err := s.checkPublishQuota(created); err != nil {
+		return nil, nil, err
+	}
+
+	w.steps = steps
+	return created, cancelled, nil
+}
+
+// enqueueSteps queues the processes returned by createSteps and cancels the
+// ones which can't run.
+func (s *Scheduler) enqueueSteps(created, cancelled []*process.Process) {
+	for _, p := range created {
+		s.enqueue(p)
+	}
+	for _, p := range cancelled {
+		s.setState(p, process.Cancelled)
+	}
+}
+
+func (s *Scheduler) publishWorkflow(r *types.WorkflowPublishRequest) (*workflow, error) {
+	if err := r.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if err := s.checkImpersonation(r.Owner); err != nil {
+		return nil, err
+	}
+
+	id := r.Id
+	if len(id) == 0 {
+		id = uuid.NewString()
+	} else if _, err := uuid.Parse(id); err != nil {
+		return nil, fmt.Errorf("%w: invalid workflow id %s: %v", ErrInvalidRequest, id, err)
+	} else if _, ok := s.workflows[id]; ok {
+		return nil, fmt.Errorf("%w: workflow id %s is already used", ErrConflict, id)
+	}
+
+	w := &workflow{
+		id:          id,
+		name:        r.Name,
+		createdTime: time.Now(),
+		request:     r,
+		steps:       make([]*process.Process, len(r.Steps)),
+	}
+
+	rerun := make(map[int]bool)
+	for i := range r.Steps {
+		rerun[i] = true
+	}
+
+	created, cancelled, err := s.createSteps(w, rerun)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueueSteps(created, cancelled)
+	s.addWorkflow(w)
+	s.resolveDependencies()
+	return w, nil
+}
+
+func (s *Scheduler) addWorkflow(w *workflow) {
+	s.workflows[w.id] = w
+	s.workflowOrder = append(s.workflowOrder, w.id)
+
+	for len(s.workflowOrder) > maxWorkflows {
+		evicted := false
+		for i, id := range s.workflowOrder {
+			if s.workflows[id].terminated() {
+				delete(s.workflows, id)
+				s.workflowOrder = append(s.workflowOrder[:i], s.workflowOrder[i+1:]...)
+				evicted = true
+				break
+			}
+		}
+		if !evicted {
+			break
+		}
+	}
+}
+
+func (s *Scheduler) cancelWorkflow(id string) (*workflow, error) {
+	w, ok := s.workflows[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown workflow %s", ErrNotFound, id)
+	}
+
+	for _, p := range w.steps {
+		if process.IsTerminal(p.ProcessState) {
+			continue
+		}
+		s.terminateActiveProcess(p)
+		s.setState(p, process.Cancelled)
+	}
+
+	s.resolveDependencies()
+	return w, nil
+}
+
+// retryWorkflow runs every step of a terminated workflow again, except the
+// ones which finished successfully.
+func (s *Scheduler) retryWorkflow(id string) (*workflow, error) {
+	w, ok := s.workflows[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown workflow %s", ErrNotFound, id)
+	}
+	if !w.terminated() {
+		return nil, fmt.Errorf("%w: workflow %s is still running", ErrConflict, id)
+	}
+
+	rerun := make(map[int]bool)
+	for i, p := range w.steps {
+		if p.ProcessState != process.Finished {
+			rerun[i] = true
+		}
+	}
+	if len(rerun) == 0 {
+		return nil, fmt.Errorf("%w: workflow %s has already finished", ErrConflict, id)
+	}
+
+	created, cancelled, err := s.createSteps(w, rerun)
+	if err != nil {
+		return nil, err
+	}
+
+	s.enqueueSteps(created, cancelled)
+	s.resolveDependencies()
+	return w, nil
+}
+
+func (s *Scheduler) handleWorkflowCommand(c workflowCommand) workflowResult {
+	var w *workflow
+	var err error
+
+	switch c.op {
+	case listWorkflows:
+		statuses := make([]types.WorkflowStatus, 0, len(s.workflowOrder))
+		for _, id := range s.workflowOrder {
+			statuses = append(statuses, s.workflows[id].status())
+		}
+		return workflowResult{statuses: statuses}
+	case getWorkflow:
+		if w = s.workflows[c.id]; w == nil {
+			err = fmt.Errorf("%w: unknown workflow %s", ErrNotFound, c.id)
+		}
+	case publishWorkflow:
+		w, err = s.publishWorkflow(c.request)
+	case cancelWorkflow:
+		w, err = s.cancelWorkflow(c.id)
+	case retryWorkflow:
+		w, err = s.retryWorkflow(c.id)
+	}
+
+	if err != nil {
+		return workflowResult{err: err}
+	}
+	return workflowResult{statuses: []types.WorkflowStatus{w.status()}}
+}
+
+func (s *Scheduler) sendWorkflowCommand(c workflowCommand) ([]types.WorkflowStatus, error) {
+	c.result = make(chan workflowResult, 1)
+	s.workflowCh <- c
+	r := <-c.result
+	return r.statuses, r.err
+}
+
+// PublishWorkflow queues every step of r at once. Either all steps are
+// queued or none is.
+func (s *Scheduler) PublishWorkflow(r *types.WorkflowPublishRequest) (types.WorkflowStatus, error) {
+	statuses, err := s.sendWorkflowCommand(workflowCommand{op: publishWorkflow, request: r})
+	if err != nil {
+		return types.WorkflowStatus{}, err
+	}
+	return statuses[0], nil
+}
+
+func (s *Scheduler) ListWorkflows() []types.WorkflowStatus {
+	statuses, _ := s.sendWorkflowCommand(workflowCommand{op: listWorkflows})
+	return statuses
+}
+
+func (s *Scheduler) GetWorkflow(id string) (types.WorkflowStatus, error) {
+	statuses, err := s.sendWorkflowCommand(workflowCommand{op: getWorkflow, id: id})
+	if err != nil {
+		return types.WorkflowStatus{}, err
+	}
+	return statuses[0], nil
+}
+
+func (s *Scheduler) CancelWorkflow(id string) (types.WorkflowStatus, error) {
+	statuses, err := s.sendWorkflowCommand(workflowCommand{op: cancelWorkflow, id: id})
+	if err != nil {
+		return types.WorkflowStatus{}, err
+	}
+	return statuses[0], nil
+}
+
+func (s *Scheduler) RetryWorkflow(id string) (types.WorkflowStatus, error) {
+	statuses, err := s.sendWorkflowCommand(workflowCommand{op: retryWorkflow, id: id})
+	if err != nil {
+		return types.WorkflowStatus{}, err
+	}
+	return statuses[0], nil
+}
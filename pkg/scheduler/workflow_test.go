@@ -0,0 +1,168 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func workflowOf(t *testing.T, s *Scheduler, id string) types.WorkflowStatus {
+	t.Helper()
+
+	for _, status := range s.ListWorkflows() {
+		if status.Id == id {
+			return status
+		}
+	}
+	t.Fatalf("unknown workflow %s", id)
+	return types.WorkflowStatus{}
+}
+
+func stepStates(status types.WorkflowStatus) map[string]string {
+	states := make(map[string]string)
+	for _, step := range status.Steps {
+		states[step.Name] = step.State
+	}
+	return states
+}
+
+func twoSteps(first ...string) *types.WorkflowPublishRequest {
+	return &types.WorkflowPublishRequest{
+		Name: "pipeline",
+		Steps: []types.ProcessPublishRequest{
+			{Name: "prepare", Command: first},
+			{Name: "train", Command: []string{"true"}, DependsOn: []types.Dependency{{Job: "prepare"}}},
+		},
+	}
+}
+
+func TestWorkflowRetry(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+	marker := filepath.Join(t.TempDir(), "prepared")
+
+	w, err := s.PublishWorkflow(twoSteps("test", "-e", marker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.State != "Pending" {
+		t.Errorf("new workflow is %s", w.State)
+	}
+	waitFor(t, gpuInfos, func() bool { return workflowOf(t, s, w.Id).State == "Failed" })
+	if states := stepStates(workflowOf(t, s, w.Id)); states["train"] != "Cancelled" {
+		t.Errorf("train is %s after prepare failed", states["train"])
+	}
+
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RetryWorkflow(w.Id); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, gpuInfos, func() bool { return workflowOf(t, s, w.Id).State == "Finished" })
+
+	if _, err := s.RetryWorkflow(w.Id); err == nil {
+		t.Error("a finished workflow was retried")
+	}
+}
+
+func TestWorkflowCancel(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	w, err := s.PublishWorkflow(twoSteps("sleep", "10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, gpuInfos, func() bool { return stepStates(workflowOf(t, s, w.Id))["prepare"] == "Active" })
+
+	w, err = s.CancelWorkflow(w.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.State != "Cancelled" {
+		t.Errorf("cancelled workflow is %s", w.State)
+	}
+	for name, state := range stepStates(w) {
+		if state != "Cancelled" {
+			t.Errorf("%s is %s", name, state)
+		}
+	}
+}
+
+func TestWorkflowUnknownDependency(t *testing.T) {
+	s, _ := newTestScheduler(t)
+
+	r := twoSteps("true")
+	r.Steps[1].DependsOn = []types.Dependency{{Job: "evaluate"}}
+	if _, err := s.PublishWorkflow(r); err == nil {
+		t.Fatal("a workflow with an unknown dependency was accepted")
+	}
+	if processes := s.List(); len(processes) != 0 {
+		t.Errorf("a rejected workflow queued %d processes", len(processes))
+	}
+}
+
+func TestWorkflowRetryCancelsUnmetSteps(t *testing.T) {
+	s, gpuInfos := newTestScheduler(t)
+
+	// cleanup only runs if prepare fails, which it does not.
+	w, err := s.PublishWorkflow(&types.WorkflowPublishRequest{
+		Name: "pipeline",
+		Steps: []types.ProcessPublishRequest{
+			{Name: "prepare", Command: []string{"true"}},
+			{Name: "train", Command: []string{"false"}},
+			{Name: "cleanup", Command: []string{"true"}, DependsOn: []types.Dependency{{Job: "prepare", Condition: types.AfterFailure}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, gpuInfos, func() bool { return workflowOf(t, s, w.Id).State == "Failed" })
+
+	retried, err := s.RetryWorkflow(w.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cleanup string
+	for _, step := range retried.Steps {
+		if step.Name == "cleanup" {
+			cleanup = step.Id
+		}
+	}
+	waitFor(t, gpuInfos, func() bool { return workflowOf(t, s, w.Id).State == "Failed" })
+
+	// The step cancelled when it is created is published and counted like
+	// any other cancellation.
+	sub, backlog := s.Events.Subscribe(0, 1)
+	defer s.Events.Unsubscribe(sub)
+
+	var got []string
+	for _, e := range backlog {
+		if e.JobId == cleanup {
+			got = append(got, string(e.Type)+" "+e.State)
+		}
+	}
+	want := []string{string(events.JobPublished) + " Pending", string(events.JobStateChanged) + " Cancelled"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	if n := s.Metrics.Cancelled.Value(); n != 2 {
+		t.Errorf("counted %v cancellations, want 2", n)
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/history"
+
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// Routes of the /v1/ API:
+//
+//	GET    /v1/jobs                   queued jobs, filtered with ?array_id= and
+//	                                  ?state=&since=&until=&user=&mine=&gpu=&limit=&offset=
+//	POST   /v1/jobs                   publish a job, returns the created jobs
+//	GET    /v1/jobs/{id}              get a job
+//	DELETE /v1/jobs/{id}              delete a job, terminating it if active
+//	GET    /v1/jobs/{id}/logs         output of a job, ?stream=stdout|stderr,
+//	                                  ?follow=true keeps streaming until it exits
+//	GET    /v1/arrays/{id}            list jobs of an array
+//	DELETE /v1/arrays/{id}            delete every job of an array
+//	GET    /v1/workflows              list workflows
+//	POST   /v1/workflows              publish a workflow
+//	GET    /v1/workflows/{id}         get a workflow
+//	DELETE /v1/workflows/{id}         cancel a workflow
+//	POST   /v1/workflows/{id}/retry   retry a terminated workflow
+//	GET    /v1/history                queued and terminated jobs, filtered with
+//	                                  ?state=&since=&until=&user=&mine=&gpu=&limit=&offset=
+//	GET    /v1/schema                 JSON Schema of task definitions
+//	GET    /v1/webhooks/deliveries    recent webhook deliveries and their outcome
+//	GET    /v1/events                 scheduler and GPU events as Server-Sent
+//	                                  Events, optionally ?type=a,b
+const apiV1Prefix = "/v1/"
+
+type jobsResponse struct {
+	Jobs []process.Process `json:"jobs"`
+}
+
+type historyResponse struct {
+	Jobs  []process.Process `json:"jobs"`
+	Total int               `json:"total"`
+}
+
+type webhookDeliveriesResponse struct {
+	Deliveries []types.WebhookDelivery `json:"deliveries"`
+}
+
+type workflowsResponse struct {
+	Workflows []types.WorkflowStatus `json:"workflows"`
+}
+
+func (s *Server) registerV1(mux *http.ServeMux) {
+	mux.HandleFunc(apiV1Prefix+"jobs", s.handleV1Jobs)
+	mux.HandleFunc(apiV1Prefix+"jobs/", s.handleV1Job)
+	mux.HandleFunc(apiV1Prefix+"arrays/", s.handleV1Array)
+	mux.HandleFunc(apiV1Prefix+"workflows", s.handleV1Workflows)
+	mux.HandleFunc(apiV1Prefix+"workflows/", s.handleV1Workflow)
+	mux.HandleFunc(apiV1Prefix+"history", s.handleV1History)
+	mux.HandleFunc(apiV1Prefix+"schema", s.handleV1Schema)
+	mux.HandleFunc(apiV1Prefix+"quota", s.handleV1Quota)
+	mux.HandleFunc(apiV1Prefix+"events", s.handleV1Events)
+	mux.HandleFunc(apiV1Prefix+"webhooks/deliveries", s.handleV1WebhookDeliveries)
+	mux.HandleFunc(apiV1Prefix, func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, codeNotFound, "unknown path "+r.URL.Path)
+	})
+}
+
+// pathParams returns the path segments following prefix.
+func pathParams(r *http.Request, prefix string) []string {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if len(rest) == 0 {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+func (s *Server) handleV1Jobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		q, err := parseHistoryQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+
+		var ok bool
+		if q.Owner, ok = mineFilter(w, r); !ok {
+			return
+		}
+
+		var jobs []process.Process
+		if arrayId := r.URL.Query().Get("array_id"); len(arrayId) != 0 {
+			jobs = s.schedular.ListArray(arrayId)
+		} else {
+			jobs = s.schedular.List()
+		}
+		jobs, _ = q.Apply(jobs)
+		writeJSON(w, http.StatusOK, jobsResponse{Jobs: redact(r, jobs)})
+	case http.MethodPost:
+		var request types.ProcessPublishRequest
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		clampMemoryUsageLowWatermark(&request)
+		setOwner(r, &request)
+
+		jobs, err := s.schedular.Publish(&request)
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+
+		if len(jobs) == 1 {
+			w.Header().Set("Location", apiV1Prefix+"jobs/"+jobs[0].Id)
+		}
+		writeJSON(w, http.StatusCreated, jobsResponse{Jobs: jobs})
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) handleV1Job(w http.ResponseWriter, r *http.Request) {
+	params := pathParams(r, apiV1Prefix+"jobs/")
+	if len(params) == 2 && params[1] == "logs" {
+		if r.Method != http.MethodGet {
+			writeMethodNotAllowed(w, http.MethodGet)
+			return
+		}
+		s.handleV1JobLogs(w, r, params[0])
+		return
+	}
+
+	if len(params) != 1 {
+		writeError(w, http.StatusNotFound, codeNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	id := params[0]
+
+	switch r.Method {
+	case http.MethodGet:
+		job, err := s.schedular.Get(id)
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+		if !mayInspect(r, job.Owner) {
+			job = job.Redacted()
+		}
+		writeJSON(w, http.StatusOK, job)
+	case http.MethodDelete:
+		job, err := s.schedular.Get(id)
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+		if !s.authorize(w, r, auth.ActionModify, "job "+id, job.Owner) {
+			return
+		}
+
+		if !s.schedular.Delete(id) {
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown job "+id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (s *Server) handleV1Array(w http.ResponseWriter, r *http.Request) {
+	params := pathParams(r, apiV1Prefix+"arrays/")
+	if len(params) != 1 {
+		writeError(w, http.StatusNotFound, codeNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	id := params[0]
+
+	switch r.Method {
+	case http.MethodGet:
+		jobs := s.schedular.ListArray(id)
+		if len(jobs) == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown array "+id)
+			return
+		}
+		writeJSON(w, http.StatusOK, jobsResponse{Jobs: redact(r, jobs)})
+	case http.MethodDelete:
+		jobs := s.schedular.ListArray(id)
+		if len(jobs) == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown array "+id)
+			return
+		}
+		// Every job of an array is published by the same request, so they
+		// share their owner.
+		if !s.authorize(w, r, auth.ActionModify, "array "+id, jobs[0].Owner) {
+			return
+		}
+
+		if s.schedular.DeleteArray(id) == 0 {
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown array "+id)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+	}
+}
+
+func (s *Server) handleV1Workflows(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, workflowsResponse{Workflows: s.schedular.ListWorkflows()})
+	case http.MethodPost:
+		var request types.WorkflowPublishRequest
+		if !decodeRequest(w, r, &request) {
+			return
+		}
+
+		for i := range request.Steps {
+			clampMemoryUsageLowWatermark(&request.Steps[i])
+			setOwner(r, &request.Steps[i])
+		}
+		if identity, ok := auth.FromContext(r.Context()); ok {
+			request.Owner = identity.User
+		}
+
+		status, err := s.schedular.PublishWorkflow(&request)
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+
+		w.Header().Set("Location", apiV1Prefix+"workflows/"+status.Id)
+		writeJSON(w, http.StatusCreated, status)
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+func (s *Server) handleV1Workflow(w http.ResponseWriter, r *http.Request) {
+	params := pathParams(r, apiV1Prefix+"workflows/")
+	if len(params) == 2 && params[1] == "retry" {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !s.authorizeWorkflow(w, r, params[0]) {
+			return
+		}
+
+		status, err := s.schedular.RetryWorkflow(params[0])
+		if err != nil {
+			writeSchedulerError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	}
+
+	if len(params) != 1 {
+		writeError(w, http.StatusNotFound, codeNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+
+	var status types.WorkflowStatus
+	var err error
+
+	switch r.Method {
+	case http.MethodGet:
+		status, err = s.schedular.GetWorkflow(params[0])
+	case http.MethodDelete:
+		if !s.authorizeWorkflow(w, r, params[0]) {
+			return
+		}
+		status, err = s.schedular.CancelWorkflow(params[0])
+	default:
+		writeMethodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		return
+	}
+
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleV1History(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	var ok bool
+	if q.Owner, ok = mineFilter(w, r); !ok {
+		return
+	}
+
+	jobs, total := s.schedular.Query(q)
+	writeJSON(w, http.StatusOK, historyResponse{Jobs: redact(r, jobs), Total: total})
+}
+
+func parseHistoryQuery(values url.Values) (history.Query, error) {
+	q := history.Query{Gpu: -1, User: values.Get("user")}
+
+	if states := values.Get("state"); len(states) != 0 {
+		for _, name := range strings.Split(states, ",") {
+			state, err := process.ParseProcessState(strings.TrimSpace(name))
+			if err != nil {
+				return q, err
+			}
+			q.States = append(q.States, state)
+		}
+	}
+
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := values.Get(name); len(v) != 0 {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time: %v", name, err)
+			}
+			*t = parsed
+		}
+	}
+
+	for name, n := range map[string]*int{"gpu": &q.Gpu, "limit": &q.Limit, "offset": &q.Offset} {
+		if v := values.Get(name); len(v) != 0 {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 0 {
+				return q, fmt.Errorf("%s must be a non-negative integer", name)
+			}
+			*n = parsed
+		}
+	}
+
+	return q, nil
+}
+
+func (s *Server) handleV1WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, webhookDeliveriesResponse{Deliveries: s.notifier.Deliveries()})
+}
+
+func (s *Server) handleV1Schema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(types.ProcessPublishRequestSchema)
+}
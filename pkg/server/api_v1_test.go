@@ -0,0 +1,385 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/history"
+	"github.com/Shikugawa/gpupipe/pkg/metrics"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler/plugin"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+	"github.com/google/uuid"
+)
+
+// newTestServer serves the API of a running scheduler without
+// authentication. GPUs are sampled only once an hour, so published jobs stay
+// pending.
+func newTestServer(t *testing.T) (*httptest.Server, *scheduler.Scheduler) {
+	t.Helper()
+	return newAuthTestServer(t, nil, nil)
+}
+
+// newAuthTestServer is newTestServer with requests authenticated by tokens
+// and denials recorded in audit.
+func newAuthTestServer(t *testing.T, tokens *auth.Tokens, audit *auth.AuditLog) (*httptest.Server, *scheduler.Scheduler) {
+	t.Helper()
+
+	s := newTestAPI(t, tokens, audit)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return ts, s.schedular
+}
+
+// newTestAPI returns a Server of a running scheduler which is not serving
+// yet.
+func newTestAPI(t *testing.T, tokens *auth.Tokens, audit *auth.AuditLog) *Server {
+	t.Helper()
+
+	sched := scheduler.NewScheduler(10, 3600, 0, plugin.NewFifoPlugin(), history.NewStore(0, 0), nil)
+	go sched.Run()
+
+	n := notify.NewNotifier(notify.Config{}, sched.Events, sched.Get)
+	go n.Run()
+	return NewServer(sched, n, tokens, audit)
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON response
+// into out unless it is nil.
+func do(t *testing.T, method, url, body string, out interface{}) *http.Response {
+	t.Helper()
+	return doWithHeader(t, nil, method, url, body, out)
+}
+
+// doWithHeader is do with additional request headers.
+func doWithHeader(t *testing.T, header http.Header, method, url, body string, out interface{}) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if len(body) != 0 {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return resp
+}
+
+func TestV1Jobs(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	var created jobsResponse
+	resp := do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["sleep", "10"]}`, &created)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("publish returned %d", resp.StatusCode)
+	}
+	id := created.Jobs[0].Id
+	if location := resp.Header.Get("Location"); location != "/v1/jobs/"+id {
+		t.Errorf("got Location %s", location)
+	}
+
+	var listed jobsResponse
+	do(t, http.MethodGet, ts.URL+"/v1/jobs", "", &listed)
+	if len(listed.Jobs) != 1 || listed.Jobs[0].Id != id {
+		t.Errorf("got jobs %v", listed.Jobs)
+	}
+
+	if resp := do(t, http.MethodGet, ts.URL+"/v1/jobs/"+id, "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("get returned %d", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodDelete, ts.URL+"/v1/jobs/"+id, "", nil); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete returned %d", resp.StatusCode)
+	}
+	if resp := do(t, http.MethodDelete, ts.URL+"/v1/jobs/"+id, "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("deleting again returned %d", resp.StatusCode)
+	}
+
+	var deleted process.Process
+	if resp := do(t, http.MethodGet, ts.URL+"/v1/jobs/"+id, "", &deleted); resp.StatusCode != http.StatusOK {
+		t.Fatalf("getting a deleted job returned %d", resp.StatusCode)
+	}
+	if deleted.ProcessState != process.Cancelled {
+		t.Errorf("deleted job is %s", process.ProcessStateToString(deleted.ProcessState))
+	}
+}
+
+func TestV1Errors(t *testing.T) {
+	ts, _ := newTestServer(t)
+	id := uuid.NewString()
+
+	if resp := do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"id": "`+id+`", "command": ["true"]}`, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("publish returned %d", resp.StatusCode)
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+		code               string
+	}{
+		{http.MethodPost, "/v1/jobs", `{"command": ["true"], "gpus": [0]}`, http.StatusBadRequest, codeInvalidRequest},
+		{http.MethodPost, "/v1/jobs", `{"command": []}`, http.StatusBadRequest, codeInvalidRequest},
+		{http.MethodPost, "/v1/jobs", `{"command": ["true"], "notify": {"webhooks": [{"url": "http://127.0.0.1:8080/"}]}}`, http.StatusBadRequest, codeInvalidRequest},
+		{http.MethodPost, "/v1/jobs", `{"id": "` + id + `", "command": ["true"]}`, http.StatusConflict, codeConflict},
+		{http.MethodGet, "/v1/jobs/" + uuid.NewString(), "", http.StatusNotFound, codeNotFound},
+		{http.MethodGet, "/v1/arrays/" + uuid.NewString(), "", http.StatusNotFound, codeNotFound},
+		{http.MethodGet, "/v1/workflows/" + uuid.NewString(), "", http.StatusNotFound, codeNotFound},
+		{http.MethodPut, "/v1/jobs", "", http.StatusMethodNotAllowed, codeMethodNotAllowed},
+		{http.MethodGet, "/v1/unknown", "", http.StatusNotFound, codeNotFound},
+	} {
+		var body types.ErrorResponse
+		resp := do(t, tc.method, ts.URL+tc.path, tc.body, &body)
+		if resp.StatusCode != tc.status || body.Error.Code != tc.code {
+			t.Errorf("%s %s %s: got %d %s, want %d %s", tc.method, tc.path, tc.body,
+				resp.StatusCode, body.Error.Code, tc.status, tc.code)
+		}
+		if tc.status == http.StatusMethodNotAllowed && len(resp.Header["Allow"]) == 0 {
+			t.Errorf("%s %s: no Allow header", tc.method, tc.path)
+		}
+	}
+}
+
+func TestV1Workflows(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	var status types.WorkflowStatus
+	resp := do(t, http.MethodPost, ts.URL+"/v1/workflows", `{
+		"name": "pipeline",
+		"steps": [
+			{"name": "prepare", "command": ["sleep", "10"]},
+			{"name": "train", "command": ["true"], "depends_on": [{"job": "prepare"}]}
+		]
+	}`, &status)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("publish returned %d", resp.StatusCode)
+	}
+
+	if resp := do(t, http.MethodPost, ts.URL+"/v1/workflows/"+status.Id+"/retry", "", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("retrying a pending workflow returned %d", resp.StatusCode)
+	}
+
+	do(t, http.MethodDelete, ts.URL+"/v1/workflows/"+status.Id, "", &status)
+	if status.State != "Cancelled" {
+		t.Errorf("cancelled workflow is %s", status.State)
+	}
+
+	var listed workflowsResponse
+	do(t, http.MethodGet, ts.URL+"/v1/workflows", "", &listed)
+	if len(listed.Workflows) != 1 || listed.Workflows[0].Id != status.Id {
+		t.Errorf("got workflows %v", listed.Workflows)
+	}
+}
+
+func TestV1History(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	var created jobsResponse
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["sleep", "10"], "target_gpu": [0]}`, &created)
+	deleted := created.Jobs[0].Id
+	do(t, http.MethodDelete, ts.URL+"/v1/jobs/"+deleted, "", nil)
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["sleep", "10"], "target_gpu": [1]}`, &created)
+	queued := created.Jobs[0].Id
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{deleted, queued}},
+		{"?state=Cancelled", []string{deleted}},
+		{"?gpu=1", []string{queued}},
+		{"?limit=1&offset=1", []string{queued}},
+	} {
+		var body historyResponse
+		if resp := do(t, http.MethodGet, ts.URL+"/v1/history"+tc.query, "", &body); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got %d", tc.query, resp.StatusCode)
+			continue
+		}
+		var ids []string
+		for _, job := range body.Jobs {
+			ids = append(ids, job.Id)
+		}
+		if !reflect.DeepEqual(ids, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, ids, tc.want)
+		}
+	}
+
+	var listed jobsResponse
+	do(t, http.MethodGet, ts.URL+"/v1/jobs?gpu=0", "", &listed)
+	if len(listed.Jobs) != 0 {
+		t.Errorf("/v1/jobs?gpu=0 listed %v", listed.Jobs)
+	}
+
+	for _, query := range []string{"?state=Sleeping", "?since=yesterday", "?limit=-1"} {
+		if resp := do(t, http.MethodGet, ts.URL+"/v1/history"+query, "", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %d", query, resp.StatusCode)
+		}
+		if resp := do(t, http.MethodGet, ts.URL+"/v1/jobs"+query, "", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("/v1/jobs%s: got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+func TestV1JobLogs(t *testing.T) {
+	ts, sched := newTestServer(t)
+
+	var created jobsResponse
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["sleep", "10"]}`, &created)
+	job, err := sched.Get(created.Jobs[0].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.StdoutTail.Write([]byte("epoch 1\n"))
+	job.StderrTail.Write([]byte("warning\n"))
+
+	read := func(query string) string {
+		resp, err := http.Get(ts.URL + "/v1/jobs/" + job.Id + "/logs" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got %d %s", query, resp.StatusCode, b)
+		}
+		return string(b)
+	}
+
+	if got := read(""); got != "epoch 1\n" {
+		t.Errorf("got stdout %q", got)
+	}
+	if got := read("?stream=stderr"); got != "warning\n" {
+		t.Errorf("got stderr %q", got)
+	}
+
+	followed := make(chan string)
+	go func() {
+		resp, err := http.Get(ts.URL + "/v1/jobs/" + job.Id + "/logs?follow=true")
+		if err != nil {
+			followed <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		followed <- string(b)
+	}()
+	// The follower sees what is written after it connected, and stops once
+	// the output is closed.
+	time.Sleep(50 * time.Millisecond)
+	job.StdoutTail.Write([]byte("epoch 2\n"))
+	job.StdoutTail.Close()
+	if got := <-followed; got != "epoch 1\nepoch 2\n" {
+		t.Errorf("followed %q", got)
+	}
+
+	for _, query := range []string{"?stream=stdin", "?follow=maybe"} {
+		if resp := do(t, http.MethodGet, ts.URL+"/v1/jobs/"+job.Id+"/logs"+query, "", nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: got %d", query, resp.StatusCode)
+		}
+	}
+	if resp := do(t, http.MethodGet, ts.URL+"/v1/jobs/"+uuid.NewString()+"/logs", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("logs of an unknown job: got %d", resp.StatusCode)
+	}
+}
+
+func TestV1WebhookDeliveries(t *testing.T) {
+	s := newTestAPI(t, nil, nil)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer hook.Close()
+
+	// Jobs may not point webhooks at the loopback address, but the daemon
+	// may.
+	s.notifier.SetConfig(notify.Config{Webhooks: []notify.WebhookConfig{{Webhook: types.Webhook{
+		Url:    hook.URL + "/secret-path",
+		Events: []types.NotifyEvent{types.NotifyPublished},
+	}}}})
+
+	var created jobsResponse
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &created)
+
+	var got webhookDeliveriesResponse
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		do(t, http.MethodGet, ts.URL+"/v1/webhooks/deliveries", "", &got)
+		if len(got.Deliveries) == 1 && got.Deliveries[0].Delivered {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got deliveries %+v", got.Deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d := got.Deliveries[0]
+	if d.JobId != created.Jobs[0].Id || d.Event != types.NotifyPublished {
+		t.Errorf("got delivery %+v", d)
+	}
+	if strings.Contains(d.Target, "secret-path") {
+		t.Errorf("delivery target %q leaks the webhook path", d.Target)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ts, sched := newTestServer(t)
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, nil)
+
+	rec := httptest.NewRecorder()
+	NewServer(sched, nil, nil, nil).handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("got content type %q", ct)
+	}
+	for _, line := range []string{
+		"gpupipe_jobs_published_total 1",
+		`gpupipe_queue_jobs{state="` + process.ProcessStateToString(process.Pending) + `"} 1`,
+		"# TYPE gpupipe_scheduling_latency_seconds histogram",
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("%q is missing from\n%s", line, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+)
+
+// authenticate rejects requests without valid credentials, and requests to
+// change anything from read-only users. The identity of the others, taken from
+// the Unix socket they connected to, their client certificate or their bearer
+// token, is passed on in their context.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens := s.currentTokens()
+
+		var identity auth.Identity
+		var err error
+		if p, ok := peerFromContext(r.Context()); ok {
+			// Callers on the Unix socket are who the kernel says they are,
+			// whether or not tokens are configured.
+			identity, err = p.identity, p.err
+		} else if cert, ok := clientCertificate(r); ok {
+			// So are clients with a certificate verified against the
+			// client CAs.
+			identity, err = auth.CertificateIdentity(cert, tokens)
+		} else if tokens == nil {
+			next.ServeHTTP(w, r)
+			return
+		} else {
+			identity, err = tokens.Authenticate(r)
+		}
+		if err != nil {
+			s.auditDenial(r, auth.Identity{}, "", "", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gpupipe"`)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, err.Error())
+			return
+		}
+
+		// Anything but reading needs at least the right to publish.
+		// Handlers check ownership on top of that.
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if err := auth.Authorize(identity, auth.ActionPublish, ""); err != nil {
+				s.auditDenial(r, identity, auth.ActionPublish, r.URL.Path, err)
+				writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), identity)))
+	})
+}
+
+// authorize checks that the sender of r may perform action on resource, which
+// is owned by owner. Denied requests are audited and answered with 403.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, action auth.Action, resource, owner string) bool {
+	identity, ok := auth.FromContext(r.Context())
+	if !ok {
+		return true
+	}
+
+	if err := auth.Authorize(identity, action, owner); err != nil {
+		s.auditDenial(r, identity, action, resource, err)
+		writeError(w, http.StatusForbidden, codeForbidden, err.Error())
+		return false
+	}
+	return true
+}
+
+// mayInspect reports whether the sender of r may see the secrets of what owner
+// published.
+func mayInspect(r *http.Request, owner string) bool {
+	identity, ok := auth.FromContext(r.Context())
+	return !ok || auth.Authorize(identity, auth.ActionInspect, owner) == nil
+}
+
+// redact replaces the processes the sender of r may not inspect with their
+// redacted copies.
+func redact(r *http.Request, processes []process.Process) []process.Process {
+	for i := range processes {
+		if !mayInspect(r, processes[i].Owner) {
+			processes[i] = processes[i].Redacted()
+		}
+	}
+	return processes
+}
+
+func (s *Server) authorizeWorkflow(w http.ResponseWriter, r *http.Request, id string) bool {
+	status, err := s.schedular.GetWorkflow(id)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return false
+	}
+	return s.authorize(w, r, auth.ActionModify, "workflow "+id, status.Owner)
+}
+
+func (s *Server) auditDenial(r *http.Request, identity auth.Identity, action auth.Action, resource string, reason error) {
+	if s.audit == nil {
+		return
+	}
+	s.audit.Record(auth.AuditRecord{
+		User:       identity.User,
+		Role:       identity.Role,
+		Action:     action,
+		Resource:   resource,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: r.RemoteAddr,
+		Reason:     reason.Error(),
+	})
+}
+
+// mineFilter returns the user whose jobs are selected by ?mine=true, or an
+// empty string if every job is. Selecting my jobs needs authentication.
+func mineFilter(w http.ResponseWriter, r *http.Request) (string, bool) {
+	value := r.URL.Query().Get("mine")
+	if len(value) == 0 {
+		return "", true
+	}
+
+	mine, err := strconv.ParseBool(value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid mine "+value)
+		return "", false
+	}
+	if !mine {
+		return "", true
+	}
+
+	identity, ok := auth.FromContext(r.Context())
+	if !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "mine requires gpiped to authenticate requests")
+		return "", false
+	}
+	return identity.User, true
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/quota"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func testTokens(t *testing.T) *auth.Tokens {
+	t.Helper()
+
+	tokens, err := auth.NewTokens([]auth.TokenEntry{
+		{User: "shimizu", Role: auth.RoleAdmin, Token: "admin-token"},
+		{User: "rei", Role: auth.RoleUser, Token: "user-token"},
+		{User: "viewer", Role: auth.RoleReadOnly, Token: "read-only-token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// doAs is do with the bearer token of a user, or without credentials if
+// token is empty.
+func doAs(t *testing.T, token, method, url, body string, out interface{}) *http.Response {
+	t.Helper()
+
+	var header http.Header
+	if len(token) != 0 {
+		header = http.Header{"Authorization": {"Bearer " + token}}
+	}
+	return doWithHeader(t, header, method, url, body, out)
+}
+
+func TestAuthenticate(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	for _, token := range []string{"", "wrong-token"} {
+		resp := doAs(t, token, http.MethodGet, ts.URL+"/v1/jobs", "", nil)
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("token %q: got %d", token, resp.StatusCode)
+		}
+		if len(resp.Header.Get("WWW-Authenticate")) == 0 {
+			t.Errorf("token %q: no WWW-Authenticate challenge", token)
+		}
+	}
+
+	if resp := doAs(t, "user-token", http.MethodGet, ts.URL+"/v1/jobs", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("valid token: got %d", resp.StatusCode)
+	}
+}
+
+func TestMetricsRequireAuthentication(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	if resp := doAs(t, "", http.MethodGet, ts.URL+"/metrics", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a token: got %d", resp.StatusCode)
+	}
+	if resp := doAs(t, "read-only-token", http.MethodGet, ts.URL+"/metrics", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("read-only token: got %d", resp.StatusCode)
+	}
+}
+
+func TestPublishRecordsOwner(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	// The user given by the client is replaced by the authenticated one.
+	var created jobsResponse
+	doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"], "user": "shimizu"}`, &created)
+	if job := created.Jobs[0]; job.Owner != "rei" || job.User != "rei" {
+		t.Errorf("got owner %q and user %q, want rei", job.Owner, job.User)
+	}
+
+	// Clients can not choose the owner.
+	resp := doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"], "owner": "shimizu"}`, nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("publishing with an owner: got %d", resp.StatusCode)
+	}
+}
+
+// readAudit returns the records written to the audit log at path.
+func readAudit(t *testing.T, path string) []auth.AuditRecord {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []auth.AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		var record auth.AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestRoles(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	f, err := os.Create(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ts, _ := newAuthTestServer(t, testTokens(t), auth.NewAuditLog(f))
+
+	var created jobsResponse
+	doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["sleep", "10"]}`, &created)
+	owned := created.Jobs[0].Id
+	doAs(t, "admin-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["sleep", "10"]}`, &created)
+	others := created.Jobs[0].Id
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"read-only reads", "read-only-token", http.MethodGet, "/v1/jobs/" + owned, "", http.StatusOK},
+		{"read-only publishes", "read-only-token", http.MethodPost, "/v1/jobs", `{"command": ["true"]}`, http.StatusForbidden},
+		{"read-only deletes", "read-only-token", http.MethodDelete, "/v1/jobs/" + owned, "", http.StatusForbidden},
+		{"user deletes a job of another user", "user-token", http.MethodDelete, "/v1/jobs/" + others, "", http.StatusForbidden},
+		{"user deletes through the old API", "user-token", http.MethodPost, "/delete", `{"id": "` + others + `"}`, http.StatusForbidden},
+		{"user deletes an own job", "user-token", http.MethodDelete, "/v1/jobs/" + owned, "", http.StatusNoContent},
+		{"admin deletes a job of another user", "admin-token", http.MethodDelete, "/v1/jobs/" + others, "", http.StatusNoContent},
+	} {
+		if resp := doAs(t, tc.token, tc.method, ts.URL+tc.path, tc.body, nil); resp.StatusCode != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, resp.StatusCode, tc.want)
+		}
+	}
+
+	records := readAudit(t, auditPath)
+	if len(records) != 4 {
+		t.Fatalf("got %d audit records, want one per denial: %+v", len(records), records)
+	}
+	if r := records[2]; r.User != "rei" || r.Action != auth.ActionModify || r.Resource != "job "+others {
+		t.Errorf("got audit record %+v", r)
+	}
+}
+
+func TestWorkflowOwner(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	var status types.WorkflowStatus
+	doAs(t, "admin-token", http.MethodPost, ts.URL+"/v1/workflows", `{"name": "w", "steps": [{"name": "a", "command": ["sleep", "10"]}]}`, &status)
+	if status.Owner != "shimizu" {
+		t.Errorf("got owner %q", status.Owner)
+	}
+
+	if resp := doAs(t, "user-token", http.MethodDelete, ts.URL+"/v1/workflows/"+status.Id, "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("user cancelled the workflow of an admin: got %d", resp.StatusCode)
+	}
+	if resp := doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/workflows/"+status.Id+"/retry", "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("user retried the workflow of an admin: got %d", resp.StatusCode)
+	}
+}
+
+func TestMine(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	var created jobsResponse
+	doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &created)
+	mine := created.Jobs[0].Id
+	doAs(t, "admin-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, nil)
+
+	for _, path := range []string{"/v1/jobs?mine=true", "/v1/history?mine=true"} {
+		var listed jobsResponse
+		doAs(t, "user-token", http.MethodGet, ts.URL+path, "", &listed)
+		if len(listed.Jobs) != 1 || listed.Jobs[0].Id != mine {
+			t.Errorf("%s: got %+v", path, listed.Jobs)
+		}
+	}
+
+	var legacy map[string][]process.Process
+	doAs(t, "user-token", http.MethodGet, ts.URL+"/list?mine=true", "", &legacy)
+	if processes := legacy["processes"]; len(processes) != 1 || processes[0].Id != mine {
+		t.Errorf("/list: got %+v", processes)
+	}
+
+	if resp := doAs(t, "user-token", http.MethodGet, ts.URL+"/v1/jobs?mine=maybe", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid mine: got %d", resp.StatusCode)
+	}
+}
+
+func TestRedactedJobs(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	var created jobsResponse
+	doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"], "env": {"API_KEY": "secret"}, "notify": {"webhooks": [{"url": "https://hooks.example.com/secret"}]}}`, &created)
+	id := created.Jobs[0].Id
+
+	redacted := func(p process.Process) bool {
+		return p.Env["API_KEY"] != "secret" && p.Notify.Webhooks[0].Url == "https://hooks.example.com"
+	}
+
+	for _, tc := range []struct {
+		token        string
+		wantRedacted bool
+	}{
+		{"user-token", false},
+		{"admin-token", false},
+		{"read-only-token", true},
+	} {
+		var listed, history jobsResponse
+		var job process.Process
+		var legacy map[string][]process.Process
+		doAs(t, tc.token, http.MethodGet, ts.URL+"/v1/jobs", "", &listed)
+		doAs(t, tc.token, http.MethodGet, ts.URL+"/v1/history", "", &history)
+		doAs(t, tc.token, http.MethodGet, ts.URL+"/v1/jobs/"+id, "", &job)
+		doAs(t, tc.token, http.MethodGet, ts.URL+"/list", "", &legacy)
+
+		for name, p := range map[string]process.Process{
+			"/v1/jobs":      listed.Jobs[0],
+			"/v1/history":   history.Jobs[0],
+			"/v1/jobs/{id}": job,
+			"/list":         legacy["processes"][0],
+		} {
+			if got := redacted(p); got != tc.wantRedacted {
+				t.Errorf("%s %s: got env %v and webhooks %+v", tc.token, name, p.Env, p.Notify.Webhooks)
+			}
+		}
+	}
+}
+
+func TestV1JobLogsOfOtherOwners(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+
+	var created jobsResponse
+	doAs(t, "admin-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &created)
+	url := ts.URL + "/v1/jobs/" + created.Jobs[0].Id + "/logs"
+
+	for token, want := range map[string]int{
+		"user-token":      http.StatusForbidden,
+		"read-only-token": http.StatusForbidden,
+		"admin-token":     http.StatusOK,
+	} {
+		if resp := doAs(t, token, http.MethodGet, url, "", nil); resp.StatusCode != want {
+			t.Errorf("%s: got %d, want %d", token, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestMineRequiresAuthentication(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	if resp := do(t, http.MethodGet, ts.URL+"/v1/jobs?mine=true", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d", resp.StatusCode)
+	}
+}
+
+func TestV1Quota(t *testing.T) {
+	ts, sched := newAuthTestServer(t, testTokens(t), nil)
+	sched.Quotas = quota.NewQuotas(quota.Config{Users: map[string]quota.Limits{"rei": {MaxGpus: 2}}})
+
+	var report quota.Report
+	if resp := doAs(t, "user-token", http.MethodGet, ts.URL+"/v1/quota", "", &report); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	if report.User != "rei" || report.Limits.MaxGpus != 2 {
+		t.Errorf("quota of the sender: got %+v", report)
+	}
+
+	report = quota.Report{}
+	doAs(t, "user-token", http.MethodGet, ts.URL+"/v1/quota?user=shimizu", "", &report)
+	if report.User != "shimizu" || report.Limits.MaxGpus != 0 {
+		t.Errorf("quota of another user: got %+v", report)
+	}
+}
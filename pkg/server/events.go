@@ -0,0 +1,139 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/events"
+)
+
+const (
+	// eventsBufferSize is how far a client may fall behind before its
+	// stream is closed. It reconnects and resumes from the backlog.
+	eventsBufferSize = 256
+	// eventsKeepAlive is how often a comment is sent on an idle stream so
+	// that proxies do not time it out.
+	eventsKeepAlive = 15 * time.Second
+	// eventsRetry is the reconnection delay suggested to clients.
+	eventsRetry = 3 * time.Second
+)
+
+// handleV1Events streams events as Server-Sent Events from now on. A client
+// resumes after the event given in the Last-Event-ID header, or the
+// last_event_id parameter for clients which cannot set headers.
+// Events of jobs are only streamed to their owners and admins.
+func (s *Server) handleV1Events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "streaming is not supported")
+		return
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if len(lastEventId) == 0 {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if len(lastEventId) != 0 {
+		var err error
+		if after, err = strconv.ParseUint(lastEventId, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid last event id "+lastEventId)
+			return
+		}
+	}
+
+	wanted := make(map[events.Type]bool)
+	if value := r.URL.Query().Get("type"); len(value) != 0 {
+		for _, t := range strings.Split(value, ",") {
+			wanted[events.Type(strings.TrimSpace(t))] = true
+		}
+	}
+
+	var sub *events.Subscription
+	var missed []events.Event
+	if len(lastEventId) != 0 {
+		sub, missed = s.schedular.Events.Subscribe(after, eventsBufferSize)
+	} else {
+		sub = s.schedular.Events.SubscribeNew(eventsBufferSize)
+	}
+	defer s.schedular.Events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", eventsRetry.Milliseconds())
+
+	identity, authenticated := auth.FromContext(r.Context())
+
+	write := func(e events.Event) bool {
+		if len(wanted) != 0 && !wanted[e.Type] {
+			return true
+		}
+		if authenticated && len(e.JobId) != 0 && auth.Authorize(identity, auth.ActionInspect, e.Owner) != nil {
+			return true
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Println(err)
+			return true
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, b)
+		return err == nil
+	}
+
+	for _, e := range missed {
+		if !write(e) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if !write(e) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		}
+		flusher.Flush()
+	}
+}
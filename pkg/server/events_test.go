@@ -0,0 +1,169 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+// eventStream reads Server-Sent Events from a GET /v1/events response.
+type eventStream struct {
+	resp    *http.Response
+	scanner *bufio.Scanner
+}
+
+func openEventStream(t *testing.T, url, lastEventId string) *eventStream {
+	t.Helper()
+	return openEventStreamAs(t, "", url, lastEventId)
+}
+
+// openEventStreamAs is openEventStream with the bearer token of a user, or
+// without credentials if token is empty.
+func openEventStreamAs(t *testing.T, token, url, lastEventId string) *eventStream {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lastEventId) != 0 {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	if len(token) != 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+	return &eventStream{resp: resp, scanner: bufio.NewScanner(resp.Body)}
+}
+
+// next returns the next event, skipping comments and the retry hint.
+func (s *eventStream) next(t *testing.T) events.Event {
+	t.Helper()
+
+	received := make(chan events.Event, 1)
+	go func() {
+		for s.scanner.Scan() {
+			if data := strings.TrimPrefix(s.scanner.Text(), "data: "); data != s.scanner.Text() {
+				var e events.Event
+				if json.Unmarshal([]byte(data), &e) == nil {
+					received <- e
+					return
+				}
+			}
+		}
+		close(received)
+	}()
+
+	select {
+	case e, ok := <-received:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return events.Event{}
+}
+
+func TestV1Events(t *testing.T) {
+	ts, _ := newTestServer(t)
+	url := ts.URL + "/v1/events?type=" + string(events.JobPublished)
+
+	stream := openEventStream(t, url, "")
+	var first jobsResponse
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &first)
+	e := stream.next(t)
+	if e.Type != events.JobPublished || e.JobId != first.Jobs[0].Id {
+		t.Fatalf("got %+v", e)
+	}
+	stream.resp.Body.Close()
+
+	// A client reconnecting with the id of the last event it received gets
+	// what it missed.
+	var second jobsResponse
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &second)
+	resumed := openEventStream(t, url, strconv.FormatUint(e.Id, 10))
+	if e := resumed.next(t); e.JobId != second.Jobs[0].Id {
+		t.Errorf("resumed with %+v", e)
+	}
+}
+
+func TestV1EventsReplayOnlyAfterKnownIds(t *testing.T) {
+	ts, _ := newTestServer(t)
+	url := ts.URL + "/v1/events?type=" + string(events.JobPublished)
+
+	do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, nil)
+
+	// Neither a new client nor one with an id this daemon never published,
+	// e.g. from before a restart, gets the events published before.
+	for _, lastEventId := range []string{"", "1000"} {
+		stream := openEventStream(t, url, lastEventId)
+		var created jobsResponse
+		do(t, http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &created)
+		if e := stream.next(t); e.JobId != created.Jobs[0].Id {
+			t.Errorf("last event id %q: got %+v first", lastEventId, e)
+		}
+		stream.resp.Body.Close()
+	}
+}
+
+func TestV1EventsOfOtherOwners(t *testing.T) {
+	ts, _ := newAuthTestServer(t, testTokens(t), nil)
+	url := ts.URL + "/v1/events?type=" + string(events.JobPublished)
+
+	stream := openEventStreamAs(t, "user-token", url, "")
+	admin := openEventStreamAs(t, "admin-token", url, "")
+
+	var others, own jobsResponse
+	doAs(t, "admin-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &others)
+	doAs(t, "user-token", http.MethodPost, ts.URL+"/v1/jobs", `{"command": ["true"]}`, &own)
+
+	if e := stream.next(t); e.JobId != own.Jobs[0].Id || e.Owner != "rei" {
+		t.Errorf("user got %+v first, want only their own job", e)
+	}
+	if e := admin.next(t); e.JobId != others.Jobs[0].Id {
+		t.Errorf("admin got %+v first", e)
+	}
+}
+
+func TestV1EventsInvalidLastEventId(t *testing.T) {
+	ts, _ := newTestServer(t)
+
+	var body types.ErrorResponse
+	resp := do(t, http.MethodGet, ts.URL+"/v1/events?last_event_id=x", "", &body)
+	if resp.StatusCode != http.StatusBadRequest || body.Error.Code != codeInvalidRequest {
+		t.Errorf("got %d %+v", resp.StatusCode, body)
+	}
+}
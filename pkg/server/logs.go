@@ -0,0 +1,93 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/logs"
+)
+
+// handleV1JobLogs writes the output kept for a job. With follow set the
+// response is streamed in chunks until the job terminates or the client goes
+// away.
+func (s *Server) handleV1JobLogs(w http.ResponseWriter, r *http.Request, id string) {
+	query := r.URL.Query()
+
+	follow := false
+	if value := query.Get("follow"); len(value) != 0 {
+		var err error
+		if follow, err = strconv.ParseBool(value); err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "invalid follow "+value)
+			return
+		}
+	}
+
+	job, err := s.schedular.Get(id)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+	if !s.authorize(w, r, auth.ActionInspect, "logs of job "+id, job.Owner) {
+		return
+	}
+
+	var tail *logs.Buffer
+	switch stream := query.Get("stream"); stream {
+	case "", "stdout":
+		tail = job.StdoutTail
+	case "stderr":
+		tail = job.StderrTail
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "unknown stream "+stream)
+		return
+	}
+	if tail == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "no output kept for job "+id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	var offset int64
+	for {
+		data, next, wait, closed := tail.Read(offset)
+		offset = next
+		if len(data) != 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if !follow || closed {
+			return
+		}
+
+		select {
+		case <-wait:
+		case <-r.Context().Done():
+			return
+		case <-s.shutdown:
+			return
+		}
+	}
+}
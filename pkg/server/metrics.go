@@ -0,0 +1,111 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/gpu"
+	"github.com/Shikugawa/gpupipe/pkg/metrics"
+	"github.com/Shikugawa/gpupipe/pkg/process"
+	"github.com/Shikugawa/gpupipe/pkg/scheduler"
+)
+
+func newMetricsRegistry(sched *scheduler.Scheduler) *metrics.Registry {
+	r := metrics.NewRegistry()
+
+	r.AddGauge("gpupipe_queue_jobs", "Number of queued jobs by state.", func() []metrics.Sample {
+		counts := make(map[process.ProcessState]int)
+		for _, p := range sched.List() {
+			counts[p.ProcessState]++
+		}
+
+		var samples []metrics.Sample
+		for state := process.Pending; state <= process.Cancelled; state++ {
+			if state == process.CanSpawn {
+				continue
+			}
+			samples = append(samples, metrics.Sample{
+				Labels: []metrics.Label{{Name: "state", Value: process.ProcessStateToString(state)}},
+				Value:  float64(counts[state]),
+			})
+		}
+		return samples
+	})
+
+	m := sched.Metrics
+	r.AddCounter("gpupipe_jobs_published_total", "Jobs published.", m.Published)
+	r.AddCounter("gpupipe_jobs_started_total", "Jobs spawned.", m.Started)
+	r.AddCounter("gpupipe_jobs_finished_total", "Jobs which finished successfully.", m.Finished)
+	r.AddCounter("gpupipe_jobs_failed_total", "Jobs which exited with an error.", m.Failed)
+	r.AddCounter("gpupipe_jobs_cancelled_total", "Jobs which were cancelled.", m.Cancelled)
+	r.AddCounter("gpupipe_spawn_errors_total", "Failures to start the command of a job.", m.SpawnErrors)
+	r.AddHistogram("gpupipe_scheduling_latency_seconds", "Time from publishing a job to spawning it.", m.SchedulingLatency)
+
+	broker := sched.Watcher.Broker
+	gpuGauge := func(value func(info *gpu.GpuInfo) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			infos, _ := broker.Latest()
+			samples := make([]metrics.Sample, len(infos))
+			for i := range infos {
+				samples[i] = metrics.Sample{
+					Labels: []metrics.Label{
+						{Name: "gpu", Value: strconv.Itoa(infos[i].Index)},
+						{Name: "uuid", Value: infos[i].Uuid},
+						{Name: "name", Value: infos[i].Name},
+					},
+					Value: value(&infos[i]),
+				}
+			}
+			return samples
+		}
+	}
+	r.AddGauge("gpupipe_gpu_utilization_percent", "GPU utilization reported by nvidia-smi.",
+		gpuGauge(func(info *gpu.GpuInfo) float64 { return float64(info.GpuUsage) }))
+	r.AddGauge("gpupipe_gpu_memory_utilization_percent", "GPU memory utilization reported by nvidia-smi.",
+		gpuGauge(func(info *gpu.GpuInfo) float64 { return float64(info.MemoryUsage) }))
+	r.AddGauge("gpupipe_gpu_memory_used_bytes", "GPU memory in use.",
+		gpuGauge(func(info *gpu.GpuInfo) float64 { return float64(info.MemoryUsed) * (1 << 20) }))
+	r.AddGauge("gpupipe_gpu_memory_total_bytes", "Total GPU memory.",
+		gpuGauge(func(info *gpu.GpuInfo) float64 { return float64(info.TotalMemory) * (1 << 20) }))
+	r.AddGauge("gpupipe_gpu_telemetry_age_seconds", "Time since GPUs were last sampled successfully.", func() []metrics.Sample {
+		_, sampled := broker.Latest()
+		if sampled.IsZero() {
+			return nil
+		}
+		return []metrics.Sample{{Value: time.Since(sampled).Seconds()}}
+	})
+
+	agent := sched.Watcher
+	r.AddHistogram("gpupipe_nvidia_smi_duration_seconds", "Time taken to sample GPUs with nvidia-smi.", agent.SampleDuration)
+	r.AddCounter("gpupipe_nvidia_smi_errors_total", "Failures to sample GPUs with nvidia-smi.", agent.SampleErrors)
+
+	return r
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := s.metrics.Write(w); err != nil {
+		log.Println(err)
+	}
+}
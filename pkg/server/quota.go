@@ -0,0 +1,42 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+)
+
+// handleV1Quota reports the quota of the user given by ?user=, or of the
+// sender of the request.
+func (s *Server) handleV1Quota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	user := r.URL.Query().Get("user")
+	if len(user) == 0 {
+		identity, ok := auth.FromContext(r.Context())
+		if !ok {
+			writeError(w, http.StatusBadRequest, codeInvalidRequest, "user is required when gpiped does not authenticate requests")
+			return
+		}
+		user = identity.User
+	}
+
+	writeJSON(w, http.StatusOK, s.schedular.Quota(user))
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+)
+
+// handleReload reloads the configuration of the daemon and tells what
+// changed. Nothing is applied if the new configuration is invalid.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if !s.authorize(w, r, auth.ActionAdmin, "configuration", "") {
+		return
+	}
+	if s.reload == nil {
+		writeError(w, http.StatusNotFound, codeNotFound, "reloading is not supported")
+		return
+	}
+
+	res, err := s.reload()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidRequest, "failed to reload: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
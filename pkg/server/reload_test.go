@@ -0,0 +1,76 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/types"
+)
+
+func TestReload(t *testing.T) {
+	s := newTestAPI(t, testTokens(t), nil)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+
+	if resp := doAs(t, "admin-token", http.MethodPost, ts.URL+"/admin/reload", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("without a reloader: got %d", resp.StatusCode)
+	}
+
+	want := types.ReloadResponse{Applied: []string{"quotas"}, RestartRequired: []string{"listen"}}
+	var err error
+	s.SetReloader(func() (types.ReloadResponse, error) { return want, err })
+
+	if resp := doAs(t, "user-token", http.MethodPost, ts.URL+"/admin/reload", "", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("reload by a user: got %d", resp.StatusCode)
+	}
+
+	var got types.ReloadResponse
+	if resp := doAs(t, "admin-token", http.MethodPost, ts.URL+"/admin/reload", "", &got); resp.StatusCode != http.StatusOK {
+		t.Fatalf("reload by an admin: got %d", resp.StatusCode)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	err = errors.New("invalid configuration")
+	if resp := doAs(t, "admin-token", http.MethodPost, ts.URL+"/admin/reload", "", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reload of an invalid configuration: got %d", resp.StatusCode)
+	}
+}
+
+func TestSetTokens(t *testing.T) {
+	s := newTestAPI(t, testTokens(t), nil)
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+
+	tokens, err := auth.NewTokens([]auth.TokenEntry{{User: "rei", Role: auth.RoleUser, Token: "new-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetTokens(tokens)
+
+	if resp := doAs(t, "user-token", http.MethodGet, ts.URL+"/v1/jobs", "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("replaced token: got %d", resp.StatusCode)
+	}
+	if resp := doAs(t, "new-token", http.MethodGet, ts.URL+"/v1/jobs", "", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("new token: got %d", resp.StatusCode)
+	}
+}
@@ -15,86 +15,226 @@
 package server
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
+	"sync"
 
+	"github.com/Shikugawa/gpupipe/pkg/auth"
+	"github.com/Shikugawa/gpupipe/pkg/metrics"
+	"github.com/Shikugawa/gpupipe/pkg/notify"
+	"github.com/Shikugawa/gpupipe/pkg/process"
 	"github.com/Shikugawa/gpupipe/pkg/scheduler"
 	"github.com/Shikugawa/gpupipe/pkg/types"
 )
 
 type Server struct {
 	schedular *scheduler.Scheduler
+	notifier  *notify.Notifier
+	metrics   *metrics.Registry
+	// tokens authenticates requests. Authentication of requests over TCP is
+	// disabled if it is nil, those on the Unix socket are always identified.
+	tokensMu sync.RWMutex
+	tokens   *auth.Tokens
+	audit    *auth.AuditLog
+	// reload, if set, reloads the configuration of the daemon.
+	reload func() (types.ReloadResponse, error)
+	// shutdown is closed when the http.Server shuts down so that streaming
+	// responses end instead of holding up the shutdown.
+	shutdown chan struct{}
 }
 
+// The handlers below serve the unversioned API used by older gpipectl. New
+// clients should use the /v1/ API.
+
 func (e *Server) handlePublish(w http.ResponseWriter, r *http.Request) {
-	var request types.ProcessPublishRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Failed to decode request body", http.StatusInternalServerError)
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
 	}
 
-	if request.MemoryUsageLowWatermark > 100 {
-		request.MemoryUsageLowWatermark = 100
+	var request types.ProcessPublishRequest
+	if !decodeRequest(w, r, &request) {
+		return
 	}
 
-	if request.MemoryUsageLowWatermark < 0 {
-		request.MemoryUsageLowWatermark = 0
-	}
+	clampMemoryUsageLowWatermark(&request)
+	setOwner(r, &request)
 
-	if err := e.schedular.Publish(&request); err != nil {
-		http.Error(w, "Failed to publish process", http.StatusInternalServerError)
+	processes, err := e.schedular.Publish(&request)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, http.StatusAccepted, map[string][]process.Process{"processes": processes})
 }
 
 func (e *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
 	var request types.ProcessDeleteRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Failed to decode request body", http.StatusInternalServerError)
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	job, err := e.schedular.Get(request.Id)
+	if err != nil {
+		writeSchedulerError(w, err)
+		return
+	}
+	if !e.authorize(w, r, auth.ActionModify, "job "+request.Id, job.Owner) {
+		return
 	}
 
 	if !e.schedular.Delete(request.Id) {
-		http.Error(w, "Failed to publish process", http.StatusInternalServerError)
+		writeError(w, http.StatusNotFound, codeNotFound, "unknown process "+request.Id)
+		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
 
 func (e *Server) handleList(w http.ResponseWriter, r *http.Request) {
-	b, err := e.schedular.List()
-	if err != nil {
-		http.Error(w, "Failed to fetch process", http.StatusInternalServerError)
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, http.MethodGet)
+		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Write(b)
+	owner, ok := mineFilter(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]process.Process{"processes": redact(r, filterOwner(e.schedular.List(), owner))})
+}
+
+func clampMemoryUsageLowWatermark(request *types.ProcessPublishRequest) {
+	if request.MemoryUsageLowWatermark > 100 {
+		request.MemoryUsageLowWatermark = 100
+	}
+
+	if request.MemoryUsageLowWatermark < 0 {
+		request.MemoryUsageLowWatermark = 0
+	}
+}
+
+// setOwner records who published request. The user claimed by the client is
+// replaced as well, so that it can be trusted once requests are
+// authenticated.
+func setOwner(r *http.Request, request *types.ProcessPublishRequest) {
+	if identity, ok := auth.FromContext(r.Context()); ok {
+		request.Owner = identity.User
+		request.User = identity.User
+	}
+}
+
+// filterOwner returns the processes owned by owner, or all of them if owner
+// is empty.
+func filterOwner(processes []process.Process, owner string) []process.Process {
+	if len(owner) == 0 {
+		return processes
+	}
+
+	filtered := []process.Process{}
+	for _, p := range processes {
+		if p.Owner == owner {
+			filtered = append(filtered, p)
+		}
+	}
+	return filtered
 }
 
-func (s *Server) Start(port string) *http.Server {
+// Listeners are where Start serves the API. Each is disabled by leaving it
+// empty.
+type Listeners struct {
+	// Port is the TCP port, served over TLS if TLS is set.
+	Port string
+	TLS  *tls.Config
+	// Socket is the path of a Unix socket.
+	Socket string
+}
+
+// handler routes every endpoint behind authentication.
+func (s *Server) handler() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/publish", s.handlePublish)
 	mux.HandleFunc("/list", s.handleList)
 	mux.HandleFunc("/delete", s.handleDelete)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/admin/reload", s.handleReload)
+	s.registerV1(mux)
+
+	return s.authenticate(mux)
+}
 
+func (s *Server) Start(listeners Listeners) *http.Server {
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Handler:     s.handler(),
+		ConnContext: s.connContext,
 	}
-
-	go func() {
-		log.Println("Admin server started...")
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalln("Server closed with error:", err)
+	srv.RegisterOnShutdown(func() {
+		close(s.shutdown)
+	})
+
+	if len(listeners.Port) != 0 {
+		l, err := net.Listen("tcp", ":"+listeners.Port)
+		if err != nil {
+			log.Fatalln("Failed to listen:", err)
+		}
+		if listeners.TLS != nil {
+			l = tls.NewListener(l, listeners.TLS)
+		}
+		go s.serve(srv, l)
+	}
+	if len(listeners.Socket) != 0 {
+		l, err := listenUnix(listeners.Socket)
+		if err != nil {
+			log.Fatalln("Failed to listen:", err)
 		}
-	}()
+		go s.serve(srv, l)
+	}
 
 	return srv
 }
 
-func NewServer(s *scheduler.Scheduler) *Server {
+func (s *Server) serve(srv *http.Server, l net.Listener) {
+	log.Println("Admin server started on", l.Addr())
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		log.Fatalln("Server closed with error:", err)
+	}
+}
+
+// SetTokens replaces the tokens requests are authenticated with.
+func (s *Server) SetTokens(tokens *auth.Tokens) {
+	s.tokensMu.Lock()
+	defer s.tokensMu.Unlock()
+	s.tokens = tokens
+}
+
+func (s *Server) currentTokens() *auth.Tokens {
+	s.tokensMu.RLock()
+	defer s.tokensMu.RUnlock()
+	return s.tokens
+}
+
+// SetReloader serves POST /admin/reload by calling reload.
+func (s *Server) SetReloader(reload func() (types.ReloadResponse, error)) {
+	s.reload = reload
+}
+
+func NewServer(s *scheduler.Scheduler, n *notify.Notifier, tokens *auth.Tokens, audit *auth.AuditLog) *Server {
 	return &Server{
 		schedular: s,
+		notifier:  n,
+		tokens:    tokens,
+		audit:     audit,
+		metrics:   newMetricsRegistry(s),
+		shutdown:  make(chan struct{}),
 	}
 }
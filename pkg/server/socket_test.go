@@ -0,0 +1,102 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(file); err == nil {
+		t.Error("a regular file was replaced by the socket")
+	}
+
+	path := filepath.Join(dir, "gpupipe.sock")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != socketMode {
+		t.Errorf("socket has mode %v, %v", fi.Mode(), err)
+	}
+	if _, err := listenUnix(path); err == nil {
+		t.Error("the socket of a running server was replaced")
+	}
+
+	// A socket nobody listens on any more is stale and replaced.
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listenUnix(path)
+	if err != nil {
+		t.Fatalf("stale socket was not replaced: %v", err)
+	}
+	l.Close()
+}
+
+func TestUnixSocketPeerIdentity(t *testing.T) {
+	s := newTestAPI(t, testTokens(t), nil)
+	path := filepath.Join(t.TempDir(), "gpupipe.sock")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: s.handler(), ConnContext: s.connContext}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}
+
+	// No token is needed, the caller is who the kernel says it is.
+	resp, err := client.Post("http://gpiped/v1/jobs", "application/json", strings.NewReader(`{"command": ["true"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	jobs := s.schedular.List()
+	if len(jobs) != 1 || jobs[0].Owner != name {
+		t.Errorf("got jobs %+v, want one owned by %s", jobs, name)
+	}
+}
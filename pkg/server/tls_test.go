@@ -0,0 +1,234 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA signs certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	ca := &testCA{}
+	ca.cert, ca.key, ca.pem = issue(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "gpupipe test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	return ca
+}
+
+// issue signs template by ca, or self-signs it if ca is nil.
+func issue(t *testing.T, template *x509.Certificate, ca *testCA) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	parent, signer := template, key
+	if ca != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// keyPair issues a certificate for template and writes it with its key to
+// PEM files in dir.
+func (ca *testCA) keyPair(t *testing.T, dir, name string, template *x509.Certificate) (certFile, keyFile string) {
+	t.Helper()
+
+	_, key, certPEM := issue(t, template, ca)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// clientOf returns a client trusting ca which presents the certificate of
+// user, if any.
+func (ca *testCA) clientOf(t *testing.T, user string) *http.Client {
+	t.Helper()
+
+	config := &tls.Config{RootCAs: x509.NewCertPool()}
+	config.RootCAs.AddCert(ca.cert)
+	if len(user) != 0 {
+		dir := t.TempDir()
+		certFile, keyFile := ca.keyPair(t, dir, user, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: user},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// newTLSTestServer serves an API with testTokens over TLS, requiring client
+// certificates signed by the returned CA.
+func newTLSTestServer(t *testing.T) (*httptest.Server, *testCA) {
+	t.Helper()
+
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.keyPair(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "gpiped"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestAPI(t, testTokens(t), nil)
+	ts := httptest.NewUnstartedServer(s.handler())
+	ts.TLS = config
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts, ca
+}
+
+func TestClientCertificateIdentity(t *testing.T) {
+	ts, ca := newTLSTestServer(t)
+
+	for _, c := range []struct {
+		user  string
+		token string
+	}{
+		{user: "rei"},
+		// Users without a token are users too.
+		{user: "stranger"},
+		// The certificate takes precedence over a bearer token.
+		{user: "rei", token: "admin-token"},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/jobs", strings.NewReader(`{"command": ["true"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.token) != 0 {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := ca.clientOf(t, c.user).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var created jobsResponse
+		err = json.NewDecoder(resp.Body).Decode(&created)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("%+v: got %d, %v", c, resp.StatusCode, err)
+		}
+		if owner := created.Jobs[0].Owner; owner != c.user {
+			t.Errorf("%+v: got owner %q", c, owner)
+		}
+	}
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	ts, ca := newTLSTestServer(t)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/jobs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer admin-token")
+	if resp, err := ca.clientOf(t, "").Do(req); err == nil {
+		resp.Body.Close()
+		t.Errorf("served a client without a certificate: got %d", resp.StatusCode)
+	}
+
+	// Neither is a certificate signed by another CA accepted.
+	if resp, err := newTestCA(t).clientOf(t, "rei").Get(ts.URL + "/v1/jobs"); err == nil {
+		resp.Body.Close()
+		t.Errorf("served a client of another CA: got %d", resp.StatusCode)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.keyPair(t, dir, "server", &x509.Certificate{Subject: pkix.Name{CommonName: "gpiped"}})
+
+	config, err := NewTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Errorf("client certificates required without client CAs: %v", config.ClientAuth)
+	}
+
+	notPEM := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTLSConfig(certFile, keyFile, notPEM); err == nil {
+		t.Error("accepted client CAs without certificates")
+	}
+	if _, err := NewTLSConfig(certFile, filepath.Join(dir, "missing.key"), ""); err == nil {
+		t.Error("accepted a missing key")
+	}
+}
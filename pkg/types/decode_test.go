@@ -0,0 +1,90 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	var r ProcessPublishRequest
+	if err := DecodeStrict([]byte(`{"command": ["true"], "target_gpu": [0]}`), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{
+		`{"command": ["true"], "target_gpus": [0]}`,
+		`{"command": ["true"]} {"command": ["false"]}`,
+		`{"command": "true"}`,
+	} {
+		if err := DecodeStrict([]byte(body), &r); err == nil {
+			t.Errorf("%s was decoded", body)
+		}
+	}
+}
+
+// jsonFields returns the JSON names of the fields of a struct type.
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(name) != 0 && name != "-" {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// TestSchemaMatchesRequest keeps the published JSON Schema in sync with
+// ProcessPublishRequest.
+func TestSchemaMatchesRequest(t *testing.T) {
+	var schema struct {
+		Properties map[string]struct {
+			Items struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"items"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(ProcessPublishRequestSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	var properties []string
+	for name := range schema.Properties {
+		// extends is resolved by gpipectl and never reaches the daemon.
+		if name != "extends" {
+			properties = append(properties, name)
+		}
+	}
+	sort.Strings(properties)
+
+	if want := jsonFields(reflect.TypeOf(ProcessPublishRequest{})); !reflect.DeepEqual(properties, want) {
+		t.Errorf("schema has properties %v, ProcessPublishRequest has %v", properties, want)
+	}
+
+	var dependencyProperties []string
+	for name := range schema.Properties["depends_on"].Items.Properties {
+		dependencyProperties = append(dependencyProperties, name)
+	}
+	sort.Strings(dependencyProperties)
+	if want := jsonFields(reflect.TypeOf(Dependency{})); !reflect.DeepEqual(dependencyProperties, want) {
+		t.Errorf("schema has dependency properties %v, Dependency has %v", dependencyProperties, want)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import "fmt"
+
+type DependencyCondition string
+
+const (
+	AfterSuccess DependencyCondition = "after_success"
+	AfterAny     DependencyCondition = "after_any"
+	AfterFailure DependencyCondition = "after_failure"
+)
+
+// Dependency makes a job wait for another job, referenced by its id or name.
+// An empty condition means AfterSuccess.
+type Dependency struct {
+	Job       string              `json:"job"`
+	Condition DependencyCondition `json:"condition,omitempty"`
+}
+
+func (d *Dependency) Validate() error {
+	if len(d.Job) == 0 {
+		return fmt.Errorf("dependency must specify job id or name")
+	}
+
+	switch d.Condition {
+	case "", AfterSuccess, AfterAny, AfterFailure:
+		return nil
+	default:
+		return fmt.Errorf("unknown dependency condition %q", d.Condition)
+	}
+}
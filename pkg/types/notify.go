@@ -0,0 +1,221 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInternalWebhook is returned for webhooks which point at the host of the
+// daemon or its private network, which jobs must not be able to reach
+// through it.
+var ErrInternalWebhook = errors.New("webhook url points to an internal host")
+
+// NotifyEvent is a point in the lifecycle of a job that can be notified.
+type NotifyEvent string
+
+const (
+	NotifyPublished NotifyEvent = "job.published"
+	NotifyStarted   NotifyEvent = "job.started"
+	NotifyFinished  NotifyEvent = "job.finished"
+	NotifyFailed    NotifyEvent = "job.failed"
+	NotifyCancelled NotifyEvent = "job.cancelled"
+)
+
+// DefaultNotifyEvents are notified when no events are selected.
+var DefaultNotifyEvents = []NotifyEvent{NotifyFinished, NotifyFailed}
+
+func ValidateNotifyEvent(e NotifyEvent) error {
+	switch e {
+	case NotifyPublished, NotifyStarted, NotifyFinished, NotifyFailed, NotifyCancelled:
+		return nil
+	default:
+		return fmt.Errorf("unknown notify event %q", e)
+	}
+}
+
+// WebhookFormat is the shape of the body posted to a webhook.
+type WebhookFormat string
+
+const (
+	// WebhookJSON posts the event together with the job. It is the default.
+	WebhookJSON    WebhookFormat = "json"
+	WebhookSlack   WebhookFormat = "slack"
+	WebhookDiscord WebhookFormat = "discord"
+)
+
+// Notify selects who is told about a job.
+type Notify struct {
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// Email opts in to email when the job terminates. It is only sent if
+	// the daemon is configured with an SMTP server.
+	Email *EmailNotify `json:"email,omitempty"`
+}
+
+type EmailNotify struct {
+	// To defaults to the user who submitted the job. Addresses other than
+	// theirs and those at the domain of the daemon are dropped.
+	To []string `json:"to,omitempty"`
+	// Events defaults to DefaultNotifyEvents and may only select the
+	// terminal events job.finished, job.failed and job.cancelled.
+	Events []NotifyEvent `json:"events,omitempty"`
+}
+
+type Webhook struct {
+	Url string `json:"url"`
+	// Events defaults to DefaultNotifyEvents.
+	Events []NotifyEvent `json:"events,omitempty"`
+	Format WebhookFormat `json:"format,omitempty"`
+}
+
+func (n *Notify) Validate() error {
+	for i := range n.Webhooks {
+		if err := n.Webhooks[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	if n.Email != nil {
+		if err := n.Email.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EmailNotify) Validate() error {
+	for _, to := range e.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return fmt.Errorf("invalid email address %q", to)
+		}
+	}
+
+	for _, event := range e.Events {
+		switch event {
+		case NotifyFinished, NotifyFailed, NotifyCancelled:
+		default:
+			return fmt.Errorf("email can not be sent on %q", event)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether e is one of the events of the email.
+func (e *EmailNotify) Selects(event NotifyEvent) bool {
+	return selectsEvent(e.Events, event)
+}
+
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid webhook url %q", w.Url)
+	}
+
+	for _, e := range w.Events {
+		if err := ValidateNotifyEvent(e); err != nil {
+			return err
+		}
+	}
+
+	switch w.Format {
+	case "", WebhookJSON, WebhookSlack, WebhookDiscord:
+	default:
+		return fmt.Errorf("unknown webhook format %q", w.Format)
+	}
+
+	if InternalHost(u.Hostname()) {
+		return fmt.Errorf("%w: %q", ErrInternalWebhook, RedactUrl(w.Url))
+	}
+	return nil
+}
+
+// InternalHost reports whether host names the local host or is a loopback,
+// link-local, private or unspecified address. Names are not resolved.
+func InternalHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && InternalIP(ip)
+}
+
+// InternalIP reports whether ip is a loopback, link-local, private or
+// unspecified address.
+func InternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified()
+}
+
+// RedactUrl returns the scheme and host of rawUrl. The rest of the url is
+// left out since chat webhooks carry their credentials in the path.
+func RedactUrl(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// Redacted returns a copy of n whose webhook urls are reduced to their
+// scheme and host.
+func (n *Notify) Redacted() *Notify {
+	redacted := *n
+	redacted.Webhooks = make([]Webhook, len(n.Webhooks))
+	for i, hook := range n.Webhooks {
+		hook.Url = RedactUrl(hook.Url)
+		redacted.Webhooks[i] = hook
+	}
+	return &redacted
+}
+
+// Selects reports whether e is one of the events of the webhook.
+func (w *Webhook) Selects(e NotifyEvent) bool {
+	return selectsEvent(w.Events, e)
+}
+
+func selectsEvent(events []NotifyEvent, e NotifyEvent) bool {
+	if len(events) == 0 {
+		events = DefaultNotifyEvents
+	}
+	for _, selected := range events {
+		if selected == e {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery records the attempts to post one event to one webhook.
+type WebhookDelivery struct {
+	Id    string      `json:"id"`
+	Event NotifyEvent `json:"event"`
+	JobId string      `json:"job_id"`
+	// Target is the scheme and host of the webhook. The rest of the url is
+	// left out since chat webhooks carry their credentials in the path.
+	Target          string     `json:"target"`
+	Attempts        int        `json:"attempts"`
+	StatusCode      int        `json:"status_code,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	Delivered       bool       `json:"delivered"`
+	CreatedTime     time.Time  `json:"created_time"`
+	LastAttemptTime *time.Time `json:"last_attempt_time,omitempty"`
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import "testing"
+
+func TestWebhookValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		webhook Webhook
+		valid   bool
+	}{
+		{"https", Webhook{Url: "https://hooks.example.com/x"}, true},
+		{"events and format", Webhook{Url: "http://example.com", Events: []NotifyEvent{NotifyStarted}, Format: WebhookSlack}, true},
+		{"no scheme", Webhook{Url: "example.com/x"}, false},
+		{"other scheme", Webhook{Url: "ftp://example.com"}, false},
+		{"no host", Webhook{Url: "https:///x"}, false},
+		{"unknown event", Webhook{Url: "https://example.com", Events: []NotifyEvent{"job.paused"}}, false},
+		{"unknown format", Webhook{Url: "https://example.com", Format: "teams"}, false},
+		{"localhost", Webhook{Url: "http://localhost:8080/x"}, false},
+		{"loopback", Webhook{Url: "http://127.0.0.1/x"}, false},
+		{"loopback v6", Webhook{Url: "http://[::1]/x"}, false},
+		{"link-local", Webhook{Url: "http://169.254.169.254/latest/meta-data"}, false},
+		{"private", Webhook{Url: "https://10.0.0.8/x"}, false},
+		{"unspecified", Webhook{Url: "http://0.0.0.0/x"}, false},
+		{"public address", Webhook{Url: "https://203.0.113.7/x"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.webhook.Validate(); (err == nil) != tc.valid {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookSelects(t *testing.T) {
+	defaults := Webhook{}
+	if !defaults.Selects(NotifyFailed) || defaults.Selects(NotifyStarted) {
+		t.Errorf("default events are not %v", DefaultNotifyEvents)
+	}
+
+	started := Webhook{Events: []NotifyEvent{NotifyStarted}}
+	if !started.Selects(NotifyStarted) || started.Selects(NotifyFailed) {
+		t.Errorf("selected events %v are not honored", started.Events)
+	}
+}
+
+func TestEmailNotifyValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		email EmailNotify
+		valid bool
+	}{
+		{"defaults", EmailNotify{}, true},
+		{"recipients", EmailNotify{To: []string{"shimizu@example.com", "Rei <rei@example.com>"}}, true},
+		{"terminal events", EmailNotify{Events: []NotifyEvent{NotifyFinished, NotifyFailed, NotifyCancelled}}, true},
+		{"invalid recipient", EmailNotify{To: []string{"shimizu"}}, false},
+		{"started", EmailNotify{Events: []NotifyEvent{NotifyStarted}}, false},
+		{"published", EmailNotify{Events: []NotifyEvent{NotifyPublished}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.email.Validate(); (err == nil) != tc.valid {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
@@ -14,11 +14,45 @@
 
 package types
 
+import "fmt"
+
 type ProcessPublishRequest struct {
-	RootPath                string   `json:"rootpath"`
-	Command                 []string `json:"command"`
-	TargetGpu               []int    `json:"target_gpu"`
-	LogPath                 string   `json:"log_path"`
-	ErrLogPath              string   `json:"err_log_path"`
-	MemoryUsageLowWatermark int      `json:"memory_usage_low_watermark"`
+	// Id is optional. When given, it must be a UUID not used by any queued
+	// job; with a sweep it becomes the array id.
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	// User is who submitted the job.
+	User string `json:"user,omitempty"`
+	// Owner is the authenticated user who published the job. It is set by
+	// gpiped and can not be given by clients.
+	Owner                   string            `json:"-"`
+	RootPath                string            `json:"rootpath"`
+	Command                 []string          `json:"command"`
+	TargetGpu               []int             `json:"target_gpu"`
+	LogPath                 string            `json:"log_path"`
+	ErrLogPath              string            `json:"err_log_path"`
+	MemoryUsageLowWatermark int               `json:"memory_usage_low_watermark"`
+	DependsOn               []Dependency      `json:"depends_on,omitempty"`
+	Env                     map[string]string `json:"env,omitempty"`
+	Sweep                   *Sweep            `json:"sweep,omitempty"`
+	Notify                  *Notify           `json:"notify,omitempty"`
+}
+
+func (r *ProcessPublishRequest) Validate() error {
+	if len(r.Command) == 0 || len(r.Command[0]) == 0 {
+		return fmt.Errorf("command must not be empty")
+	}
+
+	for _, dep := range r.DependsOn {
+		if err := dep.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if r.Notify != nil {
+		if err := r.Notify.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,24 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+// ReloadResponse tells what reloading the daemon configuration changed.
+type ReloadResponse struct {
+	// Applied are the settings which changed and are in effect now.
+	Applied []string `json:"applied"`
+	// RestartRequired are the settings which changed but only take effect
+	// when gpiped is restarted.
+	RestartRequired []string `json:"restart_required"`
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SweepMode string
+
+const (
+	// SweepGrid runs every combination of the parameter values.
+	SweepGrid SweepMode = "grid"
+	// SweepList runs exactly the parameter sets listed in Values.
+	SweepList SweepMode = "list"
+	// SweepRandom runs Count combinations picked at random from the grid.
+	SweepRandom SweepMode = "random"
+)
+
+// maxSweepSize bounds how many jobs a single sweep may expand to.
+const maxSweepSize = 1024
+
+// SweepValue is a parameter value. It may be written as a JSON string or
+// number; numbers keep their original spelling, so 2e-3 stays 2e-3.
+type SweepValue string
+
+func (v *SweepValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = SweepValue(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("sweep value must be a string or a number: %s", string(b))
+	}
+	*v = SweepValue(n.String())
+	return nil
+}
+
+// Sweep expands one task definition into an array of jobs. Every ${name}
+// in command, log_path, err_log_path and env values is replaced by the value
+// of the parameter, and ${index} by the position of the job in the array.
+type Sweep struct {
+	Mode       SweepMode               `json:"mode"`
+	Parameters map[string][]SweepValue `json:"parameters,omitempty"`
+	Values     []map[string]SweepValue `json:"values,omitempty"`
+	Count      int                     `json:"count,omitempty"`
+	Seed       int64                   `json:"seed,omitempty"`
+}
+
+// Expand returns the parameter sets of every job in the sweep.
+func (s *Sweep) Expand() ([]map[string]string, error) {
+	switch s.Mode {
+	case SweepList:
+		if len(s.Values) == 0 {
+			return nil, fmt.Errorf("list sweep has no values")
+		}
+		if len(s.Values) > maxSweepSize {
+			return nil, fmt.Errorf("sweep expands to more than %d jobs", maxSweepSize)
+		}
+
+		var sets []map[string]string
+		for _, values := range s.Values {
+			set := make(map[string]string, len(values))
+			for k, v := range values {
+				set[k] = string(v)
+			}
+			sets = append(sets, set)
+		}
+		return sets, nil
+	case SweepGrid, SweepRandom:
+		grid, err := s.grid()
+		if err != nil {
+			return nil, err
+		}
+		if s.Mode == SweepGrid {
+			return grid, nil
+		}
+
+		if s.Count <= 0 {
+			return nil, fmt.Errorf("random sweep needs a positive count")
+		}
+		if s.Count > len(grid) {
+			return nil, fmt.Errorf("random sweep count %d exceeds %d combinations", s.Count, len(grid))
+		}
+		r := rand.New(rand.NewSource(s.Seed))
+		r.Shuffle(len(grid), func(i, j int) { grid[i], grid[j] = grid[j], grid[i] })
+		return grid[:s.Count], nil
+	default:
+		return nil, fmt.Errorf("unknown sweep mode %q", s.Mode)
+	}
+}
+
+// grid returns the cartesian product of the parameters. Parameter names are
+// sorted so the order of jobs does not depend on map iteration.
+func (s *Sweep) grid() ([]map[string]string, error) {
+	if len(s.Parameters) == 0 {
+		return nil, fmt.Errorf("%s sweep has no parameters", s.Mode)
+	}
+
+	var names []string
+	size := 1
+	for name, values := range s.Parameters {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("sweep parameter %s has no values", name)
+		}
+		size *= len(values)
+		if size > maxSweepSize {
+			return nil, fmt.Errorf("sweep expands to more than %d jobs", maxSweepSize)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sets := []map[string]string{{}}
+	for _, name := range names {
+		var next []map[string]string
+		for _, set := range sets {
+			for _, v := range s.Parameters[name] {
+				expanded := make(map[string]string, len(set)+1)
+				for k, value := range set {
+					expanded[k] = value
+				}
+				expanded[name] = string(v)
+				next = append(next, expanded)
+			}
+		}
+		sets = next
+	}
+	return sets, nil
+}
+
+// Instantiate returns a copy of r for the index-th parameter set of its
+// sweep, with every placeholder substituted.
+func (r *ProcessPublishRequest) Instantiate(index int, params map[string]string) ProcessPublishRequest {
+	pairs := []string{"${index}", strconv.Itoa(index)}
+	for k, v := range params {
+		pairs = append(pairs, "${"+k+"}", v)
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	instance := *r
+	instance.Id = ""
+	instance.Sweep = nil
+	instance.Command = make([]string, len(r.Command))
+	for i, unit := range r.Command {
+		instance.Command[i] = replacer.Replace(unit)
+	}
+	instance.LogPath = replacer.Replace(r.LogPath)
+	instance.ErrLogPath = replacer.Replace(r.ErrLogPath)
+	if r.Env != nil {
+		instance.Env = make(map[string]string, len(r.Env))
+		for k, v := range r.Env {
+			instance.Env[k] = replacer.Replace(v)
+		}
+	}
+	return instance
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSweepValueKeepsNumberSpelling(t *testing.T) {
+	var values []SweepValue
+	if err := json.Unmarshal([]byte(`["adam", 2e-3, 0.10, 32]`), &values); err != nil {
+		t.Fatal(err)
+	}
+	want := []SweepValue{"adam", "2e-3", "0.10", "32"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+
+	if err := json.Unmarshal([]byte(`[true]`), &values); err == nil {
+		t.Error("a boolean sweep value was accepted")
+	}
+}
+
+func TestSweepExpand(t *testing.T) {
+	grid := map[string][]SweepValue{"lr": {"0.1", "0.01"}, "bs": {"32", "64"}}
+
+	for _, tc := range []struct {
+		name  string
+		sweep Sweep
+		want  []map[string]string
+	}{
+		{
+			name:  "grid in parameter name order",
+			sweep: Sweep{Mode: SweepGrid, Parameters: grid},
+			want: []map[string]string{
+				{"bs": "32", "lr": "0.1"},
+				{"bs": "32", "lr": "0.01"},
+				{"bs": "64", "lr": "0.1"},
+				{"bs": "64", "lr": "0.01"},
+			},
+		},
+		{
+			name: "list",
+			sweep: Sweep{Mode: SweepList, Values: []map[string]SweepValue{
+				{"lr": "0.1", "bs": "32"},
+				{"lr": "0.01"},
+			}},
+			want: []map[string]string{{"bs": "32", "lr": "0.1"}, {"lr": "0.01"}},
+		},
+	} {
+		got, err := tc.sweep.Expand()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSweepExpandRandom(t *testing.T) {
+	sweep := Sweep{
+		Mode:       SweepRandom,
+		Parameters: map[string][]SweepValue{"lr": {"0.1", "0.01", "0.001"}, "bs": {"32", "64"}},
+		Count:      3,
+		Seed:       7,
+	}
+
+	first, err := sweep.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sweep.Expand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 3 {
+		t.Fatalf("got %d parameter sets, want 3", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("the same seed picked %v and %v", first, second)
+	}
+
+	seen := make(map[string]bool)
+	for _, set := range first {
+		key := fmt.Sprint(set)
+		if seen[key] {
+			t.Errorf("%v was picked twice", set)
+		}
+		seen[key] = true
+	}
+}
+
+func TestSweepExpandErrors(t *testing.T) {
+	tooLarge := make([]SweepValue, maxSweepSize+1)
+	for i := range tooLarge {
+		tooLarge[i] = SweepValue(fmt.Sprint(i))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		sweep Sweep
+	}{
+		{"unknown mode", Sweep{Mode: "bayesian"}},
+		{"grid without parameters", Sweep{Mode: SweepGrid}},
+		{"parameter without values", Sweep{Mode: SweepGrid, Parameters: map[string][]SweepValue{"lr": nil}}},
+		{"list without values", Sweep{Mode: SweepList}},
+		{"random without count", Sweep{Mode: SweepRandom, Parameters: map[string][]SweepValue{"lr": {"1"}}}},
+		{"random count over grid", Sweep{Mode: SweepRandom, Parameters: map[string][]SweepValue{"lr": {"1"}}, Count: 2}},
+		{"too many jobs", Sweep{Mode: SweepGrid, Parameters: map[string][]SweepValue{"seed": tooLarge}}},
+	} {
+		if _, err := tc.sweep.Expand(); err == nil {
+			t.Errorf("%s: expanded", tc.name)
+		}
+	}
+}
+
+func TestInstantiate(t *testing.T) {
+	r := ProcessPublishRequest{
+		Command: []string{"python", "train.py", "--lr", "${lr}"},
+		LogPath: "/tmp/train-${index}.log",
+		Env:     map[string]string{"RUN": "lr-${lr}"},
+		Sweep:   &Sweep{Mode: SweepGrid},
+	}
+
+	instance := r.Instantiate(3, map[string]string{"lr": "0.1"})
+	if instance.Sweep != nil {
+		t.Error("the instance still has a sweep")
+	}
+	if want := []string{"python", "train.py", "--lr", "0.1"}; !reflect.DeepEqual(instance.Command, want) {
+		t.Errorf("got command %v, want %v", instance.Command, want)
+	}
+	if instance.LogPath != "/tmp/train-3.log" {
+		t.Errorf("got log path %s", instance.LogPath)
+	}
+	if instance.Env["RUN"] != "lr-0.1" {
+		t.Errorf("got env %v", instance.Env)
+	}
+	if r.Command[3] != "${lr}" || r.Env["RUN"] != "lr-${lr}" {
+		t.Error("instantiating modified the request")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WorkflowPublishRequest submits several named steps at once. Dependencies
+// between steps refer to step names; they may also refer to ids of jobs which
+// are already queued.
+type WorkflowPublishRequest struct {
+	// Id is optional. When given, it must be a UUID not used by another
+	// workflow.
+	Id    string                  `json:"id,omitempty"`
+	Name  string                  `json:"name"`
+	Steps []ProcessPublishRequest `json:"steps"`
+	// Owner is set by gpiped like ProcessPublishRequest.Owner.
+	Owner string `json:"-"`
+}
+
+func (r *WorkflowPublishRequest) Validate() error {
+	if len(r.Steps) == 0 {
+		return fmt.Errorf("workflow has no steps")
+	}
+
+	names := make(map[string]bool)
+	for _, step := range r.Steps {
+		if len(step.Name) == 0 {
+			return fmt.Errorf("every workflow step must have a name")
+		}
+		if names[step.Name] {
+			return fmt.Errorf("duplicated workflow step %s", step.Name)
+		}
+		names[step.Name] = true
+
+		if len(step.Id) != 0 {
+			return fmt.Errorf("workflow step %s can't have an id", step.Name)
+		}
+		if step.Sweep != nil {
+			return fmt.Errorf("workflow step %s can't have a sweep", step.Name)
+		}
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("workflow step %s: %v", step.Name, err)
+		}
+	}
+	return r.validateOrder()
+}
+
+// validateOrder rejects steps which depend on themselves, directly or through
+// other steps, since they would never run.
+func (r *WorkflowPublishRequest) validateOrder() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	upstream := make(map[string][]string)
+	for _, step := range r.Steps {
+		state[step.Name] = unvisited
+	}
+	for _, step := range r.Steps {
+		for _, dep := range step.DependsOn {
+			if dep.Job == step.Name {
+				return fmt.Errorf("workflow step %s depends on itself", step.Name)
+			}
+			if _, ok := state[dep.Job]; ok {
+				upstream[step.Name] = append(upstream[step.Name], dep.Job)
+			}
+		}
+	}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			for path[0] != name {
+				path = path[1:]
+			}
+			return fmt.Errorf("workflow steps depend on each other in a cycle: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, next := range upstream[name] {
+			if err := visit(next, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, step := range r.Steps {
+		if err := visit(step.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type WorkflowStepStatus struct {
+	Name  string `json:"name"`
+	Id    string `json:"id"`
+	State string `json:"state"`
+}
+
+type WorkflowStatus struct {
+	Id    string               `json:"id"`
+	Name  string               `json:"name"`
+	Owner string               `json:"owner,omitempty"`
+	State string               `json:"state"`
+	Steps []WorkflowStepStatus `json:"steps"`
+}
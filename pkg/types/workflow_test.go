@@ -0,0 +1,65 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWorkflowPublishRequestValidate(t *testing.T) {
+	step := func(name string, dependsOn ...string) ProcessPublishRequest {
+		r := ProcessPublishRequest{Name: name, Command: []string{"true"}}
+		for _, job := range dependsOn {
+			r.DependsOn = append(r.DependsOn, Dependency{Job: job})
+		}
+		return r
+	}
+
+	for _, tc := range []struct {
+		name  string
+		steps []ProcessPublishRequest
+		valid bool
+	}{
+		{"no steps", nil, false},
+		{"unnamed step", []ProcessPublishRequest{step("a"), step("")}, false},
+		{"duplicated step", []ProcessPublishRequest{step("a"), step("a")}, false},
+		{"step with id", []ProcessPublishRequest{step("a"), {Name: "b", Id: "0f8fad5b-d9cb-469f-a165-70867728950e"}}, false},
+		{"step with sweep", []ProcessPublishRequest{step("a"), {Name: "b", Sweep: &Sweep{Mode: SweepGrid}}}, false},
+		{"distinct steps", []ProcessPublishRequest{step("a"), step("b")}, true},
+		{"self-dependency", []ProcessPublishRequest{step("a", "a")}, false},
+		{"cycle", []ProcessPublishRequest{step("a", "c"), step("b", "a"), step("c", "b")}, false},
+		{"cycle after a step", []ProcessPublishRequest{step("a"), step("b", "a", "c"), step("c", "b")}, false},
+		{"diamond", []ProcessPublishRequest{step("a"), step("b", "a"), step("c", "a"), step("d", "b", "c")}, true},
+		{"dependency on a job", []ProcessPublishRequest{step("a", "0f8fad5b-d9cb-469f-a165-70867728950e")}, true},
+	} {
+		r := WorkflowPublishRequest{Name: "w", Steps: tc.steps}
+		if err := r.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
+
+func TestWorkflowCycleError(t *testing.T) {
+	r := WorkflowPublishRequest{Name: "w", Steps: []ProcessPublishRequest{
+		{Name: "a", Command: []string{"true"}},
+		{Name: "b", Command: []string{"true"}, DependsOn: []Dependency{{Job: "a"}, {Job: "c"}}},
+		{Name: "c", Command: []string{"true"}, DependsOn: []Dependency{{Job: "b"}}},
+	}}
+	err := r.Validate()
+	if err == nil || !strings.HasSuffix(err.Error(), ": b -> c -> b") {
+		t.Errorf("got %v, want the steps of the cycle", err)
+	}
+}
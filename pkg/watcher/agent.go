@@ -18,28 +18,109 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Shikugawa/gpupipe/pkg/events"
 	"github.com/Shikugawa/gpupipe/pkg/gpu"
+	"github.com/Shikugawa/gpupipe/pkg/metrics"
 )
 
+// SampleDurationBuckets are the bucket bounds, in seconds, of
+// Agent.SampleDuration.
+var SampleDurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
 type Agent struct {
 	gpuInfoRequestInterval time.Duration
+	Broker                 *Broker
+	// Events, if set, receives GPU free/busy and telemetry health events.
+	Events *events.Bus
+	// BusyMemoryUsage is the memory utilization in percent above which a
+	// GPU is reported busy.
+	BusyMemoryUsage int
+	// SampleDuration observes how long sampling GPUs takes, in seconds,
+	// and SampleErrors counts the samples which failed.
+	SampleDuration *metrics.Histogram
+	SampleErrors   *metrics.Counter
+	refresh        chan struct{}
+
+	busy     map[int]bool
+	degraded bool
 }
 
 func NewAgent(requestInterval int) *Agent {
 	return &Agent{
 		gpuInfoRequestInterval: time.Duration(requestInterval) * time.Second,
+		Broker:                 NewBroker(),
+		SampleDuration:         metrics.NewHistogram(SampleDurationBuckets),
+		SampleErrors:           metrics.NewCounter(),
+		refresh:                make(chan struct{}, 1),
+	}
+}
+
+// Refresh asks the agent to sample GPUs right away instead of waiting for the
+// rest of the current interval. It never blocks.
+func (w *Agent) Refresh() {
+	select {
+	case w.refresh <- struct{}{}:
+	default:
 	}
 }
 
-func (w *Agent) Run(ch chan<- []gpu.GpuInfo) {
+func (w *Agent) Run() {
 	for {
+		start := time.Now()
 		infos, err := gpu.GetGpuInfo()
+		w.SampleDuration.Observe(time.Since(start).Seconds())
 		if err != nil {
+			w.SampleErrors.Inc()
 			fmt.Println(err)
+		} else {
+			w.Broker.Publish(infos)
+		}
+		w.publishEvents(infos, err)
+
+		select {
+		case <-time.After(w.gpuInfoRequestInterval):
+		case <-w.refresh:
+		}
+	}
+}
+
+// publishEvents reports GPUs whose busy state changed since the last sample
+// and changes in whether sampling works at all.
+func (w *Agent) publishEvents(infos []gpu.GpuInfo, err error) {
+	if w.Events == nil {
+		return
+	}
+
+	if err != nil {
+		if !w.degraded {
+			w.degraded = true
+			w.Events.Publish(events.Event{Type: events.TelemetryDegraded, Message: err.Error()})
+		}
+		return
+	}
+	if w.degraded {
+		w.degraded = false
+		w.Events.Publish(events.Event{Type: events.TelemetryRecovered})
+	}
+
+	if w.busy == nil {
+		w.busy = make(map[int]bool)
+	}
+	for _, info := range infos {
+		busy := info.MemoryUsage > w.BusyMemoryUsage
+		if previous, ok := w.busy[info.Index]; ok && previous == busy {
 			continue
 		}
+		w.busy[info.Index] = busy
 
-		ch <- infos
-		time.Sleep(w.gpuInfoRequestInterval)
+		t := events.GpuFree
+		if busy {
+			t = events.GpuBusy
+		}
+		w.Events.Publish(events.Event{
+			Type:    t,
+			GpuId:   []int{info.Index},
+			Message: fmt.Sprintf("memory utilization %d%%", info.MemoryUsage),
+		})
 	}
 }
@@ -0,0 +1,63 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Shikugawa/gpupipe/pkg/events"
+	"github.com/Shikugawa/gpupipe/pkg/gpu"
+)
+
+func TestAgentPublishesChanges(t *testing.T) {
+	bus := events.NewBus(0)
+	w := NewAgent(1)
+	w.Events = bus
+	w.BusyMemoryUsage = 50
+
+	w.publishEvents([]gpu.GpuInfo{{Index: 0, MemoryUsage: 10}, {Index: 1, MemoryUsage: 90}}, nil)
+	w.publishEvents([]gpu.GpuInfo{{Index: 0, MemoryUsage: 20}, {Index: 1, MemoryUsage: 90}}, nil)
+	w.publishEvents(nil, errors.New("nvidia-smi failed"))
+	w.publishEvents(nil, errors.New("nvidia-smi failed"))
+	w.publishEvents([]gpu.GpuInfo{{Index: 0, MemoryUsage: 60}, {Index: 1, MemoryUsage: 90}}, nil)
+
+	sub, published := bus.Subscribe(0, 1)
+	bus.Unsubscribe(sub)
+
+	// Only the first sample of each GPU and changes are reported.
+	want := []struct {
+		t   events.Type
+		gpu int
+	}{
+		{events.GpuFree, 0},
+		{events.GpuBusy, 1},
+		{events.TelemetryDegraded, -1},
+		{events.TelemetryRecovered, -1},
+		{events.GpuBusy, 0},
+	}
+	if len(published) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(published), len(want), published)
+	}
+	for i, w := range want {
+		e := published[i]
+		if e.Type != w.t {
+			t.Errorf("event %d is %s, want %s", i, e.Type, w.t)
+		}
+		if w.gpu >= 0 && (len(e.GpuId) != 1 || e.GpuId[0] != w.gpu) {
+			t.Errorf("event %d is about GPUs %v, want %d", i, e.GpuId, w.gpu)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/gpu"
+)
+
+// DropPolicy decides what happens when a subscriber's buffer is full.
+type DropPolicy int
+
+const (
+	// Block keeps every snapshot until the subscriber has room for it. The
+	// snapshots are sent from a goroutine of the subscriber, so that a slow
+	// one only holds up itself, and queue up while it lags behind.
+	Block DropPolicy = iota
+	// DropOldest discards the oldest buffered snapshot to make room.
+	DropOldest
+	// DropNewest discards the snapshot being published.
+	DropNewest
+)
+
+type Subscription struct {
+	C <-chan []gpu.GpuInfo
+
+	id      int
+	ch      chan []gpu.GpuInfo
+	policy  DropPolicy
+	dropped uint64
+
+	// done is closed by Unsubscribe to release a blocked sender. sendMu is
+	// held while a snapshot is sent on ch, so that ch is never closed under
+	// a sender.
+	done   chan struct{}
+	sendMu sync.Mutex
+
+	// queue holds the snapshots a Block subscriber has yet to receive.
+	// queued wakes up deliver, which closes stopped when it returns.
+	queueMu sync.Mutex
+	queue   [][]gpu.GpuInfo
+	queued  chan struct{}
+	stopped chan struct{}
+}
+
+// Dropped returns the number of snapshots this subscriber has missed.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Broker fans GPU telemetry out to any number of subscribers and keeps the
+// latest snapshot around for consumers that only want to query it.
+type Broker struct {
+	// subMu guards the subscriber set. It is not held while snapshots are
+	// sent, so that Subscribe and Unsubscribe never wait for a subscriber.
+	subMu       sync.Mutex
+	nextId      int
+	subscribers map[int]*Subscription
+
+	mu         sync.RWMutex
+	latest     []gpu.GpuInfo
+	latestTime time.Time
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[int]*Subscription),
+	}
+}
+
+// Subscribe registers a new consumer. bufferSize of 0 makes the channel
+// unbuffered, which only makes sense together with Block.
+func (b *Broker) Subscribe(bufferSize int, policy DropPolicy) *Subscription {
+	if bufferSize < 0 {
+		bufferSize = 0
+	}
+	if bufferSize == 0 && policy == DropOldest {
+		bufferSize = 1
+	}
+
+	ch := make(chan []gpu.GpuInfo, bufferSize)
+	sub := &Subscription{
+		C:      ch,
+		ch:     ch,
+		policy: policy,
+		done:   make(chan struct{}),
+	}
+	if policy == Block {
+		sub.queued = make(chan struct{}, 1)
+		sub.stopped = make(chan struct{})
+		go sub.deliver()
+	}
+
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
+
+	sub.id = b.nextId
+	b.nextId++
+	b.subscribers[sub.id] = sub
+	return sub
+}
+
+// Unsubscribe removes the subscriber and closes its channel.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.subMu.Lock()
+	if _, ok := b.subscribers[sub.id]; !ok {
+		b.subMu.Unlock()
+		return
+	}
+	delete(b.subscribers, sub.id)
+	b.subMu.Unlock()
+
+	close(sub.done)
+	if sub.policy == Block {
+		<-sub.stopped
+		return
+	}
+	sub.sendMu.Lock()
+	close(sub.ch)
+	sub.sendMu.Unlock()
+}
+
+func (b *Broker) Publish(infos []gpu.GpuInfo) {
+	b.mu.Lock()
+	b.latest = infos
+	b.latestTime = time.Now()
+	b.mu.Unlock()
+
+	b.subMu.Lock()
+	subs := make([]*Subscription, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		subs = append(subs, sub)
+	}
+	b.subMu.Unlock()
+
+	for _, sub := range subs {
+		sub.send(infos)
+	}
+}
+
+func (s *Subscription) send(infos []gpu.GpuInfo) {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.policy {
+	case Block:
+		s.queueMu.Lock()
+		s.queue = append(s.queue, infos)
+		s.queueMu.Unlock()
+
+		select {
+		case s.queued <- struct{}{}:
+		default:
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.ch <- infos:
+				return
+			default:
+				select {
+				case <-s.ch:
+					atomic.AddUint64(&s.dropped, 1)
+				default:
+				}
+			}
+		}
+	case DropNewest:
+		select {
+		case s.ch <- infos:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// deliver sends the queued snapshots of a Block subscriber in order until it
+// is unsubscribed, and then closes its channel.
+func (s *Subscription) deliver() {
+	defer close(s.stopped)
+	defer close(s.ch)
+
+	for {
+		select {
+		case <-s.queued:
+		case <-s.done:
+			return
+		}
+
+		for {
+			s.queueMu.Lock()
+			if len(s.queue) == 0 {
+				s.queueMu.Unlock()
+				break
+			}
+			infos := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.queueMu.Unlock()
+
+			select {
+			case s.ch <- infos:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// Latest returns the most recent snapshot and when it was taken. The time is
+// zero if nothing has been published yet.
+func (b *Broker) Latest() ([]gpu.GpuInfo, time.Time) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.latest, b.latestTime
+}
@@ -0,0 +1,72 @@
+// Copyright 2021 Rei Shimizu
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shikugawa/gpupipe/pkg/gpu"
+)
+
+func TestPublishToBlockedSubscriber(t *testing.T) {
+	b := NewBroker()
+	blocked := b.Subscribe(0, Block)
+	latest := b.Subscribe(1, DropOldest)
+
+	// Publish does not wait for a subscriber which does not receive.
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			b.Publish([]gpu.GpuInfo{{Index: i}})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish is held up by a blocked subscriber")
+	}
+	select {
+	case infos := <-latest.C:
+		if infos[0].Index != 2 {
+			t.Errorf("got snapshot %d, want the latest", infos[0].Index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the snapshot")
+	}
+
+	// The blocked subscriber still gets every snapshot in order.
+	for i := 0; i < 3; i++ {
+		select {
+		case infos := <-blocked.C:
+			if infos[0].Index != i {
+				t.Errorf("got snapshot %d, want %d", infos[0].Index, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for snapshot %d", i)
+		}
+	}
+
+	// Unsubscribing drops what is still queued and closes C.
+	b.Publish([]gpu.GpuInfo{{Index: 3}})
+	b.Unsubscribe(blocked)
+	if _, ok := <-blocked.C; ok {
+		t.Error("C of the unsubscribed subscriber is not closed")
+	}
+	if blocked.Dropped() != 0 {
+		t.Errorf("blocked subscriber dropped %d snapshots", blocked.Dropped())
+	}
+}